@@ -0,0 +1,70 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/zcash/lightwalletd/common"
+)
+
+type healthReply struct {
+	Status string `json:"status"` // "ok", "degraded" (zcashd unreachable), or "halted"
+	Height int    `json:"height"` // latest cached block, -1 if none
+	Reason string `json:"reason,omitempty"`
+}
+
+// healthHandler reports whether block ingestion is working; the cached
+// blocks are served in either case, so this is for alerting, not routing.
+func healthHandler(cache *common.BlockCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := healthReply{
+			Status: "ok",
+			Height: cache.GetLatestHeight(),
+			Reason: common.IngestorHalted(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if common.ZcashdUnavailable() {
+			reply.Status = "degraded"
+		}
+		if reply.Reason != "" {
+			reply.Status = "halted"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(&reply)
+	}
+}
+
+// rewindHandler discards cached blocks above the "height" form value and
+// restarts ingestion from there.
+func rewindHandler(cache *common.BlockCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		height, err := strconv.Atoi(r.FormValue("height"))
+		if err != nil || height < cache.GetFirstHeight() {
+			http.Error(w, "invalid height", http.StatusBadRequest)
+			return
+		}
+		common.RewindIngestor(cache, height)
+		healthHandler(cache)(w, r)
+	}
+}
+
+// resyncHandler discards the entire cache and re-ingests from the beginning.
+func resyncHandler(cache *common.BlockCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		common.RewindIngestor(cache, cache.GetFirstHeight()-1)
+		healthHandler(cache)(w, r)
+	}
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/zcash/lightwalletd/common"
+	"github.com/zcash/lightwalletd/frontend"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Verify, repair, export or import the block cache",
+	Long: `Verify, repair, export or import the block cache in the data
+directory, or fill it from zcashd's block files; lightwalletd must not
+be running.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// These flags (rather than lightwalletd's) give these settings,
+		// or else the config file does.
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			viper.BindPFlag(flag.Name, flag)
+		})
+	},
+}
+
+// cacheVerifyCmd represents the cache verify command
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check every block in the cache",
+	Long: `Check every block in the cache: its checksum and length, that it
+decodes, that it's at the right height (with no gaps) and that it follows
+the block before it. The heights of any bad blocks are reported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if report := verifyCache(); len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// cacheRepairCmd represents the cache repair command
+var cacheRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Repair the bad blocks in the cache",
+	Long: `Verify the cache, then remove the first bad block and all the blocks
+after it, or (with --refetch) fetch only the bad blocks from zcashd.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report := verifyCache()
+		if len(report.Problems) == 0 {
+			return
+		}
+		refetch := viper.GetBool("refetch")
+		if refetch {
+			connectZcashd()
+		}
+		if err := common.RepairCache(viper.GetString("cache-storage"), filepath.Join(viper.GetString("data-dir"), "db"),
+			viper.GetString("chain"), report, refetch); err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't repair the cache")
+		}
+		fmt.Println("Repaired; verifying again")
+		if report := verifyCache(); len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// cacheExportCmd represents the cache export command
+var cacheExportCmd = &cobra.Command{
+	Use:   "export FILE",
+	Short: "Write a snapshot of the cache to a file",
+	Long: `Write a checksummed snapshot of the cached blocks, from --start to
+--end (by default, all of them), to a file, which cache import can
+use to seed a new data directory.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Create(args[0])
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't create the snapshot file")
+		}
+		header, err := common.ExportCache(viper.GetString("cache-storage"),
+			filepath.Join(viper.GetString("data-dir"), "db"), viper.GetString("chain"),
+			viper.GetInt("start"), viper.GetInt("end"), f)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(args[0])
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't export the cache")
+		}
+		fmt.Printf("Exported blocks %d to %d, tip hash %s\n", header.First, header.Next-1,
+			hex.EncodeToString(parser.Reverse(header.TipHash)))
+	},
+}
+
+// cacheImportCmd represents the cache import command
+var cacheImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Seed the cache from a snapshot file",
+	Long: `Create the cache (in a data directory that doesn't have one for the
+snapshot's chain) from a snapshot that cache export wrote, checking
+that its blocks follow one another.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't open the snapshot file")
+		}
+		defer f.Close()
+		dbPath := filepath.Join(viper.GetString("data-dir"), "db")
+		if err := os.MkdirAll(dbPath, 0755); err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't create the db directory")
+		}
+		header, err := common.ImportCache(viper.GetString("cache-storage"), dbPath, f)
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't import the cache")
+		}
+		fmt.Printf("Imported %s blocks %d to %d, tip hash %s\n", header.ChainName, header.First, header.Next-1,
+			hex.EncodeToString(parser.Reverse(header.TipHash)))
+	},
+}
+
+// cacheImportBlocksCmd represents the cache import-blocks command
+var cacheImportBlocksCmd = &cobra.Command{
+	Use:   "import-blocks BLOCKS_DIR",
+	Short: "Fill the cache from zcashd's block files",
+	Long: `Add the blocks of the best chain in zcashd's blk*.dat files, in its
+blocks directory, to the cache, from the cache's next height (or the
+Sapling activation height, for a new cache). Neither zcashd nor
+lightwalletd need to be running; --chain isn't used, the files say which
+chain they are.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		idx, err := common.IndexBlkFiles(args[0])
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't read the block files")
+		}
+		saplingHeight := viper.GetInt("sapling-height")
+		if saplingHeight == 0 {
+			saplingHeight = saplingActivationHeights[idx.ChainName]
+		}
+		if saplingHeight == 0 {
+			common.Log.WithFields(logrus.Fields{
+				"chain": idx.ChainName,
+			}).Fatal("--sapling-height is needed for this chain")
+		}
+		common.CacheStorage = viper.GetString("cache-storage")
+		common.CacheCompression = viper.GetString("cache-compression")
+		common.CompactBlockHeaders = viper.GetBool("compact-block-headers")
+		dbPath := filepath.Join(viper.GetString("data-dir"), "db")
+		if err := os.MkdirAll(dbPath, 0755); err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't create the db directory")
+		}
+		cache := common.NewBlockCache(dbPath, idx.ChainName, saplingHeight, false)
+		if err := common.CheckCompactBlockHeaders(dbPath, idx.ChainName, cache); err != nil {
+			cache.Close()
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("compact-block-headers doesn't match the cache")
+		}
+		n, err := idx.ImportInto(cache)
+		cache.Sync()
+		cache.Close()
+		if err == nil {
+			err = common.WriteCacheMetadata(dbPath, &common.CacheMetadata{
+				ChainName:           idx.ChainName,
+				SaplingHeight:       saplingHeight,
+				CompactBlockHeaders: common.CompactBlockHeaders,
+			})
+		}
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't import the blocks")
+		}
+		fmt.Printf("Imported %d %s blocks, the cache has heights %d to %d\n", n, idx.ChainName,
+			cache.GetFirstHeight(), cache.GetNextHeight()-1)
+	},
+}
+
+// saplingActivationHeights are the chains' Sapling activation heights, for
+// cache import-blocks (regtest's depends on how zcashd is run).
+var saplingActivationHeights = map[string]int{
+	"main": 419200,
+	"test": 280000,
+}
+
+// verifyCache verifies the cache and prints the results.
+func verifyCache() *common.CacheReport {
+	report, err := common.VerifyCache(viper.GetString("cache-storage"),
+		filepath.Join(viper.GetString("data-dir"), "db"), viper.GetString("chain"))
+	if err != nil {
+		common.Log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("couldn't verify the cache")
+	}
+	fmt.Printf("Checked %d blocks, heights %d to %d\n", report.Next-report.First, report.First, report.Next-1)
+	for _, p := range report.Problems {
+		fmt.Printf("Bad block at height %d: %s\n", p.Height, p.Reason)
+	}
+	if len(report.Problems) == 0 {
+		fmt.Println("No problems found")
+	}
+	return report
+}
+
+// connectZcashd sets up the RPC connection to zcashd, checking that it's
+// on the cache's chain.
+func connectZcashd() {
+	connCfg, err := frontend.ZcashdConnConfig(optionsFromViper())
+	var rpcClient *rpcclient.Client
+	if err == nil {
+		rpcClient, _, err = frontend.NewZRPCFromConnConfig(connCfg)
+	}
+	if err != nil {
+		common.Log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("setting up RPC connection to zcashd")
+	}
+	common.RawRequest = rpcClient.RawRequest
+	getLightdInfo, err := common.GetLightdInfo()
+	if err != nil {
+		common.Log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("getting information from zcashd")
+	}
+	if getLightdInfo.ChainName != viper.GetString("chain") {
+		common.Log.WithFields(logrus.Fields{
+			"chain":        viper.GetString("chain"),
+			"zcashd_chain": getLightdInfo.ChainName,
+		}).Fatal("zcashd is on a different chain")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
+	cacheCmd.AddCommand(cacheRepairCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+	cacheCmd.AddCommand(cacheImportBlocksCmd)
+	cacheCmd.PersistentFlags().String("data-dir", "/var/lib/lightwalletd", "data directory (such as db)")
+	cacheCmd.PersistentFlags().String("cache-storage", "flat", "how the blocks are stored: flat (files) or bbolt (database)")
+	cacheCmd.PersistentFlags().String("chain", "main", "the chain of the cache: main, test or regtest")
+	cacheRepairCmd.Flags().Bool("refetch", false, "fetch the bad blocks from zcashd, rather than removing them and the blocks after them")
+	cacheRepairCmd.Flags().String("zcash-conf-path", "./zcash.conf", "conf file to pull RPC creds from")
+	cacheRepairCmd.Flags().String("rpcuser", "", "RPC user name")
+	cacheRepairCmd.Flags().String("rpcpassword", "", "RPC password")
+	cacheRepairCmd.Flags().String("rpchost", "", "RPC host")
+	cacheRepairCmd.Flags().String("rpcport", "", "RPC host port")
+	cacheImportBlocksCmd.Flags().Int("sapling-height", 0, "height of the first block for a new cache (0 for the chain's Sapling activation height)")
+	cacheImportBlocksCmd.Flags().String("cache-compression", "none", "how new blocks are compressed: none or snappy")
+	cacheImportBlocksCmd.Flags().Bool("compact-block-headers", false, "include the full block header in compact blocks (as lightwalletd's compact-block-headers; it must match the cache's)")
+	cacheExportCmd.Flags().Int("start", 0, "height of the first block to export (0 for the first cached block)")
+	cacheExportCmd.Flags().Int("end", 0, "height of the last block to export (0 for the last cached block)")
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/zcash/lightwalletd/common"
+	"github.com/zcash/lightwalletd/frontend"
+)
+
+// reloader applies the settings that can change without a restart: the log
+// level and file, the TLS certificate and key, the zcashd RPC host and
+// credentials, and the zcashd backends. It's triggered by SIGHUP, or by a
+// change to the config file if --watch-config is set.
+type reloader struct {
+	mutex    sync.Mutex
+	opts     *common.Options // as currently applied
+	logFile  *os.File        // nil if logging to stderr
+	cert     *certificate    // nil unless the certificate is from files
+	zcashd   *zcashdClient   // nil unless using a single zcashd
+	backends *zcashdPool     // nil unless using zcashd-backends
+}
+
+// reloadConfig rereads the config file (if there is one) and applies the
+// new options. Called on SIGHUP.
+func (r *reloader) reloadConfig() {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+				"path":  viper.ConfigFileUsed(),
+			}).Error("couldn't reread config file, keeping the current configuration")
+			return
+		}
+	}
+	r.apply(optionsFromViper())
+}
+
+// apply reloads the options, logging the result.
+func (r *reloader) apply(opts *common.Options) {
+	if err := r.reload(opts); err != nil {
+		common.Log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("invalid configuration, keeping the current one")
+	}
+}
+
+// reload validates all of the reloadable options before applying any of
+// them, then logs what changed.
+func (r *reloader) reload(opts *common.Options) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if opts.LogLevel > uint64(logrus.TraceLevel) {
+		return fmt.Errorf("log-level %d is out of range", opts.LogLevel)
+	}
+	// Always reopen the log file, so that it can be rotated.
+	var logFile *os.File
+	if opts.LogFile != "" {
+		var err error
+		logFile, err = os.OpenFile(opts.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	var cert *tls.Certificate
+	if r.cert != nil {
+		newCert, err := tls.LoadX509KeyPair(opts.TLSCertPath, opts.TLSKeyPath)
+		if err != nil {
+			closeLogFile(logFile)
+			return errors.Wrap(err, "couldn't load TLS credentials")
+		}
+		cert = &newCert
+	}
+	var connCfg *rpcclient.ConnConfig
+	var rpcClient *rpcclient.Client
+	var batchClient *frontend.BatchClient
+	if r.zcashd != nil {
+		newConnCfg, err := frontend.ZcashdConnConfig(opts)
+		if err == nil && !r.zcashd.sameConn(newConnCfg) {
+			connCfg = newConnCfg
+			rpcClient, batchClient, err = frontend.NewZRPCFromConnConfig(connCfg)
+			if err == nil {
+				// Make sure the new host and credentials work.
+				if _, err = rpcClient.RawRequest("getblockchaininfo", []json.RawMessage{}); err != nil {
+					rpcClient.Shutdown()
+					err = errors.Wrap(err, "can't use the new zcashd RPC settings")
+				}
+			}
+		}
+		if err != nil {
+			closeLogFile(logFile)
+			return err
+		}
+	}
+	// Switching between a single zcashd and backends needs a restart.
+	var backends []*common.Backend
+	var backendClients map[string]*rpcclient.Client
+	changeBackends := r.backends != nil && len(opts.ZcashdBackends) > 0 &&
+		!reflect.DeepEqual(opts.ZcashdBackends, r.opts.ZcashdBackends)
+	if changeBackends {
+		var err error
+		backends, backendClients, err = r.backends.connect(opts.ZcashdBackends)
+		if err != nil {
+			closeLogFile(logFile)
+			return err
+		}
+	}
+
+	old := r.opts
+	if opts.LogLevel != old.LogLevel {
+		logger.SetLevel(logrus.Level(opts.LogLevel))
+		common.Log.WithFields(logrus.Fields{
+			"old": old.LogLevel,
+			"new": opts.LogLevel,
+		}).Info("changed log-level")
+	}
+	oldLogFile := r.logFile
+	setLogOutput(logFile)
+	r.logFile = logFile
+	closeLogFile(oldLogFile)
+	if opts.LogFile != old.LogFile {
+		common.Log.WithFields(logrus.Fields{
+			"old": old.LogFile,
+			"new": opts.LogFile,
+		}).Info("changed log-file")
+	}
+	if cert != nil && r.cert.set(cert) {
+		common.Log.WithFields(logrus.Fields{
+			"cert_file": opts.TLSCertPath,
+			"key_path":  opts.TLSKeyPath,
+		}).Info("changed TLS certificate")
+	}
+	if connCfg != nil {
+		oldConnCfg := r.zcashd.set(connCfg, rpcClient, batchClient)
+		common.Log.WithFields(logrus.Fields{
+			"old_host":         oldConnCfg.Host,
+			"new_host":         connCfg.Host,
+			"old_user":         oldConnCfg.User,
+			"new_user":         connCfg.User,
+			"password_changed": oldConnCfg.Pass != connCfg.Pass,
+		}).Info("changed zcashd RPC settings")
+	}
+	if changeBackends {
+		r.backends.set(opts.ZcashdBackends, backends, backendClients)
+		names := make([]string, len(backends))
+		for i, b := range backends {
+			names[i] = b.Name
+		}
+		common.Log.WithFields(logrus.Fields{
+			"backends": names,
+		}).Info("changed zcashd-backends")
+	}
+
+	applied := *old
+	applied.LogLevel = opts.LogLevel
+	applied.LogFile = opts.LogFile
+	applied.TLSCertPath = opts.TLSCertPath
+	applied.TLSKeyPath = opts.TLSKeyPath
+	applied.ZcashConfPath = opts.ZcashConfPath
+	applied.RPCUser = opts.RPCUser
+	applied.RPCPassword = opts.RPCPassword
+	applied.RPCHost = opts.RPCHost
+	applied.RPCPort = opts.RPCPort
+	if changeBackends {
+		applied.ZcashdBackends = opts.ZcashdBackends
+	}
+	if !reflect.DeepEqual(&applied, opts) {
+		common.Log.Warn("some of the changed settings take effect only after a restart")
+	}
+	r.opts = &applied
+	return nil
+}
+
+// setLogOutput sends the log to the file (as JSON), or to stderr if it's nil.
+func setLogOutput(logFile *os.File) {
+	if logFile == nil {
+		logger.SetOutput(os.Stderr)
+		logger.SetFormatter(textFormatter)
+		return
+	}
+	// instead write parsable logs for logstash/splunk/etc
+	logger.SetOutput(logFile)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+}
+
+func closeLogFile(logFile *os.File) {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
+// certificate is the server's TLS certificate, which can be replaced while
+// the server is running; connections made afterward get the new one.
+type certificate struct {
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+func loadCertificate(certPath, keyPath string) (*certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate{cert: &cert}, nil
+}
+
+// get implements tls.Config.GetCertificate.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+// set replaces the certificate, returning false if it's the same one.
+func (c *certificate) set(cert *tls.Certificate) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(cert.Certificate) == len(c.cert.Certificate) {
+		same := true
+		for i := range cert.Certificate {
+			same = same && bytes.Equal(cert.Certificate[i], c.cert.Certificate[i])
+		}
+		if same {
+			return false
+		}
+	}
+	c.cert = cert
+	return true
+}
+
+// zcashdClient sends requests to zcashd using the current connection
+// settings (common.RawRequest and common.RawBatchRequest point to it).
+type zcashdClient struct {
+	mutex   sync.RWMutex
+	connCfg *rpcclient.ConnConfig
+	client  *rpcclient.Client
+	batch   *frontend.BatchClient
+}
+
+var errNoZcashdClient = errors.New("no zcashd RPC client")
+
+// RawRequest implements common.RawRequest.
+func (z *zcashdClient) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	z.mutex.RLock()
+	client := z.client
+	z.mutex.RUnlock()
+	if client == nil {
+		return nil, errNoZcashdClient
+	}
+	return client.RawRequest(method, params)
+}
+
+// BatchRequest implements common.RawBatchRequest.
+func (z *zcashdClient) BatchRequest(requests []common.RPCRequest) ([]common.RPCReply, error) {
+	z.mutex.RLock()
+	batch := z.batch
+	z.mutex.RUnlock()
+	if batch == nil {
+		return nil, errNoZcashdClient
+	}
+	return batch.BatchRequest(requests)
+}
+
+func (z *zcashdClient) sameConn(connCfg *rpcclient.ConnConfig) bool {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return z.connCfg.Host == connCfg.Host && z.connCfg.User == connCfg.User && z.connCfg.Pass == connCfg.Pass
+}
+
+// set switches to the new clients, returning the previous settings.
+func (z *zcashdClient) set(connCfg *rpcclient.ConnConfig, client *rpcclient.Client, batch *frontend.BatchClient) *rpcclient.ConnConfig {
+	z.mutex.Lock()
+	oldConnCfg, oldClient := z.connCfg, z.client
+	z.connCfg, z.client, z.batch = connCfg, client, batch
+	z.mutex.Unlock()
+	if oldClient != nil {
+		// Let the requests in progress finish.
+		time.AfterFunc(time.Minute, oldClient.Shutdown)
+	}
+	return oldConnCfg
+}
+
+// zcashdPool is the pool of zcashd backends, with each backend's RPC client,
+// by its zcashd-backends entry.
+type zcashdPool struct {
+	pool     *common.BackendPool
+	backends map[string]*common.Backend
+	clients  map[string]*rpcclient.Client
+}
+
+func newZcashdPool() *zcashdPool {
+	return &zcashdPool{pool: common.NewBackendPool(nil)}
+}
+
+// uniqueSpecs returns the zcashd-backends entries in order, without any
+// repeats, so there's one backend (and client) for each.
+func uniqueSpecs(specs []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if !seen[spec] {
+			seen[spec] = true
+			unique = append(unique, spec)
+		}
+	}
+	return unique
+}
+
+// connect returns the backends for the given zcashd-backends entries,
+// reusing the current ones, and the RPC clients it created for the others.
+func (z *zcashdPool) connect(specs []string) ([]*common.Backend, map[string]*rpcclient.Client, error) {
+	specs = uniqueSpecs(specs)
+	backends := make([]*common.Backend, 0, len(specs))
+	clients := make(map[string]*rpcclient.Client)
+	for _, spec := range specs {
+		if b, ok := z.backends[spec]; ok {
+			backends = append(backends, b)
+			continue
+		}
+		rpcClient, batchClient, name, err := frontend.NewZRPCFromBackend(spec)
+		if err != nil {
+			for _, client := range clients {
+				client.Shutdown()
+			}
+			return nil, nil, errors.Wrap(err, "setting up RPC connection to zcashd backend")
+		}
+		b := &common.Backend{
+			Name:         name,
+			RawRequest:   rpcClient.RawRequest,
+			BatchRequest: batchClient.BatchRequest,
+		}
+		backends = append(backends, b)
+		clients[spec] = rpcClient
+	}
+	return backends, clients, nil
+}
+
+// set switches the pool to the backends (from connect), shutting down the
+// clients of the backends that are no longer in it.
+func (z *zcashdPool) set(specs []string, backends []*common.Backend, clients map[string]*rpcclient.Client) {
+	specs = uniqueSpecs(specs)
+	z.pool.SetBackends(backends)
+	newBackends := make(map[string]*common.Backend)
+	newClients := make(map[string]*rpcclient.Client)
+	for i, spec := range specs {
+		newBackends[spec] = backends[i]
+		if client, ok := z.clients[spec]; ok {
+			newClients[spec] = client
+		} else {
+			newClients[spec] = clients[spec]
+		}
+	}
+	for spec, client := range z.clients {
+		if _, ok := newClients[spec]; !ok {
+			// Let the requests in progress finish.
+			time.AfterFunc(time.Minute, client.Shutdown)
+		}
+	}
+	z.backends, z.clients = newBackends, newClients
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/fsnotify/fsnotify"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -31,6 +33,13 @@ import (
 var cfgFile string
 var logger = logrus.New()
 
+// textFormatter is for logging to stderr (when there's no log file).
+var textFormatter = &logrus.TextFormatter{
+	//DisableColors:          true,
+	FullTimestamp:          true,
+	DisableLevelTruncation: true,
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "lightwalletd",
@@ -38,27 +47,7 @@ var rootCmd = &cobra.Command{
 	Long: `Lightwalletd is a backend service that provides a 
          bandwidth-efficient interface to the Zcash blockchain`,
 	Run: func(cmd *cobra.Command, args []string) {
-		opts := &common.Options{
-			GRPCBindAddr:        viper.GetString("grpc-bind-addr"),
-			GRPCLogging:         viper.GetBool("grpc-logging-insecure"),
-			HTTPBindAddr:        viper.GetString("http-bind-addr"),
-			TLSCertPath:         viper.GetString("tls-cert"),
-			TLSKeyPath:          viper.GetString("tls-key"),
-			LogLevel:            viper.GetUint64("log-level"),
-			LogFile:             viper.GetString("log-file"),
-			ZcashConfPath:       viper.GetString("zcash-conf-path"),
-			RPCUser:             viper.GetString("rpcuser"),
-			RPCPassword:         viper.GetString("rpcpassword"),
-			RPCHost:             viper.GetString("rpchost"),
-			RPCPort:             viper.GetString("rpcport"),
-			NoTLSVeryInsecure:   viper.GetBool("no-tls-very-insecure"),
-			GenCertVeryInsecure: viper.GetBool("gen-cert-very-insecure"),
-			DataDir:             viper.GetString("data-dir"),
-			Redownload:          viper.GetBool("redownload"),
-			PingEnable:          viper.GetBool("ping-very-insecure"),
-			Darkside:            viper.GetBool("darkside-very-insecure"),
-			DarksideTimeout:     viper.GetUint64("darkside-timeout"),
-		}
+		opts := optionsFromViper()
 
 		common.Log.Debugf("Options: %#v\n", opts)
 
@@ -68,7 +57,7 @@ var rootCmd = &cobra.Command{
 		if !fileExists(opts.LogFile) {
 			os.OpenFile(opts.LogFile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 		}
-		if !opts.Darkside && (opts.RPCUser == "" || opts.RPCPassword == "" || opts.RPCHost == "" || opts.RPCPort == "") {
+		if !opts.Darkside && len(opts.ZcashdBackends) == 0 && (opts.RPCUser == "" || opts.RPCPassword == "" || opts.RPCHost == "" || opts.RPCPort == "") {
 			filesThatShouldExist = append(filesThatShouldExist, opts.ZcashConfPath)
 		}
 		if !opts.NoTLSVeryInsecure && !opts.GenCertVeryInsecure {
@@ -92,6 +81,86 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// optionsFromViper returns the options given by the flags, environment
+// and config file.
+func optionsFromViper() *common.Options {
+	return &common.Options{
+		GRPCBindAddr:        viper.GetString("grpc-bind-addr"),
+		GRPCLogging:         viper.GetBool("grpc-logging-insecure"),
+		HTTPBindAddr:        viper.GetString("http-bind-addr"),
+		TLSCertPath:         viper.GetString("tls-cert"),
+		TLSKeyPath:          viper.GetString("tls-key"),
+		LogLevel:            viper.GetUint64("log-level"),
+		LogFile:             viper.GetString("log-file"),
+		ZcashConfPath:       viper.GetString("zcash-conf-path"),
+		RPCUser:             viper.GetString("rpcuser"),
+		RPCPassword:         viper.GetString("rpcpassword"),
+		RPCHost:             viper.GetString("rpchost"),
+		RPCPort:             viper.GetString("rpcport"),
+		NoTLSVeryInsecure:   viper.GetBool("no-tls-very-insecure"),
+		GenCertVeryInsecure: viper.GetBool("gen-cert-very-insecure"),
+		DataDir:             viper.GetString("data-dir"),
+		Redownload:          viper.GetBool("redownload"),
+		PingEnable:          viper.GetBool("ping-very-insecure"),
+		Darkside:            viper.GetBool("darkside-very-insecure"),
+		DarksideTimeout:     viper.GetUint64("darkside-timeout"),
+		MaxReorgDepth:       viper.GetInt("max-reorg-depth"),
+		HTTPAdmin:           viper.GetBool("http-admin"),
+		ZcashdBackends:      viper.GetStringSlice("zcashd-backends"),
+		NodeType:            viper.GetString("node-type"),
+		ZcashdZmq:           viper.GetStringSlice("zcashd-zmq"),
+		SyncWorkers:         viper.GetInt("sync-workers"),
+		ShutdownTimeout:     viper.GetInt("shutdown-timeout"),
+		WatchConfig:         viper.GetBool("watch-config"),
+		CacheCompression:    viper.GetString("cache-compression"),
+		CacheStorage:        viper.GetString("cache-storage"),
+		CacheSize:           viper.GetInt("cache-size"),
+		CacheLRUSize:        viper.GetInt("cache-lru-size"),
+		TxIndex:             viper.GetBool("tx-index"),
+		NullifierIndex:      viper.GetBool("nullifier-index"),
+		SaplingTree:         viper.GetBool("sapling-tree"),
+		CompactBlockHeaders: viper.GetBool("compact-block-headers"),
+	}
+}
+
+// ingestorStopGrace is the least time shutdown gives the ingestor to finish
+// its current block, even if the streams used up the shutdown timeout.
+const ingestorStopGrace = 10 * time.Second
+
+// shutdown stops accepting connections and lets the streams in progress
+// finish (up to the timeout), then stops the ingestor and closes the cache.
+// It closes stopping first, to stop the background tasks that wait on it.
+func shutdown(server *grpc.Server, cache *common.BlockCache, timeout time.Duration, stopping chan struct{}) {
+	deadline := time.Now().Add(timeout)
+	close(stopping)
+	// Chain event subscriptions never end by themselves.
+	cache.CloseSubscriptions()
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		common.Log.Warn("gRPC streams didn't finish in time, closing them")
+		server.Stop()
+	}
+	ingestorTimeout := time.Until(deadline)
+	if ingestorTimeout < ingestorStopGrace {
+		ingestorTimeout = ingestorStopGrace
+	}
+	if !common.StopIngestor(ingestorTimeout) {
+		// Closing (or syncing) the cache under it could leave a block
+		// half added, or wait on it; the cache recovers from being left
+		// as it is, as from a crash.
+		common.Log.Warn("block ingestor didn't stop in time, leaving the cache as it is")
+		return
+	}
+	cache.Sync()
+	cache.Close()
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -101,8 +170,10 @@ func fileExists(filename string) bool {
 }
 
 func startServer(opts *common.Options) error {
+	reload := &reloader{opts: opts}
+	// Closed by shutdown.
+	stopping := make(chan struct{})
 	if opts.LogFile != "" {
-		// instead write parsable logs for logstash/splunk/etc
 		output, err := os.OpenFile(opts.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			common.Log.WithFields(logrus.Fields{
@@ -110,9 +181,9 @@ func startServer(opts *common.Options) error {
 				"path":  opts.LogFile,
 			}).Fatal("couldn't open log file")
 		}
-		defer output.Close()
-		logger.SetOutput(output)
-		logger.SetFormatter(&logrus.JSONFormatter{})
+		defer func() { closeLogFile(reload.logFile) }()
+		setLogOutput(output)
+		reload.logFile = output
 	}
 
 	logger.SetLevel(logrus.Level(opts.LogLevel))
@@ -132,6 +203,7 @@ func startServer(opts *common.Options) error {
 		common.Log.Warningln("Starting insecure no-TLS (plaintext) server")
 		fmt.Println("Starting insecure server")
 		server = grpc.NewServer(
+			frontend.ServerCodec(),
 			grpc.StreamInterceptor(
 				grpc_middleware.ChainStreamServer(
 					grpc_prometheus.StreamServerInterceptor),
@@ -149,7 +221,7 @@ func startServer(opts *common.Options) error {
 			transportCreds = credentials.NewServerTLSFromCert(tlsCert)
 		} else {
 			var err error
-			transportCreds, err = credentials.NewServerTLSFromFile(opts.TLSCertPath, opts.TLSKeyPath)
+			reload.cert, err = loadCertificate(opts.TLSCertPath, opts.TLSKeyPath)
 			if err != nil {
 				common.Log.WithFields(logrus.Fields{
 					"cert_file": opts.TLSCertPath,
@@ -157,8 +229,11 @@ func startServer(opts *common.Options) error {
 					"error":     err,
 				}).Fatal("couldn't load TLS credentials")
 			}
+			// The certificate can be replaced while running (see reloader).
+			transportCreds = credentials.NewTLS(&tls.Config{GetCertificate: reload.cert.get})
 		}
 		server = grpc.NewServer(
+			frontend.ServerCodec(),
 			grpc.Creds(transportCreds),
 			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
 				grpc_prometheus.StreamServerInterceptor),
@@ -182,43 +257,99 @@ func startServer(opts *common.Options) error {
 	// of block streamer.
 
 	var saplingHeight int
+	var tipHeight int
 	var chainName string
-	var rpcClient *rpcclient.Client
 	var err error
+	switch opts.NodeType {
+	case "zcashd":
+		common.Chain = common.ZcashdBackend{}
+	case "zebrad":
+		common.Chain = common.ZebradBackend{}
+	default:
+		common.Log.WithFields(logrus.Fields{
+			"node-type": opts.NodeType,
+		}).Fatal("node-type must be zcashd or zebrad")
+	}
 	if opts.Darkside {
 		chainName = "darkside"
+	} else if len(opts.ZcashdBackends) > 0 {
+		// The backends can change while running (see reloader).
+		reload.backends = newZcashdPool()
+		backends, clients, err := reload.backends.connect(opts.ZcashdBackends)
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("setting up RPC connections to zcashd backends")
+		}
+		reload.backends.set(opts.ZcashdBackends, backends, clients)
+		pool := reload.backends.pool
+		pool.CheckHealth()
+		go pool.HealthChecker(10*time.Second, stopping)
+		common.RawRequest = pool.RawRequest
+		common.RawBatchRequest = pool.RawBatchRequest
 	} else {
-		if opts.RPCUser != "" && opts.RPCPassword != "" && opts.RPCHost != "" && opts.RPCPort != "" {
-			rpcClient, err = frontend.NewZRPCFromFlags(opts)
-		} else {
-			rpcClient, err = frontend.NewZRPCFromConf(opts.ZcashConfPath)
+		connCfg, err := frontend.ZcashdConnConfig(opts)
+		var rpcClient *rpcclient.Client
+		var batchClient *frontend.BatchClient
+		if err == nil {
+			rpcClient, batchClient, err = frontend.NewZRPCFromConnConfig(connCfg)
 		}
 		if err != nil {
 			common.Log.WithFields(logrus.Fields{
 				"error": err,
 			}).Fatal("setting up RPC connection to zcashd")
 		}
+		// The connection settings can change while running (see reloader).
+		reload.zcashd = &zcashdClient{}
+		reload.zcashd.set(connCfg, rpcClient, batchClient)
 		// Indirect function for test mocking (so unit tests can talk to stub functions).
-		common.RawRequest = rpcClient.RawRequest
-
-		// Ensure that we can communicate with zcashd
-		common.FirstRPC()
-
-		getLightdInfo, err := common.GetLightdInfo()
-		if err != nil {
+		common.RawRequest = reload.zcashd.RawRequest
+		common.RawBatchRequest = reload.zcashd.BatchRequest
+	}
+	dbPath := filepath.Join(opts.DataDir, "db")
+	// Without zcashd, the cache (if there is one) can still be served.
+	startUnavailable := false
+	if !opts.Darkside {
+		// Ensure that we can communicate with zcashd; don't wait for it
+		// if there's a cache to serve.
+		metadata, metadataErr := common.FindCacheMetadata(dbPath)
+		canStartUnavailable := metadataErr == nil && !opts.Redownload && opts.CacheStorage != "memory"
+		retries := 10
+		if canStartUnavailable {
+			retries = 1
+		}
+		if err := common.FirstRPC(retries); err != nil {
+			if !canStartUnavailable {
+				common.Log.WithFields(logrus.Fields{
+					"error":       err,
+					"cache_error": metadataErr,
+				}).Fatal("unable to reach zcashd at startup (getblockchaininfo RPC), and there's no cache to serve")
+			}
 			common.Log.WithFields(logrus.Fields{
 				"error": err,
-			}).Fatal("getting initial information from zcashd")
+				"chain": metadata.ChainName,
+			}).Error("unable to reach zcashd at startup, serving cached blocks only")
+			common.StartUnavailable(metadata)
+			startUnavailable = true
+			saplingHeight = metadata.SaplingHeight
+			chainName = metadata.ChainName
+		} else {
+			getLightdInfo, err := common.GetLightdInfo()
+			if err != nil {
+				common.Log.WithFields(logrus.Fields{
+					"error": err,
+				}).Fatal("getting initial information from zcashd")
+			}
+			common.Log.Info("Got sapling height ", getLightdInfo.SaplingActivationHeight,
+				" block height ", getLightdInfo.BlockHeight,
+				" chain ", getLightdInfo.ChainName,
+				" branchID ", getLightdInfo.ConsensusBranchId)
+			saplingHeight = int(getLightdInfo.SaplingActivationHeight)
+			tipHeight = int(getLightdInfo.BlockHeight)
+			chainName = getLightdInfo.ChainName
 		}
-		common.Log.Info("Got sapling height ", getLightdInfo.SaplingActivationHeight,
-			" block height ", getLightdInfo.BlockHeight,
-			" chain ", getLightdInfo.ChainName,
-			" branchID ", getLightdInfo.ConsensusBranchId)
-		saplingHeight = int(getLightdInfo.SaplingActivationHeight)
-		chainName = getLightdInfo.ChainName
 	}
 
-	dbPath := filepath.Join(opts.DataDir, "db")
 	if opts.Darkside {
 		os.RemoveAll(filepath.Join(dbPath, chainName))
 	}
@@ -239,8 +370,120 @@ func startServer(opts *common.Options) error {
 		os.Stderr.WriteString(fmt.Sprintf("\n  ** Can't create db directory: %s\n\n", dbPath))
 		os.Exit(1)
 	}
+	switch opts.CacheCompression {
+	case "none", "snappy":
+		common.CacheCompression = opts.CacheCompression
+	default:
+		common.Log.WithFields(logrus.Fields{
+			"cache-compression": opts.CacheCompression,
+		}).Fatal("cache-compression must be none or snappy")
+	}
+	switch opts.CacheStorage {
+	case "flat", "bbolt", "memory":
+		common.CacheStorage = opts.CacheStorage
+	default:
+		common.Log.WithFields(logrus.Fields{
+			"cache-storage": opts.CacheStorage,
+		}).Fatal("cache-storage must be flat, bbolt or memory")
+	}
+	if opts.CacheStorage == "memory" && (opts.TxIndex || opts.NullifierIndex || opts.SaplingTree) {
+		// The indexes are kept on disk, with the cache they index.
+		common.Log.WithFields(logrus.Fields{
+			"tx-index":        opts.TxIndex,
+			"nullifier-index": opts.NullifierIndex,
+			"sapling-tree":    opts.SaplingTree,
+		}).Fatal("tx-index, nullifier-index and sapling-tree need cache-storage flat or bbolt, not memory")
+	}
+	common.BlockLRUSize = opts.CacheLRUSize * 1000 * 1000
+	if opts.Darkside {
+		// Darkside starts from scratch every time.
+		common.CacheStorage = "memory"
+	}
+	common.CompactBlockHeaders = opts.CompactBlockHeaders
 	cache := common.NewBlockCache(dbPath, chainName, saplingHeight, opts.Redownload)
+	if !opts.Darkside && common.CacheStorage != "memory" {
+		if err := common.CheckCompactBlockHeaders(dbPath, chainName, cache); err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("compact-block-headers doesn't match the cache")
+		}
+	}
+	if startUnavailable {
+		if cache.GetLatestHeight() < 0 {
+			common.Log.Fatal("unable to reach zcashd at startup, and the cache is empty")
+		}
+		tipHeight = cache.GetLatestHeight()
+	} else if !opts.Darkside && common.CacheStorage != "memory" {
+		err := common.WriteCacheMetadata(dbPath, &common.CacheMetadata{
+			ChainName:           chainName,
+			SaplingHeight:       saplingHeight,
+			CompactBlockHeaders: common.CompactBlockHeaders,
+		})
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("couldn't record the cache's metadata (lightwalletd can't start without zcashd)")
+		}
+	}
+	if opts.TxIndex && common.CacheStorage != "memory" {
+		txIndex, err := common.OpenTxIndex(dbPath, chainName, opts.Redownload)
+		if err == nil {
+			err = cache.SetTxIndex(txIndex)
+		}
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't open the tx index")
+		}
+	}
+	if opts.NullifierIndex && common.CacheStorage != "memory" {
+		nfIndex, err := common.OpenNullifierIndex(dbPath, chainName, opts.Redownload)
+		if err == nil {
+			err = cache.SetNullifierIndex(nfIndex)
+		}
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't open the nullifier index")
+		}
+	}
+	if opts.SaplingTree && common.CacheStorage != "memory" {
+		treeIndex, err := common.OpenSaplingTreeIndex(dbPath, chainName, opts.Redownload)
+		if err == nil {
+			err = cache.SetSaplingTreeIndex(treeIndex, saplingHeight)
+		}
+		if err != nil {
+			common.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("couldn't open the Sapling tree index")
+		}
+	}
+	if opts.CacheSize > 0 && !opts.Darkside {
+		cache.SetRetention(opts.CacheSize, tipHeight)
+	}
+	http.HandleFunc("/health", healthHandler(cache))
+	if opts.HTTPAdmin {
+		http.HandleFunc("/admin/rewind", rewindHandler(cache))
+		http.HandleFunc("/admin/resync", resyncHandler(cache))
+	}
+	common.MaxReorgDepth = opts.MaxReorgDepth
+	if opts.SyncWorkers > 0 {
+		common.SyncWorkers = opts.SyncWorkers
+	}
 	if !opts.Darkside {
+		for _, endpoint := range opts.ZcashdZmq {
+			subscriber, err := common.NewZmqSubscriber(endpoint,
+				func([]byte) {
+					common.NotifyNewBlock()
+					frontend.ExpireMempool()
+				}, frontend.AddMempoolTx)
+			if err != nil {
+				common.Log.WithFields(logrus.Fields{
+					"error": err,
+				}).Fatal("setting up ZMQ notifications")
+			}
+			go subscriber.Run()
+		}
 		go common.BlockIngestor(cache, 0 /*loop forever*/)
 	} else {
 		// Darkside wants to control starting the block ingestor.
@@ -279,14 +522,34 @@ func startServer(opts *common.Options) error {
 	// Signal handler for graceful stops
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
 	go func() {
 		s := <-signals
-		cache.Sync()
 		common.Log.WithFields(logrus.Fields{
 			"signal": s.String(),
 		}).Info("caught signal, stopping gRPC server")
-		os.Exit(1)
+		shutdown(server, cache, time.Duration(opts.ShutdownTimeout)*time.Second, stopping)
+		close(stopped)
+	}()
+
+	// Reload the configuration on SIGHUP, or when the config file changes
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			common.Log.Info("caught SIGHUP, reloading configuration")
+			reload.reloadConfig()
+		}
 	}()
+	if opts.WatchConfig && viper.ConfigFileUsed() != "" {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			common.Log.WithFields(logrus.Fields{
+				"path": e.Name,
+			}).Info("config file changed, reloading configuration")
+			reload.apply(optionsFromViper())
+		})
+		viper.WatchConfig()
+	}
 
 	err = server.Serve(listener)
 	if err != nil {
@@ -294,6 +557,9 @@ func startServer(opts *common.Options) error {
 			"error": err,
 		}).Fatal("gRPC server exited")
 	}
+	// Serve() returns as soon as shutdown begins.
+	<-stopped
+	common.Log.Info("lightwalletd stopped")
 	return nil
 }
 
@@ -329,6 +595,22 @@ func init() {
 	rootCmd.Flags().Bool("ping-very-insecure", false, "allow Ping GRPC for testing")
 	rootCmd.Flags().Bool("darkside-very-insecure", false, "run with GRPC-controllable mock zcashd for integration testing (shuts down after 30 minutes)")
 	rootCmd.Flags().Int("darkside-timeout", 30, "override 30 minute default darkside timeout")
+	rootCmd.Flags().Int("max-reorg-depth", 100, "halt block ingestion (but keep serving) if a reorg is deeper than this")
+	rootCmd.Flags().Bool("http-admin", false, "enable the /admin/rewind and /admin/resync actions on the http-bind-addr")
+	rootCmd.Flags().StringSlice("zcashd-backends", nil, "zcashd nodes to fail over between, each user:password@host:port or a zcash.conf path")
+	rootCmd.Flags().String("node-type", "zcashd", "type of full node to get blocks from, zcashd or zebrad")
+	rootCmd.Flags().Int("sync-workers", 1, "number of blocks to fetch from zcashd concurrently when far behind its tip (1 to disable)")
+	rootCmd.Flags().Int("shutdown-timeout", 20, "seconds to let gRPC streams and block ingestion finish when stopping")
+	rootCmd.Flags().Bool("watch-config", false, "reload the configuration (as on SIGHUP) when the config file changes")
+	rootCmd.Flags().String("cache-compression", "none", "how to compress newly cached blocks, none or snappy (existing blocks are read either way)")
+	rootCmd.Flags().String("cache-storage", "flat", "how to store cached blocks: flat (files), bbolt (database) or memory (not saved)")
+	rootCmd.Flags().Int("cache-size", 0, "keep only about this many of the most recent blocks in the cache, older ones are fetched from zcashd (0 keeps all blocks since Sapling activation)")
+	rootCmd.Flags().Int("cache-lru-size", 64, "megabytes of recently used blocks to keep in memory (0 to disable)")
+	rootCmd.Flags().Bool("tx-index", false, "keep the transactions of newly cached blocks, to serve GetTransaction without zcashd (and its -txindex)")
+	rootCmd.Flags().Bool("nullifier-index", false, "keep the nullifiers of the cached blocks, for GetNullifierStatus (with --cache-size, it can't tell that a nullifier is unspent)")
+	rootCmd.Flags().Bool("sapling-tree", false, "keep the Sapling note commitment tree, to serve GetTreeState without zcashd")
+	rootCmd.Flags().Bool("compact-block-headers", false, "include the full block header in compact blocks, so wallets can verify them (changing this needs --redownload)")
+	rootCmd.Flags().StringSlice("zcashd-zmq", nil, "zcashd -zmqpubhashblock and -zmqpubrawtx endpoints to get new blocks and transactions from, such as tcp://127.0.0.1:28332")
 
 	viper.BindPFlag("grpc-bind-addr", rootCmd.Flags().Lookup("grpc-bind-addr"))
 	viper.SetDefault("grpc-bind-addr", "127.0.0.1:9067")
@@ -364,12 +646,38 @@ func init() {
 	viper.SetDefault("darkside-very-insecure", false)
 	viper.BindPFlag("darkside-timeout", rootCmd.Flags().Lookup("darkside-timeout"))
 	viper.SetDefault("darkside-timeout", 30)
-
-	logger.SetFormatter(&logrus.TextFormatter{
-		//DisableColors:          true,
-		FullTimestamp:          true,
-		DisableLevelTruncation: true,
-	})
+	viper.BindPFlag("max-reorg-depth", rootCmd.Flags().Lookup("max-reorg-depth"))
+	viper.SetDefault("max-reorg-depth", 100)
+	viper.BindPFlag("http-admin", rootCmd.Flags().Lookup("http-admin"))
+	viper.SetDefault("http-admin", false)
+	viper.BindPFlag("zcashd-backends", rootCmd.Flags().Lookup("zcashd-backends"))
+	viper.BindPFlag("node-type", rootCmd.Flags().Lookup("node-type"))
+	viper.SetDefault("node-type", "zcashd")
+	viper.BindPFlag("zcashd-zmq", rootCmd.Flags().Lookup("zcashd-zmq"))
+	viper.BindPFlag("sync-workers", rootCmd.Flags().Lookup("sync-workers"))
+	viper.SetDefault("sync-workers", 1)
+	viper.BindPFlag("shutdown-timeout", rootCmd.Flags().Lookup("shutdown-timeout"))
+	viper.SetDefault("shutdown-timeout", 20)
+	viper.BindPFlag("watch-config", rootCmd.Flags().Lookup("watch-config"))
+	viper.SetDefault("watch-config", false)
+	viper.BindPFlag("cache-compression", rootCmd.Flags().Lookup("cache-compression"))
+	viper.SetDefault("cache-compression", "none")
+	viper.BindPFlag("cache-storage", rootCmd.Flags().Lookup("cache-storage"))
+	viper.SetDefault("cache-storage", "flat")
+	viper.BindPFlag("cache-size", rootCmd.Flags().Lookup("cache-size"))
+	viper.SetDefault("cache-size", 0)
+	viper.BindPFlag("cache-lru-size", rootCmd.Flags().Lookup("cache-lru-size"))
+	viper.SetDefault("cache-lru-size", 64)
+	viper.BindPFlag("tx-index", rootCmd.Flags().Lookup("tx-index"))
+	viper.SetDefault("tx-index", false)
+	viper.BindPFlag("nullifier-index", rootCmd.Flags().Lookup("nullifier-index"))
+	viper.SetDefault("nullifier-index", false)
+	viper.BindPFlag("sapling-tree", rootCmd.Flags().Lookup("sapling-tree"))
+	viper.SetDefault("sapling-tree", false)
+	viper.BindPFlag("compact-block-headers", rootCmd.Flags().Lookup("compact-block-headers"))
+	viper.SetDefault("compact-block-headers", false)
+
+	logger.SetFormatter(textFormatter)
 
 	onexit := func() {
 		fmt.Printf("Lightwalletd died with a Fatal error. Check logfile for details.\n")
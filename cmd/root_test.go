@@ -5,6 +5,9 @@ package cmd
 
 import (
 	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/common"
 )
 
 func TestFileExists(t *testing.T) {
@@ -20,3 +23,80 @@ func TestFileExists(t *testing.T) {
 		t.Fatal("fileExists failed")
 	}
 }
+
+func TestReload(t *testing.T) {
+	defer logger.SetLevel(logger.GetLevel())
+	logger.SetLevel(logrus.InfoLevel)
+	r := &reloader{opts: &common.Options{LogLevel: uint64(logrus.InfoLevel)}}
+
+	if err := r.reload(&common.Options{LogLevel: uint64(logrus.DebugLevel)}); err != nil {
+		t.Fatal("reload failed", err)
+	}
+	if logger.GetLevel() != logrus.DebugLevel {
+		t.Fatal("log level not reloaded")
+	}
+	if r.opts.LogLevel != uint64(logrus.DebugLevel) {
+		t.Fatal("unexpected applied log level", r.opts.LogLevel)
+	}
+
+	// Nothing changes if any of the options are invalid.
+	bad := []*common.Options{
+		{LogLevel: uint64(logrus.TraceLevel) + 1},
+		{LogLevel: uint64(logrus.TraceLevel), LogFile: "nonexistent-dir/server.log"},
+	}
+	for _, opts := range bad {
+		if err := r.reload(opts); err == nil {
+			t.Fatal("reload unexpected success")
+		}
+		if logger.GetLevel() != logrus.DebugLevel {
+			t.Fatal("log level changed by invalid options")
+		}
+		if r.opts.LogLevel != uint64(logrus.DebugLevel) {
+			t.Fatal("applied options changed by invalid options")
+		}
+	}
+}
+
+func TestReloadBackends(t *testing.T) {
+	level := uint64(logger.GetLevel())
+	r := &reloader{
+		opts:     &common.Options{LogLevel: level, ZcashdBackends: []string{"user:pass@127.0.0.1:8232"}},
+		backends: newZcashdPool(),
+	}
+	backends, clients, err := r.backends.connect(r.opts.ZcashdBackends)
+	if err != nil {
+		t.Fatal("connect failed", err)
+	}
+	r.backends.set(r.opts.ZcashdBackends, backends, clients)
+	first := r.backends.backends["user:pass@127.0.0.1:8232"]
+
+	// One backend is kept, one is added.
+	specs := []string{"user:pass@127.0.0.1:8232", "user:pass@127.0.0.1:18232"}
+	if err := r.reload(&common.Options{LogLevel: level, ZcashdBackends: specs}); err != nil {
+		t.Fatal("reload failed", err)
+	}
+	if len(r.backends.backends) != 2 || r.backends.backends[specs[0]] != first {
+		t.Fatal("unexpected backends after reload", r.backends.backends)
+	}
+	if len(r.opts.ZcashdBackends) != 2 {
+		t.Fatal("unexpected applied backends", r.opts.ZcashdBackends)
+	}
+
+	// An invalid backend changes nothing.
+	bad := []string{"user:pass@127.0.0.1:8232", "user:pass@[::1"}
+	if err := r.reload(&common.Options{LogLevel: level, ZcashdBackends: bad}); err == nil {
+		t.Fatal("reload unexpected success")
+	}
+	if len(r.backends.backends) != 2 || len(r.opts.ZcashdBackends) != 2 {
+		t.Fatal("backends changed by invalid options")
+	}
+
+	// A repeated backend gets only one client.
+	specs = append(specs, specs[1])
+	if err := r.reload(&common.Options{LogLevel: level, ZcashdBackends: specs}); err != nil {
+		t.Fatal("reload failed", err)
+	}
+	if len(r.backends.backends) != 2 || len(r.backends.clients) != 2 {
+		t.Fatal("unexpected backends after reload with a repeat", r.backends.backends)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// A backend whose tip is this many blocks behind the best backend's
+	// isn't used for reads (it's probably still syncing).
+	maxBackendTipLag = 3
+
+	// A backend that takes longer than this to answer getblockchaininfo
+	// is considered unhealthy.
+	maxBackendLatency = 5 * time.Second
+)
+
+var (
+	backendHealthyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_backend_healthy",
+		Help: "1 if this zcashd backend is receiving requests, else 0.",
+	}, []string{"backend"})
+	backendTipGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_backend_tip_height",
+		Help: "Best block height reported by this zcashd backend.",
+	}, []string{"backend"})
+	backendLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_backend_latency_seconds",
+		Help: "Latency of this zcashd backend's most recent getblockchaininfo.",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(backendHealthyGauge)
+	prometheus.MustRegister(backendTipGauge)
+	prometheus.MustRegister(backendLatencyGauge)
+}
+
+// Backend is one zcashd node that RPCs can be sent to.
+type Backend struct {
+	Name       string // for logging and metrics, such as host:port
+	RawRequest func(method string, params []json.RawMessage) (json.RawMessage, error)
+
+	// Optional; if nil, batched requests are sent one at a time.
+	BatchRequest func(requests []RPCRequest) ([]RPCReply, error)
+
+	healthy   bool
+	tipHeight int
+}
+
+// BackendPool spreads zcashd RPCs across several nodes, skipping nodes that
+// are down or behind, and failing over to another node when a request can't
+// be delivered. Its RawRequest method can be assigned to common.RawRequest.
+type BackendPool struct {
+	backends []*Backend
+	ingest   *Backend // has the best tip; getblock goes here
+	sticky   *Backend // sendrawtransaction goes here while it's healthy
+	next     uint32   // round-robin position for other reads
+	mutex    sync.RWMutex
+}
+
+// NewBackendPool returns a pool of the given backends; all are assumed to
+// be healthy until the first CheckHealth().
+func NewBackendPool(backends []*Backend) *BackendPool {
+	p := &BackendPool{}
+	p.SetBackends(backends)
+	return p
+}
+
+// SetBackends replaces the pool's backends (when the configuration is
+// reloaded). Those already in the pool keep their health; new ones are
+// assumed to be healthy until the next CheckHealth().
+func (p *BackendPool) SetBackends(backends []*Backend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	current := make(map[*Backend]bool)
+	for _, b := range p.backends {
+		current[b] = true
+	}
+	next := make(map[*Backend]bool)
+	names := make(map[string]bool)
+	for _, b := range backends {
+		next[b] = true
+		names[b.Name] = true
+		if !current[b] {
+			b.healthy = true
+			backendHealthyGauge.WithLabelValues(b.Name).Set(1)
+		}
+	}
+	for _, b := range p.backends {
+		if !names[b.Name] {
+			backendHealthyGauge.DeleteLabelValues(b.Name)
+			backendTipGauge.DeleteLabelValues(b.Name)
+			backendLatencyGauge.DeleteLabelValues(b.Name)
+		}
+	}
+	p.backends = backends
+	if !next[p.ingest] {
+		p.ingest = nil
+	}
+	if len(backends) > 0 && p.ingest == nil {
+		p.ingest = backends[0]
+	}
+	p.chooseIngest()
+	if !next[p.sticky] {
+		p.sticky = p.ingest
+	}
+}
+
+// isNodeError is true if zcashd received the request and replied with an
+// error (such as "-8: Block height out of range"); another node would most
+// likely give the same answer, so there's no point in failing over.
+func isNodeError(err error) bool {
+	return rpcErrorCode(err) != 0
+}
+
+// RawRequest sends the request to the most suitable backend, trying the
+// others in turn if it can't be delivered.
+func (p *BackendPool) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	err := errors.New("no zcashd backends are configured")
+	for _, b := range p.candidates(method) {
+		var result json.RawMessage
+		result, err = b.RawRequest(method, params)
+		if err == nil || isNodeError(err) {
+			p.delivered(b)
+			if method == "sendrawtransaction" {
+				p.mutex.Lock()
+				p.sticky = b
+				p.mutex.Unlock()
+			}
+			return result, err
+		}
+		p.setHealthy(b, false, err)
+	}
+	return nil, err
+}
+
+// RawBatchRequest sends the batch to the most suitable backend for its
+// first request, trying the others in turn if it can't be delivered.
+func (p *BackendPool) RawBatchRequest(requests []RPCRequest) ([]RPCReply, error) {
+	err := errors.New("no zcashd backends are configured")
+	for _, b := range p.candidates(requests[0].Method) {
+		var replies []RPCReply
+		replies, err = b.batchRequest(requests)
+		if err == nil {
+			p.delivered(b)
+			return replies, nil
+		}
+		p.setHealthy(b, false, err)
+	}
+	return nil, err
+}
+
+// delivered marks the backend healthy again, if it wasn't, since it
+// answered a request; it needn't wait for the next CheckHealth().
+func (p *BackendPool) delivered(b *Backend) {
+	p.mutex.RLock()
+	healthy := b.healthy
+	p.mutex.RUnlock()
+	if !healthy {
+		p.setHealthy(b, true, nil)
+	}
+}
+
+func (b *Backend) batchRequest(requests []RPCRequest) ([]RPCReply, error) {
+	if b.BatchRequest != nil {
+		return b.BatchRequest(requests)
+	}
+	replies := make([]RPCReply, len(requests))
+	for i, r := range requests {
+		replies[i].Result, replies[i].Err = b.RawRequest(r.Method, r.Params)
+		if replies[i].Err != nil && !isNodeError(replies[i].Err) {
+			return nil, replies[i].Err
+		}
+	}
+	return replies, nil
+}
+
+// candidates returns the backends to try for this request, best first.
+// Unhealthy backends are included last, so a request can still succeed
+// if the health information is stale.
+func (p *BackendPool) candidates(method string) []*Backend {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var best, other, unhealthy []*Backend
+	var first *Backend
+	switch method {
+	case "getblock":
+		first = p.ingest
+	case "sendrawtransaction":
+		first = p.sticky
+	}
+	bestTip := p.bestTip()
+	for _, b := range p.backends {
+		switch {
+		case b == first && b.healthy:
+			// added at the front, below
+		case !b.healthy:
+			unhealthy = append(unhealthy, b)
+		case b.tipHeight+maxBackendTipLag >= bestTip:
+			best = append(best, b)
+		default:
+			other = append(other, b)
+		}
+	}
+	if len(best) > 1 {
+		// Share the load among the up-to-date backends.
+		n := int(atomic.AddUint32(&p.next, 1)) % len(best)
+		best = append(best[n:], best[:n]...)
+	}
+	result := make([]*Backend, 0, len(p.backends))
+	if first != nil && first.healthy {
+		result = append(result, first)
+	}
+	result = append(result, best...)
+	result = append(result, other...)
+	return append(result, unhealthy...)
+}
+
+// Caller should hold p.mutex (at least RLock).
+func (p *BackendPool) bestTip() int {
+	best := 0
+	for _, b := range p.backends {
+		if b.healthy && b.tipHeight > best {
+			best = b.tipHeight
+		}
+	}
+	return best
+}
+
+func (p *BackendPool) setHealthy(b *Backend, healthy bool, reason interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if b.healthy != healthy {
+		entry := Log.WithFields(logrus.Fields{
+			"backend": b.Name,
+			"reason":  reason,
+		})
+		if healthy {
+			entry.Info("zcashd backend is healthy")
+		} else {
+			entry.Warn("zcashd backend is unhealthy")
+		}
+	}
+	b.healthy = healthy
+	if healthy {
+		backendHealthyGauge.WithLabelValues(b.Name).Set(1)
+	} else {
+		backendHealthyGauge.WithLabelValues(b.Name).Set(0)
+	}
+	p.chooseIngest()
+}
+
+// chooseIngest makes the healthy backend with the highest tip (the current
+// one, if tied) the source of new blocks. Caller should hold p.mutex.Lock().
+func (p *BackendPool) chooseIngest() {
+	ingest := p.ingest
+	for _, b := range p.backends {
+		if !b.healthy {
+			continue
+		}
+		if ingest == nil || !ingest.healthy || b.tipHeight > ingest.tipHeight {
+			ingest = b
+		}
+	}
+	if ingest != p.ingest {
+		Log.WithFields(logrus.Fields{
+			"backend": ingest.Name,
+			"height":  ingest.tipHeight,
+		}).Info("ingesting blocks from zcashd backend")
+		p.ingest = ingest
+	}
+}
+
+// CheckHealth issues getblockchaininfo to each backend, recording its tip
+// height and latency, and updates the routing accordingly.
+func (p *BackendPool) CheckHealth() {
+	p.mutex.RLock()
+	backends := p.backends
+	p.mutex.RUnlock()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			start := time.Now()
+			result, err := b.RawRequest("getblockchaininfo", []json.RawMessage{})
+			latency := time.Since(start)
+			var reply ZcashdRpcReplyGetblockchaininfo
+			if err == nil {
+				err = json.Unmarshal(result, &reply)
+			}
+			backendLatencyGauge.WithLabelValues(b.Name).Set(latency.Seconds())
+			if err == nil {
+				p.mutex.Lock()
+				b.tipHeight = reply.Blocks
+				p.mutex.Unlock()
+				backendTipGauge.WithLabelValues(b.Name).Set(float64(reply.Blocks))
+			}
+			switch {
+			case err != nil:
+				p.setHealthy(b, false, err)
+			case latency > maxBackendLatency:
+				p.setHealthy(b, false, "slow getblockchaininfo: "+latency.String())
+			default:
+				p.setHealthy(b, true, nil)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+// HealthChecker runs as a goroutine, checking the backends periodically,
+// until stop is closed.
+func (p *BackendPool) HealthChecker(interval time.Duration, stop <-chan struct{}) {
+	for {
+		slept := make(chan struct{})
+		go func() {
+			Sleep(interval)
+			close(slept)
+		}()
+		select {
+		case <-stop:
+			return
+		case <-slept:
+		}
+		p.CheckHealth()
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A stub zcashd node: it records which methods it was sent.
+type stubNode struct {
+	name  string
+	tip   int
+	down  bool
+	calls []string
+}
+
+func (n *stubNode) backend() *Backend {
+	return &Backend{
+		Name: n.name,
+		RawRequest: func(method string, params []json.RawMessage) (json.RawMessage, error) {
+			if n.down {
+				return nil, errors.New("dial tcp: connection refused")
+			}
+			n.calls = append(n.calls, method)
+			switch method {
+			case "getblockchaininfo":
+				return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Blocks: n.tip})
+			case "getrawtransaction":
+				return nil, errors.New("-5: No such mempool or blockchain transaction")
+			}
+			return json.RawMessage(`"` + n.name + `"`), nil
+		},
+	}
+}
+
+func (n *stubNode) reset() {
+	n.calls = nil
+}
+
+func TestBackendPool(t *testing.T) {
+	a := &stubNode{name: "a", tip: 1000}
+	b := &stubNode{name: "b", tip: 1002}
+	c := &stubNode{name: "c", tip: 900} // still syncing
+	pool := NewBackendPool([]*Backend{a.backend(), b.backend(), c.backend()})
+	pool.CheckHealth()
+
+	// Ingestion comes from the node with the best tip.
+	result, err := pool.RawRequest("getblock", nil)
+	if err != nil || string(result) != `"b"` {
+		t.Fatal("getblock unexpected result", string(result), err)
+	}
+
+	// Reads are shared among the up-to-date nodes, never the lagging one.
+	a.reset()
+	b.reset()
+	c.reset()
+	for i := 0; i < 4; i++ {
+		if _, err := pool.RawRequest("getaddressbalance", nil); err != nil {
+			t.Fatal("getaddressbalance failed", err)
+		}
+	}
+	if len(a.calls) != 2 || len(b.calls) != 2 || len(c.calls) != 0 {
+		t.Fatal("unexpected read distribution", a.calls, b.calls, c.calls)
+	}
+
+	// An error reply from zcashd is returned as-is (no failover).
+	a.reset()
+	b.reset()
+	_, err = pool.RawRequest("getrawtransaction", nil)
+	if err == nil || len(a.calls)+len(b.calls) != 1 {
+		t.Fatal("getrawtransaction should have gone to one node", err)
+	}
+
+	// Transactions stick to one node...
+	result, err = pool.RawRequest("sendrawtransaction", nil)
+	if err != nil || string(result) != `"a"` {
+		t.Fatal("sendrawtransaction unexpected result", string(result), err)
+	}
+	result, _ = pool.RawRequest("sendrawtransaction", nil)
+	if string(result) != `"a"` {
+		t.Fatal("sendrawtransaction should be sticky", string(result))
+	}
+	// ... until it goes down, then fail over and stick to the new one.
+	a.down = true
+	result, err = pool.RawRequest("sendrawtransaction", nil)
+	if err != nil || string(result) != `"b"` {
+		t.Fatal("sendrawtransaction failover unexpected result", string(result), err)
+	}
+	a.down = false
+	pool.CheckHealth()
+	result, _ = pool.RawRequest("sendrawtransaction", nil)
+	if string(result) != `"b"` {
+		t.Fatal("sendrawtransaction should stay on the new node", string(result))
+	}
+
+	// The ingest node goes down; the next best tip takes over.
+	b.down = true
+	pool.CheckHealth()
+	result, err = pool.RawRequest("getblock", nil)
+	if err != nil || string(result) != `"a"` {
+		t.Fatal("getblock failover unexpected result", string(result), err)
+	}
+
+	// Everything is down.
+	a.down = true
+	c.down = true
+	if _, err = pool.RawRequest("getblock", nil); err == nil {
+		t.Fatal("getblock should fail when all backends are down")
+	}
+
+	// A backend that recovers is used even before the next health check.
+	c.down = false
+	result, err = pool.RawRequest("getblock", nil)
+	if err != nil || string(result) != `"c"` {
+		t.Fatal("getblock recovery unexpected result", string(result), err)
+	}
+	// ... and is healthy again after answering.
+	if !pool.backends[2].healthy {
+		t.Fatal("recovered backend should be healthy")
+	}
+
+	// The backends change (on reload); the ingest node is removed.
+	d := &stubNode{name: "d", tip: 1003}
+	pool.SetBackends([]*Backend{pool.backends[0], d.backend()})
+	if pool.backends[0].healthy || !pool.backends[1].healthy {
+		t.Fatal("SetBackends unexpected health")
+	}
+	result, err = pool.RawRequest("getblock", nil)
+	if err != nil || string(result) != `"d"` {
+		t.Fatal("getblock after SetBackends unexpected result", string(result), err)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	saveSleep := Sleep
+	defer func() { Sleep = saveSleep }()
+	sleeping, wake := make(chan struct{}), make(chan struct{})
+	Sleep = func(d time.Duration) {
+		sleeping <- struct{}{}
+		<-wake
+	}
+	checked := make(chan struct{})
+	n := &stubNode{name: "a", tip: 1000}
+	b := n.backend()
+	raw := b.RawRequest
+	b.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		checked <- struct{}{}
+		return raw(method, params)
+	}
+	pool := NewBackendPool([]*Backend{b})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		pool.HealthChecker(time.Second, stop)
+		close(done)
+	}()
+	<-sleeping
+	wake <- struct{}{}
+	<-checked
+
+	// Once stopped (while it sleeps), it doesn't check again.
+	<-sleeping
+	close(stop)
+	select {
+	case <-done:
+	case <-checked:
+		t.Fatal("health check after stop")
+	case <-time.After(10 * time.Second):
+		t.Fatal("HealthChecker didn't stop")
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/json"
+)
+
+// RPCRequest is one request in a JSON-RPC batch.
+type RPCRequest struct {
+	Method string
+	Params []json.RawMessage
+}
+
+// RPCReply is the reply to one request in a batch; Err has the same
+// "code: message" form as the errors from RawRequest.
+type RPCReply struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// RawBatchRequest, if set, sends several requests to zcashd in one round
+// trip, returning their replies in the same order. The error is for the
+// batch as a whole (such as a connection failure).
+var RawBatchRequest func(requests []RPCRequest) ([]RPCReply, error)
+
+// BatchRequest sends the requests as one batch if RawBatchRequest is set,
+// else one at a time using RawRequest. Errors for requests that didn't
+// reach zcashd are mapped as by rawRequest.
+func BatchRequest(requests []RPCRequest) ([]RPCReply, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	if RawBatchRequest != nil {
+		replies, err := RawBatchRequest(requests)
+		return replies, unreachable(err)
+	}
+	replies := make([]RPCReply, len(requests))
+	for i, request := range requests {
+		replies[i].Result, replies[i].Err = rawRequest(request.Method, request.Params)
+	}
+	return replies, nil
+}
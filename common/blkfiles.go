@@ -0,0 +1,260 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// zcashd's blk*.dat files have each block it has received (in the order it
+// received them, so not necessarily in height order, and including blocks
+// not in the best chain), preceded by the network's magic bytes and the
+// block's length (4 bytes, little-endian). The rest of a file may be zeros.
+var blkFileMagic = map[string][]byte{
+	"main":    {0x24, 0xe9, 0x27, 0x64},
+	"test":    {0xfa, 0x1a, 0xf9, 0xbf},
+	"regtest": {0xaa, 0xe8, 0x3f, 0x5f},
+}
+
+// maxBlkFileBlockSize is the most a block in the files can be (zcashd's
+// MAX_BLOCK_SIZE).
+const maxBlkFileBlockSize = 2000000
+
+// A BlkFileIndex locates the blocks of the best chain in zcashd's blk*.dat
+// files, without zcashd (see IndexBlkFiles).
+type BlkFileIndex struct {
+	ChainName        string
+	FirstHeight, Tip int // the best chain's heights (FirstHeight is 0 if the files start at genesis)
+	files            []string
+	blocks           map[string]*blkFileBlock // by hash
+	best             []*blkFileBlock          // the best chain, from FirstHeight
+}
+
+// blkFileBlock is where a block is, and what's known about it.
+type blkFileBlock struct {
+	file     int // index in files
+	offset   int64
+	size     int
+	hash     []byte
+	prevHash string
+	work     *big.Int // of this block, then (once height is known) of its chain
+	height   int      // -1 until known
+}
+
+// IndexBlkFiles reads the block headers in the blk*.dat files in blocksDir
+// (zcashd's blocks directory) and finds the best chain: the one with the
+// most work, from the block at the start of the files (the genesis block,
+// unless the files are incomplete).
+func IndexBlkFiles(blocksDir string) (*BlkFileIndex, error) {
+	files, err := filepath.Glob(filepath.Join(blocksDir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no blk*.dat files in " + blocksDir)
+	}
+	sort.Strings(files)
+	idx := &BlkFileIndex{files: files, blocks: make(map[string]*blkFileBlock)}
+	for i := range files {
+		if err := idx.indexFile(i); err != nil {
+			return nil, err
+		}
+	}
+	if err := idx.findBestChain(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// indexFile adds the blocks in the given file to the index.
+func (idx *BlkFileIndex) indexFile(file int) error {
+	name := idx.files[file]
+	f, err := os.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "open "+name+" failed")
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 1<<20)
+	var offset int64
+	prefix := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, prefix); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "read "+name+" failed")
+		}
+		magic := prefix[:4]
+		if bytes.Equal(magic, make([]byte, 4)) {
+			// Unused space at the end of the file.
+			return nil
+		}
+		if idx.ChainName == "" {
+			for chainName, m := range blkFileMagic {
+				if bytes.Equal(magic, m) {
+					idx.ChainName = chainName
+				}
+			}
+		}
+		if !bytes.Equal(magic, blkFileMagic[idx.ChainName]) {
+			return errors.Errorf("%s: unexpected magic %x at offset %d", name, magic, offset)
+		}
+		size := int(binary.LittleEndian.Uint32(prefix[4:]))
+		if size > maxBlkFileBlockSize {
+			return errors.Errorf("%s: impossible block size %d at offset %d", name, size, offset)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return errors.Wrap(err, "read "+name+" failed")
+		}
+		hdr := parser.NewBlockHeader()
+		if _, err := hdr.ParseFromSlice(data); err != nil {
+			return errors.Wrapf(err, "%s: bad block header at offset %d", name, offset)
+		}
+		hash := hdr.GetEncodableHash()
+		idx.blocks[string(hash)] = &blkFileBlock{
+			file:     file,
+			offset:   offset + 8,
+			size:     size,
+			hash:     hash,
+			prevHash: string(hdr.HashPrevBlock),
+			work:     blockWork(hdr.NBitsBytes),
+			height:   -1,
+		}
+		offset += int64(len(prefix) + size)
+	}
+}
+
+// blockWork returns the expected number of hashes to find a block with the
+// given target (nBits), 2^256 / (target+1).
+func blockWork(nBitsBytes []byte) *big.Int {
+	nBits := binary.LittleEndian.Uint32(nBitsBytes)
+	target := big.NewInt(int64(nBits & 0x007fffff))
+	if exponent := uint(nBits >> 24); exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// findBestChain works out the heights and chain work of the blocks, then
+// the best chain.
+func (idx *BlkFileIndex) findBestChain() error {
+	var tip *blkFileBlock
+	for _, block := range idx.blocks {
+		// Go back to a block whose height is known, or the first block.
+		var path []*blkFileBlock
+		b := block
+		for b.height < 0 {
+			path = append(path, b)
+			parent, ok := idx.blocks[b.prevHash]
+			if !ok {
+				break
+			}
+			b = parent
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			b := path[i]
+			if parent, ok := idx.blocks[b.prevHash]; ok {
+				b.height = parent.height + 1
+				b.work.Add(b.work, parent.work)
+				continue
+			}
+			// The first block: its height is in its coinbase.
+			full, err := idx.readBlock(b)
+			if err != nil {
+				return err
+			}
+			if b.height = full.GetHeight(); b.height < 0 {
+				return errors.Errorf("can't tell the height of block %x", parser.Reverse(b.hash))
+			}
+		}
+		if tip == nil || block.work.Cmp(tip.work) > 0 {
+			tip = block
+		}
+	}
+	idx.Tip = tip.height
+	for b := tip; ; b = idx.blocks[b.prevHash] {
+		idx.best = append(idx.best, b)
+		if _, ok := idx.blocks[b.prevHash]; !ok {
+			break
+		}
+	}
+	// Reverse, so that it's in height order.
+	for left, right := 0, len(idx.best)-1; left < right; left, right = left+1, right-1 {
+		idx.best[left], idx.best[right] = idx.best[right], idx.best[left]
+	}
+	idx.FirstHeight = idx.best[0].height
+	return nil
+}
+
+// readBlock reads and parses the given block from its file.
+func (idx *BlkFileIndex) readBlock(b *blkFileBlock) (*parser.Block, error) {
+	name := idx.files[b.file]
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open "+name+" failed")
+	}
+	defer f.Close()
+	data := make([]byte, b.size)
+	if _, err := f.ReadAt(data, b.offset); err != nil {
+		return nil, errors.Wrap(err, "read "+name+" failed")
+	}
+	block := parser.NewBlock()
+	rest, err := block.ParseFromSlice(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: bad block at offset %d", name, b.offset)
+	}
+	if len(rest) != 0 {
+		return nil, errors.Errorf("%s: extra data after block at offset %d", name, b.offset)
+	}
+	return block, nil
+}
+
+// ImportInto adds the best chain's blocks to the cache, from its next
+// height, which must be in the best chain (and its latest block too). It
+// returns the number of blocks added.
+func (idx *BlkFileIndex) ImportInto(cache *BlockCache) (int, error) {
+	next := cache.GetNextHeight()
+	if next < idx.FirstHeight || next > idx.Tip+1 {
+		return 0, errors.Errorf("the cache's next height, %d, isn't in the blk files' heights %d to %d",
+			next, idx.FirstHeight, idx.Tip)
+	}
+	if next > cache.GetFirstHeight() {
+		if next == idx.FirstHeight || !bytes.Equal(cache.GetLatestHash(), idx.best[next-1-idx.FirstHeight].hash) {
+			return 0, errors.New("the cache's latest block isn't in the blk files' best chain")
+		}
+	}
+	count := 0
+	for _, b := range idx.best[next-idx.FirstHeight:] {
+		block, err := idx.readBlock(b)
+		if err != nil {
+			return count, err
+		}
+		if block.GetHeight() != b.height {
+			return count, errors.Errorf("block %x at height %d has height %d in its coinbase",
+				parser.Reverse(b.hash), b.height, block.GetHeight())
+		}
+		if err := cache.AddBlock(b.height, block); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
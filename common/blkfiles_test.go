@@ -0,0 +1,147 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// writeBlkFile writes a blk*.dat file with the given blocks, then zeros.
+func writeBlkFile(t *testing.T, name string, magic []byte, blocks ...[]byte) {
+	var b bytes.Buffer
+	for _, block := range blocks {
+		b.Write(magic)
+		binary.Write(&b, binary.LittleEndian, uint32(len(block)))
+		b.Write(block)
+	}
+	b.Write(make([]byte, 1000))
+	if err := ioutil.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlkFiles(t *testing.T) {
+	type compactTest struct {
+		Full string `json:"full"`
+	}
+	var compactTests []compactTest
+	blockJSON, err := ioutil.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(blockJSON, &compactTests); err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][]byte
+	var compacts []*walletrpc.CompactBlock
+	for _, test := range compactTests {
+		blockData, _ := hex.DecodeString(test.Full)
+		blocks = append(blocks, blockData)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			t.Fatal(err)
+		}
+		compacts = append(compacts, block.ToCompact())
+	}
+	// A block that forks from 289462, with as much work as 289463 (a
+	// different nonce makes it a different block).
+	fork := append([]byte{}, blocks[3]...)
+	fork[4+32+32+32+4+4]++
+
+	os.RemoveAll(unitTestPath)
+	blocksDir := filepath.Join(unitTestPath, "blocks")
+	if err := os.MkdirAll(blocksDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexBlkFiles(blocksDir); err == nil {
+		t.Fatal("index of no blk files unexpected success")
+	}
+	// Out of order, over two files.
+	magic := blkFileMagic["main"]
+	writeBlkFile(t, filepath.Join(blocksDir, "blk00000.dat"), magic, blocks[0], blocks[3], blocks[1])
+	writeBlkFile(t, filepath.Join(blocksDir, "blk00001.dat"), magic, fork, blocks[5], blocks[2], blocks[4])
+	idx, err := IndexBlkFiles(blocksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.ChainName != "main" || idx.FirstHeight != 289460 || idx.Tip != 289465 {
+		t.Fatal("unexpected index ", idx.ChainName, idx.FirstHeight, idx.Tip)
+	}
+
+	// A part of the chain, then the rest.
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, 289460, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		data, _ := proto.Marshal(compacts[i])
+		store.Append(289460+i, encodeBlock(data))
+	}
+	cache := NewBlockCacheFromStore(store, 289460)
+	n, err := idx.ImportInto(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || cache.GetNextHeight() != 289466 {
+		t.Fatal("unexpected import ", n, cache.GetNextHeight())
+	}
+	for i, compact := range compacts {
+		if block := cache.Get(289460 + i); block == nil || !proto.Equal(block, compact) {
+			t.Fatal("unexpected block at ", 289460+i)
+		}
+	}
+	if n, err := idx.ImportInto(cache); n != 0 || err != nil {
+		t.Fatal("unexpected import into full cache ", n, err)
+	}
+
+	// The fork is the best chain if it's longer, and the cache isn't on it.
+	forkChild := append([]byte{}, blocks[4]...)
+	copy(forkChild[4:], rawBlockHash(t, fork))
+	forkChild2 := append([]byte{}, blocks[5]...)
+	copy(forkChild2[4:], rawBlockHash(t, forkChild))
+	forkChild3 := append([]byte{}, blocks[5]...)
+	copy(forkChild3[4:], rawBlockHash(t, forkChild2))
+	writeBlkFile(t, filepath.Join(blocksDir, "blk00002.dat"), magic, forkChild3, forkChild2, forkChild)
+	idx, err = IndexBlkFiles(blocksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Tip != 289466 || !bytes.Equal(idx.best[3].hash, rawBlockHash(t, fork)) {
+		t.Fatal("unexpected best chain with fork, tip ", idx.Tip)
+	}
+	_, err = idx.ImportInto(cache)
+	if err == nil || !strings.Contains(err.Error(), "isn't in the blk files' best chain") {
+		t.Fatal("import into cache not on the best chain unexpected result ", err)
+	}
+	cache.Close()
+
+	// Only one chain's blocks.
+	writeBlkFile(t, filepath.Join(blocksDir, "blk00002.dat"), blkFileMagic["test"], blocks[1])
+	if _, err := IndexBlkFiles(blocksDir); err == nil || !strings.Contains(err.Error(), "unexpected magic") {
+		t.Fatal("index of mixed chains unexpected result ", err)
+	}
+	os.RemoveAll(unitTestPath)
+}
+
+// rawBlockHash returns the hash of the given raw block, as in the
+// following block's prev hash.
+func rawBlockHash(t *testing.T, data []byte) []byte {
+	hdr := parser.NewBlockHeader()
+	if _, err := hdr.ParseFromSlice(data); err != nil {
+		t.Fatal(err)
+	}
+	return hdr.GetEncodableHash()
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// blockIndex is a bbolt database of entries (such as a txid and its
+// transaction) from the cached blocks, kept by the BlockCache in step with
+// its blocks (see SetTxIndex).
+type blockIndex struct {
+	db      *bolt.DB
+	keySize int
+	keep    bool // entries aren't pruned with the cache's blocks
+
+	// The blocks' entries added since the last flush, so that catching up
+	// doesn't take a bbolt transaction (and fsync) per block; the readers
+	// look in pendingKeys too. They're written when the cache syncs, so
+	// at most the last indexFlushBlocks blocks' entries are lost in a
+	// crash (see SetTxIndex and SetNullifierIndex).
+	mutex        sync.Mutex
+	pending      []pendingBlock
+	pendingKeys  map[string]pendingEntry
+	pendingBytes int
+}
+
+// pendingBlock is the entries of the block at a height, not yet written.
+type pendingBlock struct {
+	height  int
+	entries []indexEntry
+}
+
+// pendingEntry is a pending entry's height and value, by its key.
+type pendingEntry struct {
+	height int
+	value  []byte
+}
+
+// The pending entries are written once there are this many blocks' or
+// bytes' worth, if the cache doesn't sync (see BlockCache.Sync) first.
+var (
+	indexFlushBlocks = 1000
+	indexFlushBytes  = 16 << 20
+)
+
+// An indexEntry is a key, of the index's key size, and its value.
+type indexEntry struct {
+	key, value []byte
+}
+
+// The entries bucket maps a key to the height of the block it came from (8
+// bytes, big-endian) then its value. The heights bucket maps a height (as
+// in the bolt store's blocks bucket) to the keys of the block's entries,
+// one after the other (possibly none), so that they can be removed with the
+// block.
+var (
+	indexEntriesBucket = []byte("entries")
+	indexHeightsBucket = []byte("heights")
+)
+
+// openBlockIndex opens (or creates) the chain's index in the named file in
+// dbPath, emptying it if redownload is set.
+func openBlockIndex(dbPath, chainName, fileName string, keySize int, redownload bool) (*blockIndex, error) {
+	if err := os.MkdirAll(filepath.Join(dbPath, chainName), 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir "+dbPath+" failed")
+	}
+	name := filepath.Join(dbPath, chainName, fileName)
+	db, err := bolt.Open(name, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open "+name+" failed")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{indexEntriesBucket, indexHeightsBucket} {
+			if redownload {
+				if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "read "+name+" failed")
+	}
+	return &blockIndex{db: db, keySize: keySize}, nil
+}
+
+// add adds the entries of the block at the given height, which should be
+// above those already added. They're written with those of the blocks
+// after it, by flush, which the methods that remove entries do first.
+func (x *blockIndex) add(height int, entries []indexEntry) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.pending = append(x.pending, pendingBlock{height, entries})
+	if x.pendingKeys == nil {
+		x.pendingKeys = make(map[string]pendingEntry)
+	}
+	for _, entry := range entries {
+		x.pendingKeys[string(entry.key)] = pendingEntry{height, entry.value}
+		x.pendingBytes += len(entry.key) + len(entry.value)
+	}
+	if len(x.pending) < indexFlushBlocks && x.pendingBytes < indexFlushBytes {
+		return nil
+	}
+	return x.flushLocked()
+}
+
+// flush writes the pending entries, in one bbolt transaction.
+func (x *blockIndex) flush() error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.flushLocked()
+}
+
+// flushLocked is flush, for the caller holding x.mutex.
+func (x *blockIndex) flushLocked() error {
+	if len(x.pending) == 0 {
+		return nil
+	}
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		for _, block := range x.pending {
+			if err := x.put(tx, block.height, block.entries); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "index write failed")
+	}
+	x.pending = nil
+	x.pendingKeys = nil
+	x.pendingBytes = 0
+	return nil
+}
+
+// put is add, in the given bbolt transaction.
+func (x *blockIndex) put(tx *bolt.Tx, height int, entries []indexEntry) error {
+	if err := x.removeHeight(tx, boltHeightKey(height)); err != nil {
+		return err
+	}
+	keys := make([]byte, 0, x.keySize*len(entries))
+	b := tx.Bucket(indexEntriesBucket)
+	for _, entry := range entries {
+		if err := b.Put(entry.key, append(boltHeightKey(height), entry.value...)); err != nil {
+			return err
+		}
+		keys = append(keys, entry.key...)
+	}
+	return tx.Bucket(indexHeightsBucket).Put(boltHeightKey(height), keys)
+}
+
+// removeHeight removes the entries of the given height (key).
+func (x *blockIndex) removeHeight(tx *bolt.Tx, key []byte) error {
+	heights := tx.Bucket(indexHeightsBucket)
+	keys := heights.Get(key)
+	for ; len(keys) >= x.keySize; keys = keys[x.keySize:] {
+		if err := tx.Bucket(indexEntriesBucket).Delete(keys[:x.keySize]); err != nil {
+			return err
+		}
+	}
+	return heights.Delete(key)
+}
+
+// remove removes the entries of the blocks from the given height (key), or
+// the lowest if it's nil, up to the first for which more returns false.
+func (x *blockIndex) remove(start []byte, more func(key []byte) bool) error {
+	if err := x.flush(); err != nil {
+		return err
+	}
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		// (Deleting while moving a cursor forward skips keys.)
+		var keys [][]byte
+		cursor := tx.Bucket(indexHeightsBucket).Cursor()
+		var k []byte
+		if start == nil {
+			k, _ = cursor.First()
+		} else {
+			k, _ = cursor.Seek(start)
+		}
+		for ; k != nil && more(k); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, key := range keys {
+			if err := x.removeHeight(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "index remove failed")
+}
+
+// truncate removes the entries at the given height and above.
+func (x *blockIndex) truncate(height int) error {
+	return x.remove(boltHeightKey(height), func([]byte) bool { return true })
+}
+
+// prune removes the entries below the given height, unless the index keeps
+// them.
+func (x *blockIndex) prune(height int) error {
+	if x.keep {
+		return nil
+	}
+	return x.remove(nil, func(key []byte) bool {
+		return int(binary.BigEndian.Uint64(key)) < height
+	})
+}
+
+// fill adds the entries, given by the entries function, of the blocks in
+// [start, end) that the index doesn't have, many blocks at a time.
+func (x *blockIndex) fill(start, end int, entries func(height int) ([]indexEntry, error)) error {
+	if err := x.flush(); err != nil {
+		return err
+	}
+	for start < end {
+		err := x.db.Update(func(tx *bolt.Tx) error {
+			heights := tx.Bucket(indexHeightsBucket)
+			for batchEnd := start + 1000; start < end && start < batchEnd; start++ {
+				if heights.Get(boltHeightKey(start)) != nil {
+					continue
+				}
+				e, err := entries(start)
+				if err != nil {
+					return err
+				}
+				if err := x.put(tx, start, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "index write failed")
+		}
+	}
+	return nil
+}
+
+// get returns the height and value of the given key's entry, or -1 if
+// there isn't one.
+func (x *blockIndex) get(key []byte) (int, []byte, error) {
+	// (The pending entries first: if they're written meanwhile, they'll
+	// be in the database.)
+	x.mutex.Lock()
+	entry, ok := x.pendingKeys[string(key)]
+	x.mutex.Unlock()
+	if ok {
+		return entry.height, entry.value, nil
+	}
+	height := -1
+	var value []byte
+	err := x.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(indexEntriesBucket).Get(key)
+		if len(v) >= 8 {
+			height = int(binary.BigEndian.Uint64(v[:8]))
+			value = append([]byte{}, v[8:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "index read failed")
+	}
+	return height, value, nil
+}
+
+// floor returns the height and value of the entry with the highest key at
+// or below the given key, or -1 if there isn't one.
+func (x *blockIndex) floor(key []byte) (int, []byte, error) {
+	var pendingKey []byte
+	height := -1
+	var value []byte
+	for _, e := range x.pendingFrom(nil) {
+		if bytes.Compare(e.key, key) > 0 {
+			break
+		}
+		pendingKey, height, value = e.key, e.height, e.value
+	}
+	err := x.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(indexEntriesBucket).Cursor()
+		k, v := cursor.Seek(key)
+		if k == nil {
+			k, v = cursor.Last()
+		} else if !bytes.Equal(k, key) {
+			k, v = cursor.Prev()
+		}
+		if k != nil && len(v) >= 8 && (pendingKey == nil || bytes.Compare(k, pendingKey) > 0) {
+			height = int(binary.BigEndian.Uint64(v[:8]))
+			value = append([]byte{}, v[8:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "index read failed")
+	}
+	return height, value, nil
+}
+
+// keyedEntry is a pending entry with its key.
+type keyedEntry struct {
+	key []byte
+	pendingEntry
+}
+
+// pendingFrom returns the pending entries with keys at or above the given
+// key (all of them if it's nil), in key order.
+func (x *blockIndex) pendingFrom(key []byte) []keyedEntry {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	var entries []keyedEntry
+	for k, e := range x.pendingKeys {
+		if key == nil || k >= string(key) {
+			entries = append(entries, keyedEntry{[]byte(k), e})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	return entries
+}
+
+// scan calls fn with each entry's key, height and value, in key order from
+// the given key, until it returns false.
+func (x *blockIndex) scan(key []byte, fn func(key []byte, height int, value []byte) bool) error {
+	pending := x.pendingFrom(key)
+	err := x.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(indexEntriesBucket).Cursor()
+		k, v := cursor.Seek(key)
+		for k != nil || len(pending) > 0 {
+			// The pending and written entries, merged in key order.
+			if len(pending) > 0 && (k == nil || bytes.Compare(pending[0].key, k) <= 0) {
+				e := pending[0]
+				pending = pending[1:]
+				if k != nil && bytes.Equal(e.key, k) {
+					k, v = cursor.Next()
+				}
+				if !fn(e.key, e.height, e.value) {
+					break
+				}
+				continue
+			}
+			if len(v) >= 8 && !fn(k, int(binary.BigEndian.Uint64(v[:8])), v[8:]) {
+				break
+			}
+			k, v = cursor.Next()
+		}
+		return nil
+	})
+	return errors.Wrap(err, "index read failed")
+}
+
+// lastHeight returns the height of the highest block with entries (even
+// none) in the index, or -1 if there isn't one.
+func (x *blockIndex) lastHeight() (int, error) {
+	x.mutex.Lock()
+	if len(x.pending) > 0 {
+		height := x.pending[len(x.pending)-1].height
+		x.mutex.Unlock()
+		return height, nil
+	}
+	x.mutex.Unlock()
+	height := -1
+	err := x.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(indexHeightsBucket).Cursor().Last(); k != nil {
+			height = int(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	return height, errors.Wrap(err, "index read failed")
+}
+
+// Close writes the pending entries and closes the index.
+func (x *blockIndex) Close() error {
+	err := x.flush()
+	if closeErr := x.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
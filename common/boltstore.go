@@ -0,0 +1,254 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore is a BlockStore in a bbolt (embedded key-value) database, so
+// that other data about the blocks can be kept alongside them.
+type boltStore struct {
+	db          *bolt.DB
+	first, next int
+}
+
+// The blocks bucket maps a height (8 bytes, big-endian, so that they're in
+// order) to the block.
+var boltBlocksBucket = []byte("blocks")
+
+func boltHeightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+func openBoltStore(dbPath, chainName string, startHeight int, redownload bool) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Join(dbPath, chainName), 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir "+dbPath+" failed")
+	}
+	name := filepath.Join(dbPath, chainName, "blocks.db")
+	// Don't wait forever if another lightwalletd has the database open.
+	db, err := bolt.Open(name, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open "+name+" failed")
+	}
+	s := &boltStore{db: db, first: startHeight, next: startHeight}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if redownload {
+			if err := tx.DeleteBucket(boltBlocksBucket); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		b, err := tx.CreateBucketIfNotExists(boltBlocksBucket)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
+		if k, _ := cursor.First(); k != nil {
+			s.first = int(binary.BigEndian.Uint64(k))
+			k, _ = cursor.Last()
+			s.next = int(binary.BigEndian.Uint64(k)) + 1
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "read "+name+" failed")
+	}
+	return s, nil
+}
+
+// Heights implements BlockStore.
+func (s *boltStore) Heights() (int, int) {
+	return s.first, s.next
+}
+
+// Append implements BlockStore.
+func (s *boltStore) Append(height int, data []byte) error {
+	if s.first == s.next {
+		s.first, s.next = height, height
+	}
+	if height != s.next {
+		return errors.Errorf("append at height %d, expecting %d", height, s.next)
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlocksBucket).Put(boltHeightKey(height), data)
+	})
+	if err != nil {
+		return errors.Wrap(err, "blocks write failed")
+	}
+	s.next++
+	return nil
+}
+
+// Truncate implements BlockStore.
+func (s *boltStore) Truncate(height int) error {
+	if height < s.first {
+		height = s.first
+	}
+	if height >= s.next {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBlocksBucket)
+		// (Deleting while moving a cursor forward skips keys.)
+		for h := height; h < s.next; h++ {
+			if err := b.Delete(boltHeightKey(h)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "blocks truncate failed")
+	}
+	s.next = height
+	return nil
+}
+
+// Prune implements BlockStore.
+func (s *boltStore) Prune(height int) error {
+	if height <= s.first {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBlocksBucket)
+		for h := s.first; h < height && h < s.next; h++ {
+			if err := b.Delete(boltHeightKey(h)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "blocks prune failed")
+	}
+	if height > s.next {
+		s.next = height
+	}
+	s.first = height
+	return nil
+}
+
+// Read implements BlockStore.
+func (s *boltStore) Read(height int) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBlocksBucket).Get(boltHeightKey(height))
+		if v == nil {
+			return errors.Errorf("no block at height %d", height)
+		}
+		// The value is valid only during the transaction.
+		data = append([]byte{}, v...)
+		return nil
+	})
+	return data, err
+}
+
+// Iterate implements BlockStore.
+func (s *boltStore) Iterate(start int, f func(int, []byte) bool) error {
+	// Read in batches, so that f can change the store (which would
+	// deadlock within a read transaction).
+	const batchSize = 1000
+	if start < s.first {
+		start = s.first
+	}
+	for {
+		var heights []int
+		var blocks [][]byte
+		err := s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(boltBlocksBucket).Cursor()
+			for k, v := cursor.Seek(boltHeightKey(start)); k != nil && len(heights) < batchSize; k, v = cursor.Next() {
+				heights = append(heights, int(binary.BigEndian.Uint64(k)))
+				blocks = append(blocks, append([]byte{}, v...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(heights) == 0 {
+			return nil
+		}
+		for i, height := range heights {
+			if height != start {
+				return errors.Errorf("missing block at height %d", start)
+			}
+			if !f(height, blocks[i]) {
+				return nil
+			}
+			start++
+		}
+	}
+}
+
+// Sync implements BlockStore.
+func (s *boltStore) Sync() error {
+	// Each update is committed (and synced) as it's made.
+	return nil
+}
+
+// Close implements BlockStore.
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// errStopScan stops a scan of the bbolt store.
+var errStopScan = errors.New("scan stopped")
+
+// scanBoltStore is scanFlatFileStore for the bbolt store; the data given
+// to f is valid only during the call.
+func scanBoltStore(dbPath, chainName string, f func(height int, data []byte, problem string) bool) (int, int, error) {
+	name := filepath.Join(dbPath, chainName, "blocks.db")
+	if _, err := os.Stat(name); err != nil {
+		return 0, 0, errors.Wrap(err, "open "+name+" failed")
+	}
+	db, err := bolt.Open(name, 0644, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "open "+name+" failed")
+	}
+	defer db.Close()
+	first, next := -1, 0
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBlocksBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 {
+				return errors.Errorf("bad key %x", k)
+			}
+			height := int(binary.BigEndian.Uint64(k))
+			if first < 0 {
+				first, next = height, height
+			}
+			if height == next+1 && !f(next, nil, "missing") {
+				return errStopScan
+			} else if height > next+1 && !f(next, nil, fmt.Sprint("missing, through height ", height-1)) {
+				return errStopScan
+			}
+			next = height + 1
+			if !f(height, v, "") {
+				return errStopScan
+			}
+			return nil
+		})
+	})
+	if err != nil && err != errStopScan {
+		return 0, 0, errors.Wrap(err, "read "+name+" failed")
+	}
+	if first < 0 {
+		first = 0
+	}
+	return first, next, nil
+}
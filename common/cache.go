@@ -7,27 +7,75 @@ package common
 
 import (
 	"bytes"
-	"encoding/binary"
-	"hash/fnv"
-	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"encoding/hex"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/common/sapling"
+	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
 )
 
 // BlockCache contains a consecutive set of recent compact blocks in marshalled form.
 type BlockCache struct {
-	lengthsName, blocksName string // pathnames
-	lengthsFile, blocksFile *os.File
-	starts                  []int64 // Starting offset of each block within blocksFile
-	firstBlock              int     // height of the first block in the cache (usually Sapling activation)
-	nextBlock               int     // height of the first block not in the cache
-	latestHash              []byte  // hash of the most recent (highest height) block, for detecting reorgs.
-	mutex                   sync.RWMutex
+	store         BlockStore        // nil once closed
+	firstBlock    int               // height of the first block in the cache (usually Sapling activation)
+	nextBlock     int               // height of the first block not in the cache
+	latestHash    []byte            // hash of the most recent (highest height) block, for detecting reorgs.
+	removedHashes map[int][]byte    // hashes of blocks removed by Reorg(), by height
+	recent        *blockLRU         // recently added or read blocks, marshalled
+	retainBlocks  int               // number of recent blocks to keep (0 to keep all)
+	txIndex       *TxIndex          // transactions of the cached blocks, or nil
+	nfIndex       *NullifierIndex   // nullifiers of the cached blocks, or nil
+	treeIndex     *SaplingTreeIndex // Sapling tree checkpoints, or nil
+	tree          *sapling.Tree     // Sapling tree as of the latest block, or nil if not yet computed
+	treeSince     int               // number of commitments added to the tree since its last checkpoint
+	treeSize      int64             // Sapling tree size as of the latest block, or -1 if not yet known
+	treeSizeRetry time.Time         // when to next ask zcashd for the tree size, after a failure
+	saplingHeight int               // Sapling activation height, where the tree is empty
+	events        chainEvents
+	mutex         sync.RWMutex
+}
+
+// CacheCompression is how Add compresses blocks in the blocks file, "none"
+// or "snappy". Blocks are read back however they were written, so this
+// can change without redownloading.
+var CacheCompression = "none"
+
+// A compressed block in the blocks file begins with a zero byte (which
+// can't begin a marshalled CompactBlock, there is no protobuf field zero),
+// then the format; an uncompressed block is stored as is.
+const (
+	blockFormatMarker = 0
+	blockFormatSnappy = 1
+)
+
+// encodeBlock returns the marshalled block as it should be stored.
+func encodeBlock(data []byte) []byte {
+	if CacheCompression != "snappy" {
+		return data
+	}
+	b := append([]byte{blockFormatMarker, blockFormatSnappy}, snappy.Encode(nil, data)...)
+	if len(b) >= len(data) {
+		// Not worth it
+		return data
+	}
+	return b
+}
+
+// decodeBlock returns the marshalled block, given its stored form.
+func decodeBlock(b []byte) ([]byte, error) {
+	if len(b) == 0 || b[0] != blockFormatMarker {
+		return b, nil
+	}
+	if len(b) < 2 || b[1] != blockFormatSnappy {
+		return nil, errors.New("unknown block format")
+	}
+	return snappy.Decode(nil, b[2:])
 }
 
 // GetNextHeight returns the height of the lowest unobtained block.
@@ -64,108 +112,69 @@ func (c *BlockCache) HashMismatch(prevhash []byte) bool {
 // This should never increase the size of the cache, only decrease.
 // Caller should hold c.mutex.Lock().
 func (c *BlockCache) setDbFiles(height int) {
+	if c.store == nil {
+		// Closed (shutting down)
+		return
+	}
 	if height <= c.nextBlock {
 		if height < c.firstBlock {
 			height = c.firstBlock
 		}
-		index := height - c.firstBlock
-		if err := c.lengthsFile.Truncate(int64(index * 4)); err != nil {
-			Log.Fatal("truncate lengths file failed: ", err)
-		}
-		if err := c.blocksFile.Truncate(c.starts[index]); err != nil {
-			Log.Fatal("truncate blocks file failed: ", err)
+		if err := c.store.Truncate(height); err != nil {
+			Log.Fatal("truncate failed: ", err)
 		}
-		c.Sync()
-		c.starts = c.starts[:index+1]
+		c.truncateIndexes(height)
+		c.recent.removeFrom(height)
+		c.store.Sync()
 		c.nextBlock = height
 		c.setLatestHash()
 	}
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
-	}
-	return out.Close()
-}
-
+// recoverFromCorruption discards the blocks from the given height, whose
+// block is bad, to be redownloaded; the blocks below it were checked when
+// they were added or when the cache was opened.
 // Caller should hold c.mutex.Lock().
 func (c *BlockCache) recoverFromCorruption(height int) {
 	Log.Warning("CORRUPTION detected in db blocks-cache files, height ", height, " redownloading")
 
-	// Save the corrupted files for post-mortem analysis.
-	save := c.lengthsName + "-corrupted"
-	if err := copyFile(c.lengthsName, save); err != nil {
-		Log.Warning("Could not copy db lengths file: ", err)
+	if saver, ok := c.store.(corruptionSaver); ok {
+		saver.saveCorrupted()
 	}
-	save = c.blocksName + "-corrupted"
-	if err := copyFile(c.blocksName, save); err != nil {
-		Log.Warning("Could not copy db lengths file: ", err)
-	}
-
 	c.setDbFiles(height)
 }
 
-// not including the checksum
-func (c *BlockCache) blockLength(height int) int {
-	index := height - c.firstBlock
-	return int(c.starts[index+1] - c.starts[index] - 8)
-}
-
-// Calculate the 8-byte checksum that precedes each block in the blocks file.
-func checksum(height int, b []byte) []byte {
-	h := make([]byte, 8)
-	binary.LittleEndian.PutUint64(h, uint64(height))
-	cs := fnv.New64a()
-	cs.Write(h)
-	cs.Write(b)
-	return cs.Sum(nil)
-}
-
 // Caller should hold (at least) c.mutex.RLock().
 func (c *BlockCache) readBlock(height int) *walletrpc.CompactBlock {
-	blockLen := c.blockLength(height)
-	b := make([]byte, blockLen+8)
-	offset := c.starts[height-c.firstBlock]
-	n, err := c.blocksFile.ReadAt(b, offset)
-	if err != nil || n != len(b) {
-		Log.Warning("blocks read offset: ", offset, " failed: ", n, err)
-		return nil
-	}
-	diskcs := b[:8]
-	b = b[8 : blockLen+8]
-	if !bytes.Equal(checksum(height, b), diskcs) {
-		Log.Warning("bad block checksum at height: ", height, " offset: ", offset)
-		return nil
+	if data := c.recent.get(height); data != nil {
+		block := &walletrpc.CompactBlock{}
+		if err := proto.Unmarshal(data, block); err == nil {
+			return block
+		}
 	}
-	block := &walletrpc.CompactBlock{}
-	err = proto.Unmarshal(b, block)
+	b, err := c.store.Read(height)
 	if err != nil {
-		// Could be file corruption.
-		Log.Warning("blocks unmarshal at offset: ", offset, " failed: ", err)
+		Log.Warning("blocks read at height: ", height, " failed: ", err)
 		return nil
 	}
-	if int(block.Height) != height {
-		// Could be file corruption.
-		Log.Warning("block unexpected height at height ", height, " offset: ", offset)
-		return nil
+	block, data := unmarshalBlock(height, b)
+	if block != nil {
+		c.recent.put(height, data)
 	}
 	return block
 }
 
+// unmarshalBlock returns the block and its marshalled form, given its
+// stored form, or nil if it's not valid.
+func unmarshalBlock(height int, b []byte) (*walletrpc.CompactBlock, []byte) {
+	block, data, problem := checkBlock(height, b)
+	if block == nil {
+		// Could be file corruption.
+		Log.Warning("block at height ", height, ": ", problem)
+	}
+	return block, data
+}
+
 // Caller should hold c.mutex.Lock().
 func (c *BlockCache) setLatestHash() {
 	c.latestHash = nil
@@ -174,7 +183,7 @@ func (c *BlockCache) setLatestHash() {
 		// At least one block remains; get the last block's hash
 		block := c.readBlock(c.nextBlock - 1)
 		if block == nil {
-			c.recoverFromCorruption(c.nextBlock - 10000)
+			c.recoverFromCorruption(c.nextBlock - 1)
 			return
 		}
 		c.latestHash = make([]byte, len(block.Hash))
@@ -185,86 +194,412 @@ func (c *BlockCache) setLatestHash() {
 // Reset is used only for darkside testing.
 func (c *BlockCache) Reset(startHeight int) {
 	c.setDbFiles(c.firstBlock) // empty the cache
+	c.recent.removeBelow(c.firstBlock)
+	c.removedHashes = nil
 	c.firstBlock = startHeight
 	c.nextBlock = startHeight
+	c.saplingHeight = startHeight
 }
 
-// NewBlockCache returns an instance of a block cache object.
+// NewBlockCache returns an instance of a block cache object, using the
+// CacheStorage kind of store.
 // (No locking here, we assume this is single-threaded.)
 func NewBlockCache(dbPath string, chainName string, startHeight int, redownload bool) *BlockCache {
-	c := &BlockCache{}
+	store, err := OpenBlockStore(CacheStorage, dbPath, chainName, startHeight, redownload)
+	if err != nil {
+		Log.Fatal("opening block cache failed: ", err)
+	}
+	return NewBlockCacheFromStore(store, startHeight)
+}
+
+// NewBlockCacheFromStore returns a block cache object that keeps its blocks
+// in the given store, checking that they are valid.
+func NewBlockCacheFromStore(store BlockStore, startHeight int) *BlockCache {
+	c := &BlockCache{store: store, recent: newBlockLRU(BlockLRUSize), treeSize: -1}
 	c.firstBlock = startHeight
-	c.nextBlock = startHeight
-	c.lengthsName, c.blocksName = dbFileNames(dbPath, chainName)
-	var err error
-	if err := os.MkdirAll(filepath.Join(dbPath, chainName), 0755); err != nil {
-		Log.Fatal("mkdir ", dbPath, " failed: ", err)
+	c.saplingHeight = startHeight
+	first, next := store.Heights()
+	if first > startHeight {
+		// Older blocks have been pruned (see SetRetention).
+		c.firstBlock = first
+	} else if first != next && first < startHeight {
+		Log.Warning("cache starts at height ", first, ", not ", startHeight, ", redownloading")
+		if err := store.Truncate(first); err != nil {
+			Log.Fatal("truncate failed: ", err)
+		}
 	}
-	c.blocksFile, err = os.OpenFile(c.blocksName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	c.nextBlock = c.firstBlock
+
+	// Check for corruption.
+	err := store.Iterate(c.firstBlock, func(height int, data []byte) bool {
+		if block, _ := unmarshalBlock(height, data); block == nil {
+			return false
+		}
+		c.nextBlock++
+		return true
+	})
 	if err != nil {
-		Log.Fatal("open ", c.blocksName, " failed: ", err)
+		Log.Warning("error reading block: ", err)
+	}
+	if _, next := store.Heights(); c.nextBlock != next {
+		c.recoverFromCorruption(c.nextBlock)
+	}
+	c.setDbFiles(c.nextBlock)
+	Log.Info("Found ", c.nextBlock-c.firstBlock, " blocks in cache")
+	return c
+}
+
+// SetTxIndex makes the cache keep the transactions of the blocks it adds
+// with AddBlock in the given index (and close it with the cache). Any in
+// the index that aren't in the cache's blocks are removed. The compact
+// blocks don't have the transactions, so if the index lost those of the
+// latest blocks (lightwalletd stopped before writing them), those blocks
+// are removed, to be downloaded again.
+func (c *BlockCache) SetTxIndex(txIndex *TxIndex) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.trimIndex(txIndex.blockIndex); err != nil {
+		return err
 	}
-	c.lengthsFile, err = os.OpenFile(c.lengthsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	last, err := txIndex.lastHeight()
 	if err != nil {
-		Log.Fatal("open ", c.lengthsName, " failed: ", err)
+		return err
 	}
-	if redownload {
-		if err := c.lengthsFile.Truncate(0); err != nil {
-			Log.Fatal("truncate lengths file failed: ", err)
+	// A bigger gap is blocks added without the index, not lost entries.
+	if lost := c.nextBlock - (last + 1); last >= c.firstBlock && lost > 0 && lost <= indexFlushBlocks {
+		Log.Warning("the tx index is missing the transactions of blocks from height ", last+1,
+			", redownloading them")
+		c.setDbFiles(last + 1)
+	}
+	c.txIndex = txIndex
+	return nil
+}
+
+// SetNullifierIndex makes the cache keep the nullifiers of the blocks it
+// adds in the given index (and close it with the cache). The nullifiers of
+// the cached blocks that aren't in the index are added to it, and any that
+// aren't in the cache's blocks are removed.
+func (c *BlockCache) SetNullifierIndex(nfIndex *NullifierIndex) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.trimIndex(nfIndex.blockIndex); err != nil {
+		return err
+	}
+	err := nfIndex.fill(c.firstBlock, c.nextBlock, func(height int) ([]indexEntry, error) {
+		b, err := c.store.Read(height)
+		if err != nil {
+			return nil, err
 		}
-		if err := c.blocksFile.Truncate(0); err != nil {
-			Log.Fatal("truncate blocks file failed: ", err)
+		block, _, problem := checkBlock(height, b)
+		if block == nil {
+			return nil, errors.Errorf("bad block at height %d: %s", height, problem)
 		}
+		return nullifierEntries(block), nil
+	})
+	if err != nil {
+		return err
 	}
-	lengths, err := ioutil.ReadFile(c.lengthsName)
+	c.nfIndex = nfIndex
+	return nil
+}
+
+// SetSaplingTreeIndex makes the cache keep the Sapling note commitment
+// tree as of its blocks, so that it can serve tree states without zcashd,
+// with checkpoints in the given index (which it closes with the cache).
+// The tree is computed from the latest checkpoint, or else from the tree
+// before the first block (empty at the given Sapling activation height,
+// otherwise from zcashd), through the cached blocks.
+func (c *BlockCache) SetSaplingTreeIndex(treeIndex *SaplingTreeIndex, saplingHeight int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, index := range []*blockIndex{treeIndex.blockIndex, treeIndex.subtrees} {
+		if err := c.trimIndex(index); err != nil {
+			return err
+		}
+	}
+	c.treeIndex = treeIndex
+	c.saplingHeight = saplingHeight
+	tree, err := c.saplingTree(c.nextBlock-1, true)
 	if err != nil {
-		Log.Fatal("read ", c.lengthsName, " failed: ", err)
-	}
-
-	// The last entry in starts[] is where to write the next block.
-	var offset int64
-	c.starts = nil
-	c.starts = append(c.starts, 0)
-	for i := 0; i < len(lengths)/4; i++ {
-		if len(lengths[:4]) < 4 {
-			Log.Warning("lengths file has a partial entry")
-			c.recoverFromCorruption(c.nextBlock)
-			break
-		}
-		length := binary.LittleEndian.Uint32(lengths[i*4 : (i+1)*4])
-		if length < 74 || length > 4*1000*1000 {
-			Log.Warning("lengths file has impossible value ", length)
-			c.recoverFromCorruption(c.nextBlock)
-			break
-		}
-		offset += int64(length) + 8
-		c.starts = append(c.starts, offset)
-		// Check for corruption.
-		block := c.readBlock(c.nextBlock)
+		c.treeIndex = nil
+		return err
+	}
+	c.tree = tree
+	return nil
+}
+
+// saplingTree returns the Sapling tree as of the block at the given height
+// (which may be just below the first block), computed from the latest
+// checkpoint at or below it through the cached blocks. If checkpoint is
+// set (for which the caller should hold c.mutex.Lock()), it adds
+// checkpoints on the way and, if there's no checkpoint to start from,
+// starts from the tree before the first block; otherwise it returns nil.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) saplingTree(height int, checkpoint bool) (*sapling.Tree, error) {
+	start, tree, err := c.treeIndex.checkpoint(height)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil || start < c.firstBlock {
+		if !checkpoint {
+			return nil, nil
+		}
+		start = c.firstBlock - 1
+		if start < c.saplingHeight {
+			tree = sapling.NewTree()
+		} else if tree, err = getSaplingTreeFromRPC(start); err != nil {
+			return nil, errors.Wrap(err, "couldn't get the Sapling tree before the cache")
+		}
+		if start >= c.saplingHeight {
+			// The roots of the subtrees completed before the cache
+			if err := c.treeIndex.fillSubtreeRoots(uint32(tree.Size() >> uint(saplingSubtreeDepth))); err != nil {
+				Log.Warning("couldn't get the Sapling subtree roots before the cache: ", err)
+			}
+		}
+		if height-start > saplingTreeCheckpointBlocks {
+			Log.Info("Computing the Sapling tree from height ", start+1, " to ", height)
+		}
+	}
+	since := 0
+	for h := start + 1; h <= height; h++ {
+		b, err := c.store.Read(h)
+		if err != nil {
+			return nil, err
+		}
+		block, _, problem := checkBlock(h, b)
 		if block == nil {
-			Log.Warning("error reading block")
-			c.recoverFromCorruption(c.nextBlock)
-			break
+			return nil, errors.Errorf("bad block at height %d: %s", h, problem)
+		}
+		n, subtrees, err := appendCommitments(tree, block)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint && len(subtrees) > 0 {
+			if err := c.treeIndex.subtrees.add(h, subtrees); err != nil {
+				return nil, err
+			}
+		}
+		since += n
+		if checkpoint && c.isTreeCheckpoint(h, since) {
+			if err := c.treeIndex.addCheckpoint(h, tree); err != nil {
+				return nil, err
+			}
+			since = 0
 		}
-		c.nextBlock++
 	}
-	c.setDbFiles(c.nextBlock)
-	Log.Info("Found ", c.nextBlock-c.firstBlock, " blocks in cache")
-	return c
+	if checkpoint {
+		c.treeSince = since
+	}
+	return tree, nil
+}
+
+// isTreeCheckpoint returns whether the tree as of the block at the given
+// height, with the given number of commitments since the last checkpoint,
+// should be checkpointed.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) isTreeCheckpoint(height, since int) bool {
+	return height == c.firstBlock || height%saplingTreeCheckpointBlocks == 0 ||
+		since >= saplingTreeCheckpointCommitments
+}
+
+// addToSaplingTree adds the block's note commitments to the tree, checking
+// its root against the full block's header if it's given. If that fails,
+// the cache stops keeping the tree (tree states come from zcashd).
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) addToSaplingTree(height int, block *walletrpc.CompactBlock, full *parser.Block) {
+	var err error
+	if c.tree == nil {
+		// After a reorg, or if computing the tree failed before
+		if c.tree, err = c.saplingTree(height-1, true); err != nil {
+			Log.WithFields(logrus.Fields{
+				"height": height,
+				"error":  err,
+			}).Warning("couldn't compute the Sapling tree, will retry")
+			return
+		}
+	}
+	n, subtrees, err := appendCommitments(c.tree, block)
+	if err == nil && n > 0 && full != nil && !bytes.Equal(c.tree.Root(), full.GetFinalSaplingRoot()) {
+		err = errors.New("root doesn't match the block header")
+	}
+	if err != nil {
+		Log.WithFields(logrus.Fields{
+			"height": height,
+			"error":  err,
+		}).Error("bad Sapling tree, no longer keeping it")
+		for _, index := range []*blockIndex{c.treeIndex.blockIndex, c.treeIndex.subtrees} {
+			index.truncate(c.firstBlock)
+			index.Close()
+		}
+		c.treeIndex = nil
+		c.tree = nil
+		return
+	}
+	if len(subtrees) > 0 {
+		if err := c.treeIndex.subtrees.add(height, subtrees); err != nil {
+			Log.Fatal("cache.Add failed: ", err)
+		}
+	}
+	c.treeSince += n
+	if c.isTreeCheckpoint(height, c.treeSince) {
+		if err := c.treeIndex.addCheckpoint(height, c.tree); err != nil {
+			Log.Fatal("cache.Add failed: ", err)
+		}
+		c.treeSince = 0
+	}
+}
+
+// trimIndex removes the index's entries that aren't from the cache's blocks.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) trimIndex(index *blockIndex) error {
+	if err := index.truncate(c.nextBlock); err != nil {
+		return err
+	}
+	return index.prune(c.firstBlock)
+}
+
+// indexes returns the indexes that the cache keeps in step with its blocks.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) indexes() []*blockIndex {
+	var indexes []*blockIndex
+	if c.txIndex != nil {
+		indexes = append(indexes, c.txIndex.blockIndex)
+	}
+	if c.nfIndex != nil {
+		indexes = append(indexes, c.nfIndex.blockIndex)
+	}
+	if c.treeIndex != nil {
+		indexes = append(indexes, c.treeIndex.blockIndex, c.treeIndex.subtrees)
+	}
+	return indexes
+}
+
+// truncateIndexes removes the indexes' entries at the given height and
+// above, and forgets the Sapling tree and its size as of the latest block
+// (see addToSaplingTree and setChainMetadata).
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) truncateIndexes(height int) {
+	for _, index := range c.indexes() {
+		if err := index.truncate(height); err != nil {
+			Log.Fatal("truncate failed: ", err)
+		}
+	}
+	c.tree = nil
+	c.treeSize = -1
+}
+
+// treeSizeRetryDelay is how long the cache waits, after failing to get
+// the Sapling tree size from zcashd, before asking again.
+var treeSizeRetryDelay = time.Minute
+
+// setChainMetadata sets the block's chain metadata, from the Sapling tree
+// size as of the block before it (see localTreeSize), else the given size
+// from zcashd (see zcashdTreeSize) if it's not negative. Otherwise the
+// block has no metadata.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) setChainMetadata(height int, block *walletrpc.CompactBlock, zcashdSize int64) {
+	block.ChainMetadata = nil
+	size, ok := c.localTreeSize(height - 1)
+	if !ok {
+		if zcashdSize < 0 {
+			return
+		}
+		size = zcashdSize
+	}
+	for _, tx := range block.Vtx {
+		size += int64(len(tx.Outputs))
+	}
+	block.ChainMetadata = &walletrpc.ChainMetadata{SaplingCommitmentTreeSize: uint32(size)}
+	c.treeSize = size
+}
+
+// localTreeSize returns the size of the Sapling tree as of the block at the
+// given height, which is the latest block or the one before the first,
+// without asking zcashd: the running count, else the tree's size if the
+// cache keeps it, else the block's metadata (which is how it survives a
+// reorg or restart). It returns false if none of those is available.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) localTreeSize(height int) (int64, bool) {
+	if c.treeSize >= 0 {
+		return c.treeSize, true
+	}
+	if c.tree != nil {
+		return int64(c.tree.Size()), true
+	}
+	if height < c.saplingHeight {
+		return 0, true
+	}
+	if height >= c.firstBlock {
+		if block := c.readBlock(height); block != nil && block.ChainMetadata != nil {
+			return int64(block.ChainMetadata.SaplingCommitmentTreeSize), true
+		}
+	}
+	return 0, false
 }
 
-func dbFileNames(dbPath string, chainName string) (string, string) {
-	return filepath.Join(dbPath, chainName, "lengths"),
-		filepath.Join(dbPath, chainName, "blocks")
+// zcashdTreeSize returns the size of zcashd's Sapling tree as of the block
+// at the given height if the cache needs it for the next block's chain
+// metadata (see localTreeSize) and zcashd has it, else -1. It doesn't hold
+// the cache's lock while asking zcashd, and after a failure it doesn't ask
+// again for a while (see treeSizeRetryDelay).
+func (c *BlockCache) zcashdTreeSize(height int) int64 {
+	c.mutex.RLock()
+	_, ok := c.localTreeSize(height)
+	next := c.nextBlock
+	retry := c.treeSizeRetry
+	c.mutex.RUnlock()
+	if ok || height+1 != next || time.Now().Before(retry) {
+		return -1
+	}
+	err := ErrZcashdUnavailable
+	var tree *sapling.Tree
+	if !ZcashdUnavailable() {
+		tree, err = getSaplingTreeFromRPC(height)
+	}
+	if err != nil {
+		Log.WithFields(logrus.Fields{
+			"height": height,
+			"error":  err,
+		}).Warning("couldn't get the Sapling tree size, no chain metadata until it's known")
+		c.mutex.Lock()
+		c.treeSizeRetry = time.Now().Add(treeSizeRetryDelay)
+		c.mutex.Unlock()
+		return -1
+	}
+	return int64(tree.Size())
 }
 
 // Add adds the given block to the cache at the given height, returning true
-// if a reorg was detected.
+// if a reorg was detected. The cache takes ownership of the block, which it
+// may pass to subscribers (see Subscribe): it sets its chain metadata (see
+// setChainMetadata), and the caller shouldn't change it after.
 func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) error {
+	return c.add(height, block, nil)
+}
+
+// AddBlock is Add, given the full block, so that its transactions can be
+// kept in the tx index (see SetTxIndex) and the Sapling tree checked
+// against its header (see SetSaplingTreeIndex).
+func (c *BlockCache) AddBlock(height int, block *parser.Block) error {
+	compact, err := toCompact(block)
+	if err != nil {
+		return err
+	}
+	return c.add(height, compact, block)
+}
+
+func (c *BlockCache) add(height int, block *walletrpc.CompactBlock, full *parser.Block) error {
+	// (Before taking the lock, so that readers don't wait for zcashd)
+	zcashdSize := c.zcashdTreeSize(height - 1)
+
 	// Invariant: m[firstBlock..nextBlock) are valid.
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.store == nil {
+		// Closed (shutting down)
+		return nil
+	}
 	if height > c.nextBlock {
 		// Cache has been reset (for example, checksum error)
 		return nil
@@ -288,42 +623,102 @@ func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) error {
 		return nil
 	}
 
-	// Add the new block and its length to the db files.
+	c.setChainMetadata(height, block, zcashdSize)
+
+	// Add the new block to the store.
 	data, err := proto.Marshal(block)
 	if err != nil {
 		return err
 	}
-	b := append(checksum(height, data), data...)
-	n, err := c.blocksFile.Write(b)
-	if err != nil {
-		Log.Fatal("blocks write failed: ", err)
+	if err := c.store.Append(height, encodeBlock(data)); err != nil {
+		Log.Fatal("cache.Add failed: ", err)
 	}
-	if n != len(b) {
-		Log.Fatal("blocks write incorrect length: expected: ", len(b), "written: ", n)
+	if c.txIndex != nil && full != nil {
+		if err := c.txIndex.add(height, txIndexEntries(full.Transactions())); err != nil {
+			Log.Fatal("cache.Add failed: ", err)
+		}
 	}
-	b = make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, uint32(len(data)))
-	n, err = c.lengthsFile.Write(b)
-	if err != nil {
-		Log.Fatal("lengths write failed: ", err)
+	if c.nfIndex != nil {
+		if err := c.nfIndex.add(height, nullifierEntries(block)); err != nil {
+			Log.Fatal("cache.Add failed: ", err)
+		}
 	}
-	if n != len(b) {
-		Log.Fatal("lengths write incorrect length: expected: ", len(b), "written: ", n)
+	if c.treeIndex != nil {
+		c.addToSaplingTree(height, block, full)
 	}
+	c.recent.put(height, data)
 
 	// update the in-memory variables
-	offset := c.starts[len(c.starts)-1]
-	c.starts = append(c.starts, offset+int64(len(data)+8))
 
 	if c.latestHash == nil {
 		c.latestHash = make([]byte, len(block.Hash))
 	}
 	copy(c.latestHash, block.Hash)
 	c.nextBlock++
+	c.prune()
+	c.publishAdd(height, block)
 	// Invariant: m[firstBlock..nextBlock) are valid.
 	return nil
 }
 
+// SetRetention makes the cache keep only (about) the given number of the
+// most recent blocks, or all of them if it's zero; requests for older
+// blocks go to zcashd. If the cache would have none of its blocks left once
+// it has caught up to the given tip height, it starts over near the tip.
+func (c *BlockCache) SetRetention(blocks int, tipHeight int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retainBlocks = blocks
+	if blocks <= 0 || c.store == nil {
+		return
+	}
+	if start := tipHeight - blocks + 1; start > c.nextBlock {
+		Log.Info("Cache keeps only ", blocks, " blocks, starting at height ", start)
+		c.setDbFiles(c.firstBlock) // empty the cache
+		if err := c.store.Prune(start); err != nil {
+			Log.Fatal("prune failed: ", err)
+		}
+		c.firstBlock = start
+		c.nextBlock = start
+		c.latestHash = nil
+		return
+	}
+	c.prune()
+}
+
+// prune removes the oldest blocks if there are too many. It lets the
+// cache grow somewhat beyond the number of blocks to retain, so that
+// (for the flat store) it doesn't rewrite its files for every block.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) prune() {
+	if c.retainBlocks <= 0 {
+		return
+	}
+	slack := c.retainBlocks / 10
+	if slack < 100 {
+		slack = 100
+	}
+	if c.nextBlock-c.firstBlock <= c.retainBlocks+slack {
+		return
+	}
+	height := c.nextBlock - c.retainBlocks
+	if err := c.store.Prune(height); err != nil {
+		Log.Fatal("prune failed: ", err)
+	}
+	for _, index := range c.indexes() {
+		if err := index.prune(height); err != nil {
+			Log.Fatal("prune failed: ", err)
+		}
+	}
+	c.recent.removeBelow(height)
+	for h := range c.removedHashes {
+		if h < height {
+			delete(c.removedHashes, h)
+		}
+	}
+	c.firstBlock = height
+}
+
 // Reorg resets nextBlock (the block that should be Add()ed next)
 // downward to the given height.
 func (c *BlockCache) Reorg(height int) {
@@ -334,21 +729,18 @@ func (c *BlockCache) Reorg(height int) {
 	if height < c.firstBlock {
 		height = c.firstBlock
 	}
-	if height >= c.nextBlock {
-		// Timing window, ignore this request
+	if height >= c.nextBlock || c.store == nil {
+		// Timing window, or closed; ignore this request
 		return
 	}
 	// Remove the end of the cache.
+	c.rememberRemoved(height)
 	c.nextBlock = height
-	newCacheLen := height - c.firstBlock
-	c.starts = c.starts[:newCacheLen+1]
-
-	if err := c.lengthsFile.Truncate(int64(4 * newCacheLen)); err != nil {
-		Log.Fatal("truncate failed: ", err)
-	}
-	if err := c.blocksFile.Truncate(c.starts[newCacheLen]); err != nil {
+	if err := c.store.Truncate(height); err != nil {
 		Log.Fatal("truncate failed: ", err)
 	}
+	c.truncateIndexes(height)
+	c.recent.removeFrom(height)
 	c.setLatestHash()
 }
 
@@ -358,7 +750,7 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	if height < c.firstBlock || height >= c.nextBlock {
+	if height < c.firstBlock || height >= c.nextBlock || c.store == nil {
 		return nil
 	}
 	block := c.readBlock(height)
@@ -366,7 +758,7 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 		go func() {
 			// We hold only the read lock, need the exclusive lock.
 			c.mutex.Lock()
-			c.recoverFromCorruption(height - 10000)
+			c.recoverFromCorruption(height)
 			c.mutex.Unlock()
 		}()
 		return nil
@@ -374,6 +766,129 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 	return block
 }
 
+// GetMarshalled returns the compact block at the requested height, in
+// marshalled form, if it's in the cache, else nil. Unlike Get, it doesn't
+// unmarshal the block if the store checks it (the flat store's checksums);
+// other stores' blocks are checked as Get does.
+func (c *BlockCache) GetMarshalled(height int) []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if height < c.firstBlock || height >= c.nextBlock || c.store == nil {
+		return nil
+	}
+	if data := c.recent.get(height); data != nil {
+		return data
+	}
+	b, err := c.store.Read(height)
+	if err == nil {
+		if _, ok := c.store.(checksummedStore); ok {
+			b, err = decodeBlock(b)
+		} else if block, data, problem := checkBlock(height, b); block != nil {
+			b = data
+		} else {
+			err = errors.New(problem)
+		}
+	}
+	if err != nil {
+		Log.Warning("blocks read at height: ", height, " failed: ", err)
+		go func() {
+			// We hold only the read lock, need the exclusive lock.
+			c.mutex.Lock()
+			c.recoverFromCorruption(height)
+			c.mutex.Unlock()
+		}()
+		return nil
+	}
+	c.recent.put(height, b)
+	return b
+}
+
+// GetTransaction returns the transaction with the given (little-endian)
+// txid if it's in the tx index, else nil.
+func (c *BlockCache) GetTransaction(txid []byte) *walletrpc.RawTransaction {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.txIndex == nil {
+		return nil
+	}
+	tx, err := c.txIndex.Get(txid)
+	if err != nil {
+		Log.Warning(err)
+	}
+	return tx
+}
+
+// GetNullifierStatus returns the status of the given nullifier in the
+// cached blocks.
+func (c *BlockCache) GetNullifierStatus(nf *walletrpc.Nullifier) (*walletrpc.NullifierStatus, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.nfIndex == nil {
+		return nil, ErrNoNullifierIndex
+	}
+	status, err := c.nfIndex.Get(nf)
+	if err == nil && !status.Spent && c.firstBlock > c.saplingHeight {
+		// The index has only the cached (not pruned) blocks' nullifiers.
+		return nil, ErrNullifierUnknown
+	}
+	return status, err
+}
+
+// GetTreeState returns the Sapling tree state as of the cached block at
+// the given height, or nil if the cache doesn't keep the tree or doesn't
+// have a checkpoint to compute it from.
+func (c *BlockCache) GetTreeState(height int) *walletrpc.TreeState {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.treeIndex == nil || height < c.firstBlock || height >= c.nextBlock {
+		return nil
+	}
+	tree := c.tree
+	if height < c.nextBlock-1 || tree == nil {
+		var err error
+		if tree, err = c.saplingTree(height, false); err != nil {
+			Log.Warning("couldn't compute the Sapling tree at height ", height, ": ", err)
+		}
+		if tree == nil {
+			return nil
+		}
+	}
+	block := c.readBlock(height)
+	if block == nil {
+		return nil
+	}
+	return &walletrpc.TreeState{
+		Height: uint64(height),
+		Hash:   hex.EncodeToString(parser.Reverse(block.Hash)),
+		Time:   block.Time,
+		Tree:   hex.EncodeToString(tree.Bytes()),
+	}
+}
+
+// GetSubtreeRoots returns the roots of the complete Sapling subtrees (see
+// sapling.SubtreeDepth) from the given index, at most max (or all if it's
+// zero), or nil if the cache doesn't keep the tree or doesn't have the
+// root at the start index.
+func (c *BlockCache) GetSubtreeRoots(start, max uint32) []*walletrpc.SubtreeRoot {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.treeIndex == nil || c.tree == nil {
+		return nil
+	}
+	if uint64(start) >= c.tree.Size()>>uint(saplingSubtreeDepth) {
+		return []*walletrpc.SubtreeRoot{}
+	}
+	roots, err := c.treeIndex.getSubtreeRoots(start, max)
+	if err != nil {
+		Log.Warning("couldn't read the Sapling subtree roots: ", err)
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	return roots
+}
+
 // GetLatestHeight returns the height of the most recent block, or -1
 // if the cache is empty.
 func (c *BlockCache) GetLatestHeight() int {
@@ -386,20 +901,35 @@ func (c *BlockCache) GetLatestHeight() int {
 }
 
 // Sync ensures that the db files are flushed to disk, can be called unnecessarily.
+// The indexes are written first, so that they have the committed blocks'
+// entries.
 func (c *BlockCache) Sync() {
-	c.lengthsFile.Sync()
-	c.blocksFile.Sync()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, index := range c.indexes() {
+		if err := index.flush(); err != nil {
+			Log.Warning("index sync failed: ", err)
+		}
+	}
+	if c.store != nil {
+		c.store.Sync()
+	}
 }
 
-// Close is Currently used only for testing.
+// Close closes the db files, after any Add() or Reorg() in progress; later
+// calls to those do nothing.
 func (c *BlockCache) Close() {
-	// Some operating system require you to close files before you can remove them.
-	if c.lengthsFile != nil {
-		c.lengthsFile.Close()
-		c.lengthsFile = nil
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.store != nil {
+		c.store.Close()
+		c.store = nil
 	}
-	if c.blocksFile != nil {
-		c.blocksFile.Close()
-		c.blocksFile = nil
+	for _, index := range c.indexes() {
+		index.Close()
 	}
+	c.txIndex = nil
+	c.nfIndex = nil
+	c.treeIndex = nil
+	c.tree = nil
 }
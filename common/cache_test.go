@@ -4,12 +4,16 @@
 package common
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/common/sapling"
 	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
 )
@@ -97,6 +101,189 @@ func TestCache(t *testing.T) {
 	os.RemoveAll(unitTestPath)
 }
 
+func TestChainEvents(t *testing.T) {
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+	events, cancel := c.Subscribe()
+
+	expect := func(kind walletrpc.ChainEvent_Kind, height int, hash, oldHash []byte) {
+		select {
+		case e := <-events:
+			if e.Kind != kind || int(e.Height) != height ||
+				!bytes.Equal(e.Hash, hash) || !bytes.Equal(e.OldHash, oldHash) {
+				t.Fatal("unexpected chain event: ", e)
+			}
+		default:
+			t.Fatal("missing chain event, expected height ", height)
+		}
+	}
+	expectNone := func() {
+		select {
+		case e := <-events:
+			t.Fatal("unexpected chain event: ", e)
+		default:
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Add(289460+i, compacts[i]); err != nil {
+			t.Fatal(err)
+		}
+		expect(walletrpc.ChainEvent_BLOCK_ADDED, 289460+i, compacts[i].Hash, nil)
+	}
+
+	// The ingestor backs up one block when it's waiting at the tip, then
+	// re-adds the same block; that's not a reorg and isn't reported.
+	c.Reorg(289462)
+	expectNone()
+	if err := c.Add(289462, compacts[2]); err != nil {
+		t.Fatal(err)
+	}
+	expectNone()
+
+	// Replace the top two blocks with a different chain.
+	c.Reorg(289462)
+	c.Reorg(289461)
+	expectNone()
+	forked := proto.Clone(compacts[1]).(*walletrpc.CompactBlock)
+	forked.Hash = []byte("a different block hash..........")
+	if err := c.Add(289461, forked); err != nil {
+		t.Fatal(err)
+	}
+	expect(walletrpc.ChainEvent_REORG, 289460, forked.Hash, compacts[1].Hash)
+	expect(walletrpc.ChainEvent_BLOCK_ADDED, 289461, forked.Hash, nil)
+	if err := c.Add(289462, compacts[2]); err != nil {
+		t.Fatal(err)
+	}
+	expect(walletrpc.ChainEvent_BLOCK_ADDED, 289462, compacts[2].Hash, nil)
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("events channel should be closed after cancel")
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func TestCacheCompression(t *testing.T) {
+	defer func() { CacheCompression = "none" }()
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+
+	// The first half of the blocks uncompressed, the rest compressed
+	// (unless that doesn't make them smaller).
+	blocks := append([]*walletrpc.CompactBlock{}, compacts...)
+	padded := proto.Clone(compacts[0]).(*walletrpc.CompactBlock)
+	padded.Height = uint64(289460 + len(blocks))
+	padded.Header = make([]byte, 1000)
+	blocks = append(blocks, padded)
+	compressed := 0
+	for i, compact := range blocks {
+		if i == len(blocks)/2 {
+			CacheCompression = "snappy"
+		}
+		if err := c.Add(289460+i, compact); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := proto.Marshal(compact)
+		storedData, err := c.store.Read(289460 + i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := len(storedData)
+		if CacheCompression == "none" && stored != len(data) {
+			t.Fatal("unexpected uncompressed length ", stored, " at ", i)
+		}
+		if stored > len(data) {
+			t.Fatal("stored block larger than uncompressed at ", i)
+		}
+		if stored < len(data) {
+			compressed++
+		}
+	}
+	if compressed == 0 {
+		t.Fatal("no blocks compressed")
+	}
+	c.Close()
+
+	// Both kinds are read back after a restart.
+	c = NewBlockCache(unitTestPath, unitTestChain, 289460, false)
+	if c.GetNextHeight() != 289460+len(blocks) {
+		t.Fatal("unexpected next height ", c.GetNextHeight())
+	}
+	for i, compact := range blocks {
+		if !proto.Equal(c.Get(289460+i), compact) {
+			t.Fatal("unexpected block contents at ", i)
+		}
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+
+	if _, err := decodeBlock([]byte{blockFormatMarker, 99, 1, 2, 3}); err == nil {
+		t.Fatal("decodeBlock unexpected success with unknown format")
+	}
+}
+
+func TestBlockLRU(t *testing.T) {
+	l := newBlockLRU(30)
+	for h := 0; h < 3; h++ {
+		l.put(h, bytes.Repeat([]byte{byte(h)}, 10))
+	}
+	l.get(0) // now most recently used
+	l.put(3, bytes.Repeat([]byte{3}, 10))
+	if l.get(1) != nil {
+		t.Fatal("least recently used block not evicted")
+	}
+	for _, h := range []int{0, 2, 3} {
+		if !bytes.Equal(l.get(h), bytes.Repeat([]byte{byte(h)}, 10)) {
+			t.Fatal("unexpected block ", h)
+		}
+	}
+	l.put(4, make([]byte, 31)) // too big
+	if l.get(4) != nil || l.bytes != 30 {
+		t.Fatal("block larger than the LRU unexpectedly kept")
+	}
+	l.removeFrom(3)
+	l.removeBelow(1)
+	if l.get(0) != nil || l.get(3) != nil || l.get(2) == nil || l.bytes != 10 {
+		t.Fatal("unexpected blocks after removal")
+	}
+
+	// The cache doesn't return a block from memory that a reorg replaced.
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+	for i := 0; i < 3; i++ {
+		if err := c.Add(289460+i, compacts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.recent.get(289462) == nil {
+		t.Fatal("added block not in memory")
+	}
+	c.Reorg(289461)
+	forked := proto.Clone(compacts[1]).(*walletrpc.CompactBlock)
+	forked.Hash = []byte("a different block hash..........")
+	if err := c.Add(289461, forked); err != nil {
+		t.Fatal(err)
+	}
+	if c.recent.get(289462) != nil {
+		t.Fatal("reorged block still in memory")
+	}
+	if !bytes.Equal(c.Get(289461).Hash, forked.Hash) {
+		t.Fatal("unexpected block after reorg")
+	}
+	c.Reset(289460)
+	if c.recent.bytes != 0 {
+		t.Fatal("blocks in memory after reset")
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func storedBlocks(c *BlockCache) int {
+	first, next := c.store.Heights()
+	return next - first
+}
+
 func reorgCache(t *testing.T) {
 	// Simulate a reorg by adding a block whose height is lower than the latest;
 	// we're replacing the second block, so there should be only two blocks.
@@ -111,8 +298,8 @@ func reorgCache(t *testing.T) {
 	if cache.nextBlock != 289462 {
 		t.Fatal("unexpected nextBlock height")
 	}
-	if len(cache.starts) != 3 {
-		t.Fatal("unexpected len(cache.starts)")
+	if storedBlocks(cache) != 2 {
+		t.Fatal("unexpected number of stored blocks")
 	}
 
 	// some "black-box" tests (using exported interfaces)
@@ -134,8 +321,8 @@ func reorgCache(t *testing.T) {
 	if cache.nextBlock != 289463 {
 		t.Fatal("unexpected nextBlock height")
 	}
-	if len(cache.starts) != 4 {
-		t.Fatal("unexpected len(cache.starts)")
+	if storedBlocks(cache) != 3 {
+		t.Fatal("unexpected number of stored blocks")
 	}
 
 	if cache.GetLatestHeight() != 289462 {
@@ -165,8 +352,8 @@ func fillCache(t *testing.T) {
 		if cache.nextBlock != 289460+i+1 {
 			t.Fatal("unexpected nextBlock height")
 		}
-		if len(cache.starts) != i+2 {
-			t.Fatal("unexpected len(cache.starts)")
+		if storedBlocks(cache) != i+1 {
+			t.Fatal("unexpected number of stored blocks")
 		}
 
 		// some "black-box" tests (using exported interfaces)
@@ -182,3 +369,152 @@ func fillCache(t *testing.T) {
 		}
 	}
 }
+
+func TestChainMetadata(t *testing.T) {
+	const start = 1000
+	saveChain, saveDelay := Chain, treeSizeRetryDelay
+	defer func() { Chain, treeSizeRetryDelay = saveChain, saveDelay }()
+	stub := NewStubBackend("main", start)
+	Chain = stub
+
+	// Block i has i outputs (on the original chain).
+	makeBlock := func(height, fork int) *walletrpc.CompactBlock {
+		block := &walletrpc.CompactBlock{
+			Height:   uint64(height),
+			PrevHash: bytes.Repeat([]byte{byte(height - start - 1), byte(fork)}, 16),
+			Hash:     bytes.Repeat([]byte{byte(height - start), byte(fork)}, 16),
+			Vtx:      []*walletrpc.CompactTx{{}},
+		}
+		for i := 0; i < height-start+fork; i++ {
+			block.Vtx[0].Outputs = append(block.Vtx[0].Outputs, &walletrpc.CompactOutput{Cmu: make([]byte, 32)})
+		}
+		return block
+	}
+	check := func(cache *BlockCache, height int, size uint32) {
+		block := cache.Get(height)
+		if block == nil || block.ChainMetadata == nil || block.ChainMetadata.SaplingCommitmentTreeSize != size {
+			t.Fatal("unexpected chain metadata at height ", height, ": ", block)
+		}
+	}
+
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, start, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCacheFromStore(store, start)
+	for height := start; height < start+5; height++ {
+		if err := cache.Add(height, makeBlock(height, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 0, 1, 3, 6, 10
+	for height, size := start, uint32(0); height < start+5; height++ {
+		size += uint32(height - start)
+		check(cache, height, size)
+	}
+
+	// After a reorg, the count follows the new blocks.
+	cache.Reorg(start + 3)
+	if err := cache.Add(start+3, makeBlock(start+3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	check(cache, start+3, 7)
+
+	// It continues from the latest block after a restart.
+	cache.Close()
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start)
+	if err := cache.Add(start+4, makeBlock(start+4, 1)); err != nil {
+		t.Fatal(err)
+	}
+	check(cache, start+4, 12)
+	cache.Close()
+
+	// A cache that begins after Sapling activation starts from the size
+	// of zcashd's tree before its first block.
+	os.RemoveAll(unitTestPath)
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start+5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start+5)
+	cache.saplingHeight = start
+	if err := cache.Add(start+5, makeBlock(start+5, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if block := cache.Get(start + 5); block == nil || block.ChainMetadata != nil {
+		t.Fatal("unexpected chain metadata without zcashd's tree state")
+	}
+	// After that failure, zcashd isn't asked again for a while.
+	treeState := &ZcashdRpcReplyGettreestate{Height: start + 5}
+	stub.AddTreeState(treeState)
+	if err := cache.Add(start+6, makeBlock(start+6, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if block := cache.Get(start + 6); block == nil || block.ChainMetadata != nil {
+		t.Fatal("unexpected chain metadata while backing off")
+	}
+	cache.Reorg(start + 6)
+	treeSizeRetryDelay = 0
+	cache.treeSizeRetry = time.Time{}
+	tree := sapling.NewTree()
+	for i := 0; i < 10; i++ {
+		tree.Append(make([]byte, 32))
+	}
+	treeState.Sapling.Commitments.FinalState = hex.EncodeToString(tree.Bytes())
+	stub.AddTreeState(treeState)
+	if err := cache.Add(start+6, makeBlock(start+6, 0)); err != nil {
+		t.Fatal(err)
+	}
+	check(cache, start+6, 16)
+	cache.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func TestCompactBlockHeaders(t *testing.T) {
+	defer func() { CompactBlockHeaders = false }()
+	type compactTest struct {
+		Full string `json:"full"`
+	}
+	var compactTests []compactTest
+	blockJSON, err := ioutil.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(blockJSON, &compactTests); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+	for i, test := range compactTests[:2] {
+		// Only the second block has its header.
+		CompactBlockHeaders = i == 1
+		blockData, _ := hex.DecodeString(test.Full)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddBlock(289460+i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	// The header is kept in the cache.
+	c = NewBlockCache(unitTestPath, unitTestChain, 289460, false)
+	if block := c.Get(289460); block == nil || block.ProtoVersion != 0 || block.Header != nil {
+		t.Fatal("unexpected header without CompactBlockHeaders: ", block)
+	}
+	full, _ := hex.DecodeString(compactTests[1].Full)
+	block := c.Get(289461)
+	if block == nil || block.ProtoVersion != parser.CompactBlockProtoVersion ||
+		len(block.Header) == 0 || !bytes.HasPrefix(full, block.Header) {
+		t.Fatal("unexpected header with CompactBlockHeaders: ", block)
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// SyncWorkers is the number of blocks the ingestor fetches and parses
+// concurrently while it's far behind zcashd's tip; 1 disables this.
+var SyncWorkers = 1
+
+var (
+	// The ingestor catches up until it's this close to the tip, then
+	// follows the tip one block at a time (which also handles reorgs).
+	catchUpMargin = 100
+
+	// The number of blocks fetched (and held in memory) at once.
+	catchUpBatchSize = 1000
+
+	// The number of blocks in each JSON-RPC batch request.
+	catchUpChunkSize = 20
+)
+
+// catchUp fetches blocks concurrently and adds them to the cache in height
+// order until the cache is near zcashd's tip, when it returns done. If the
+// cache's tip isn't on zcashd's chain, it backs up to where it is (see
+// catchUpReorg). Any other problem returns early, so the normal ingestor
+// loop can deal with it (retry, deeper reorg).
+func catchUp(c *BlockCache) (stopped, done bool) {
+	start := time.Now()
+	startHeight := c.GetNextHeight()
+	reorgHeight := -1
+	for {
+		select {
+		case <-stopIngestorChan:
+			return true, false
+		default:
+		}
+		info, err := Chain.GetBlockchainInfo()
+		if err != nil {
+			return false, false
+		}
+		height := c.GetNextHeight()
+		end := info.Blocks - catchUpMargin
+		if end < height {
+			break
+		}
+		if end-height >= catchUpBatchSize {
+			end = height + catchUpBatchSize - 1
+		}
+		blocks, err := getBlocksFromRPC(height, end, SyncWorkers)
+		if err != nil {
+			Log.WithFields(logrus.Fields{
+				"height": height,
+				"error":  err,
+			}).Warn("error fetching blocks during catch-up")
+			return false, false
+		}
+	add:
+		for i, block := range blocks {
+			select {
+			case <-stopIngestorChan:
+				return true, false
+			default:
+			}
+			switch {
+			case block == nil:
+				return false, false
+			case !c.HashMismatch(block.GetPrevHash()):
+				if err := c.AddBlock(height+i, block); err != nil {
+					Log.Fatal("Cache add failed:", err)
+				}
+				continue
+			case i > 0:
+				// zcashd's chain changed while the batch was being
+				// fetched; the next batch finds where it connects.
+			case height == reorgHeight || !catchUpReorg(c, height):
+				// (Backing up again at the same height would be a loop.)
+				return false, false
+			default:
+				reorgHeight = c.GetNextHeight()
+			}
+			break add
+		}
+		Log.WithFields(logrus.Fields{
+			"height": c.GetNextHeight() - 1,
+			"tip":    info.Blocks,
+		}).Info("Ingestor catching up")
+	}
+	if c.GetNextHeight() > startHeight {
+		Log.WithFields(logrus.Fields{
+			"blocks":  c.GetNextHeight() - startHeight,
+			"seconds": int(time.Since(start).Seconds()),
+		}).Info("Ingestor caught up, following the tip")
+	}
+	return false, true
+}
+
+// catchUpReorg backs the cache up from the given (next) height, a block at
+// a time, until zcashd's block connects to it, and adds that block; it's
+// false if that's not within MaxReorgDepth blocks, or there's a problem.
+// This fetches only the blocks it backs up over, not a batch for each one.
+func catchUpReorg(c *BlockCache, height int) bool {
+	for depth := 1; depth <= MaxReorgDepth && height-depth >= c.GetFirstHeight(); depth++ {
+		block, err := getFullBlockFromRPC(height - depth)
+		if err != nil || block == nil {
+			return false
+		}
+		c.Reorg(height - depth)
+		if !c.HashMismatch(block.GetPrevHash()) {
+			Log.WithFields(logrus.Fields{
+				"height": height - depth,
+				"reorg":  depth,
+			}).Warn("REORG during catch-up")
+			if err := c.AddBlock(height-depth, block); err != nil {
+				Log.Fatal("Cache add failed:", err)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// getBlocksFromRPC fetches and parses the blocks in [start, end] using the
+// given number of goroutines, each requesting catchUpChunkSize blocks in
+// one batch.
+func getBlocksFromRPC(start, end, workers int) ([]*parser.Block, error) {
+	blocks := make([]*parser.Block, end-start+1)
+	chunks := make(chan []int)
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		errMutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMutex.Unlock()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for heights := range chunks {
+				blockData, err := Chain.GetBlocks(heights)
+				if err != nil {
+					setErr(err)
+					continue
+				}
+				for i, height := range heights {
+					if blockData[i] == nil {
+						continue
+					}
+					block, err := parseBlock(blockData[i], height)
+					if err != nil {
+						setErr(err)
+						break
+					}
+					blocks[height-start] = block
+				}
+			}
+		}()
+	}
+	for height := start; height <= end; height += catchUpChunkSize {
+		heights := make([]int, 0, catchUpChunkSize)
+		for h := height; h <= end && h < height+catchUpChunkSize; h++ {
+			heights = append(heights, h)
+		}
+		chunks <- heights
+	}
+	close(chunks)
+	wg.Wait()
+	return blocks, firstErr
+}
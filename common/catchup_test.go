@@ -0,0 +1,78 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestCatchUp(t *testing.T) {
+	stub := NewStubBackend("main", 380640)
+	for _, blockJSON := range blocks {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		stub.AddBlock(blockData)
+	}
+	saveChain, saveWorkers, saveMargin, saveBatch := Chain, SyncWorkers, catchUpMargin, catchUpBatchSize
+	defer func() {
+		Chain, SyncWorkers, catchUpMargin, catchUpBatchSize = saveChain, saveWorkers, saveMargin, saveBatch
+	}()
+	Chain = stub
+	SyncWorkers = 3
+	catchUpBatchSize = 3
+
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 380640, true)
+	defer os.RemoveAll(unitTestPath)
+
+	// Within the margin of the tip, there's nothing to do.
+	catchUpMargin = 100
+	if stopped, done := catchUp(c); stopped || !done || c.GetNextHeight() != 380640 {
+		t.Fatal("catchUp near the tip unexpected result", stopped, done, c.GetNextHeight())
+	}
+
+	// Two batches; the blocks are added in order, with their prev-hashes checked.
+	catchUpMargin = 0
+	if stopped, done := catchUp(c); stopped || !done || c.GetNextHeight() != 380644 {
+		t.Fatal("catchUp unexpected result", stopped, done, c.GetNextHeight())
+	}
+	for height := 380640; height < 380644; height++ {
+		if block := c.Get(height); block == nil || block.Height != uint64(height) {
+			t.Fatal("missing block", height)
+		}
+	}
+
+	// The cache's tip isn't on zcashd's chain; back up to where it is,
+	// fetching only the blocks backed up over.
+	c.Reorg(380642)
+	var blockHex string
+	json.Unmarshal(blocks[2], &blockHex)
+	blockData, _ := hex.DecodeString(blockHex)
+	blockData[9]++ // first byte of the prevhash
+	orphan, _ := parseBlock(blockData, 380642)
+	c.AddBlock(380642, orphan)
+	if stopped, done := catchUp(c); stopped || !done || c.GetNextHeight() != 380644 {
+		t.Fatal("catchUp with a reorg unexpected result", stopped, done, c.GetNextHeight())
+	}
+	if !bytes.Equal(c.Get(380642).Hash, c.Get(380643).PrevHash) {
+		t.Fatal("orphaned block not replaced")
+	}
+
+	// zcashd's chain doesn't connect to itself; leave that to the
+	// normal ingestor loop.
+	c.Reorg(380642)
+	stub.AddBlock(blockData)
+	json.Unmarshal(blocks[3], &blockHex)
+	blockData, _ = hex.DecodeString(blockHex)
+	stub.AddBlock(blockData)
+	if stopped, done := catchUp(c); stopped || done || c.GetNextHeight() != 380642 {
+		t.Fatal("catchUp with a mismatch unexpected result", stopped, done, c.GetNextHeight())
+	}
+	c.Close()
+}
@@ -0,0 +1,428 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/status"
+)
+
+// ChainBackend is lightwalletd's interface to a full node. Hashes and
+// txids in arguments and results are in big-endian (display) hex, as in
+// the full nodes' RPC interfaces, unless they're byte slices.
+type ChainBackend interface {
+	// GetBlockchainInfo returns the node's chain name, tip height and upgrades.
+	GetBlockchainInfo() (*ZcashdRpcReplyGetblockchaininfo, error)
+
+	// GetInfo returns the node's version information.
+	GetInfo() (*ZcashdRpcReplyGetinfo, error)
+
+	// GetBlock returns the serialized block at the given height, or nil
+	// (and no error) if the node doesn't have a block at that height yet.
+	GetBlock(height int) ([]byte, error)
+
+	// GetBlocks is GetBlock for several heights, in one round trip if
+	// possible.
+	GetBlocks(heights []int) ([][]byte, error)
+
+	// GetRawTransaction returns the serialized transaction with the given
+	// (little-endian) txid, and the height of the block that contains it
+	// (zero or less if it's in the mempool).
+	GetRawTransaction(txid []byte) ([]byte, int, error)
+
+	// GetRawTransactions is GetRawTransaction for several transactions, in
+	// one round trip if possible; there's an error for each one (nil if it
+	// was found).
+	GetRawTransactions(txids [][]byte) ([]*walletrpc.RawTransaction, []error)
+
+	// GetTreeState returns the note commitment tree state as of the given
+	// block, specified by height (decimal) or hash.
+	GetTreeState(heightOrHash string) (*ZcashdRpcReplyGettreestate, error)
+
+	// GetSubtreesByIndex returns the roots of the given pool's ("sapling"
+	// or "orchard") complete note commitment subtrees, from the given
+	// index, at most limit of them (or all if it's zero).
+	GetSubtreesByIndex(pool string, start, limit uint32) (*ZcashdRpcReplyGetsubtreesbyindex, error)
+
+	// GetRawMempool returns the txids of the transactions in the mempool.
+	GetRawMempool() ([]string, error)
+
+	// SendRawTransaction submits the serialized transaction to the network,
+	// returning the node's reply (the txid on success). A rejected
+	// transaction's error is in the form "code: message".
+	SendRawTransaction(tx []byte) (string, error)
+
+	// GetAddressTxids returns the txids that involve the given transparent
+	// addresses within the given (inclusive) block range.
+	GetAddressTxids(addresses []string, start, end uint64) ([]string, error)
+
+	// GetAddressBalance returns the total balance of the transparent addresses.
+	GetAddressBalance(addresses []string) (int64, error)
+
+	// GetAddressUtxos returns the unspent outputs of the transparent addresses.
+	GetAddressUtxos(addresses []string) ([]ZcashdRpcReplyGetaddressutxos, error)
+}
+
+// Chain is the full node that lightwalletd gets its data from.
+var Chain ChainBackend = ZcashdBackend{}
+
+// ZcashdBackend implements ChainBackend using zcashd's JSON-RPC interface,
+// by way of RawRequest.
+type ZcashdBackend struct{}
+
+// zcashdUnreachableError is the error from an RPC that couldn't be delivered
+// to zcashd (such as a refused connection or a timeout), as opposed to one
+// that zcashd replied to with an error. Clients get ErrZcashdUnavailable's
+// status (not the cause, which may reveal zcashd's address).
+type zcashdUnreachableError struct {
+	cause error
+}
+
+func (e *zcashdUnreachableError) Error() string {
+	return "zcashd is unreachable: " + e.cause.Error()
+}
+
+// GRPCStatus is used by status.FromError.
+func (e *zcashdUnreachableError) GRPCStatus() *status.Status {
+	return status.Convert(ErrZcashdUnavailable)
+}
+
+// unreachable maps errors that mean the request didn't reach zcashd to
+// zcashdUnreachableError, leaving the rest (and nil) alone.
+func unreachable(err error) error {
+	if _, ok := errors.Cause(err).(net.Error); ok {
+		return &zcashdUnreachableError{cause: err}
+	}
+	return err
+}
+
+// isUnreachable is true if the error is (or wraps) a zcashdUnreachableError.
+func isUnreachable(err error) bool {
+	_, ok := errors.Cause(err).(*zcashdUnreachableError)
+	return ok
+}
+
+// rawRequest is RawRequest, with the errors for requests that didn't reach
+// zcashd mapped by unreachable.
+func rawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	result, err := RawRequest(method, params)
+	return result, unreachable(err)
+}
+
+// rpcErrorCode returns the JSON-RPC error code (the "-8" in "-8: Block height
+// out of range"), or 0 if the error didn't come from the node.
+func rpcErrorCode(err error) int {
+	code, convErr := strconv.Atoi(strings.TrimSpace(strings.SplitN(err.Error(), ":", 2)[0]))
+	if convErr != nil {
+		return 0
+	}
+	return code
+}
+
+func marshalParams(args ...interface{}) ([]json.RawMessage, error) {
+	params := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		if raw, ok := arg.(json.RawMessage); ok {
+			params[i] = raw
+			continue
+		}
+		param, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = param
+	}
+	return params, nil
+}
+
+// request issues the RPC and unmarshals its (JSON) result into reply.
+func request(reply interface{}, method string, args ...interface{}) error {
+	params, err := marshalParams(args...)
+	if err != nil {
+		return err
+	}
+	if RawRequest == nil {
+		// Not connected to zcashd (such as by cache import-blocks)
+		return errors.New("no zcashd connection")
+	}
+	result, rpcErr := rawRequest(method, params)
+	// For some reason, the error responses are not JSON
+	if rpcErr != nil {
+		return rpcErr
+	}
+	return json.Unmarshal(result, reply)
+}
+
+// GetBlockchainInfo implements ChainBackend.
+func (ZcashdBackend) GetBlockchainInfo() (*ZcashdRpcReplyGetblockchaininfo, error) {
+	var reply ZcashdRpcReplyGetblockchaininfo
+	if err := request(&reply, "getblockchaininfo"); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// GetInfo implements ChainBackend.
+func (ZcashdBackend) GetInfo() (*ZcashdRpcReplyGetinfo, error) {
+	var reply ZcashdRpcReplyGetinfo
+	if err := request(&reply, "getinfo"); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// GetBlock implements ChainBackend.
+func (ZcashdBackend) GetBlock(height int) ([]byte, error) {
+	return getRawBlock(height, zcashdBlockNotFound)
+}
+
+// GetBlocks implements ChainBackend.
+func (ZcashdBackend) GetBlocks(heights []int) ([][]byte, error) {
+	return getRawBlocks(heights, zcashdBlockNotFound)
+}
+
+// zcashdBlockNotFound is true if the getblock error means zcashd doesn't
+// have a block at that height yet.
+func zcashdBlockNotFound(err error) bool {
+	return rpcErrorCode(err) == -8
+}
+
+// TxNotFound is true if the getrawtransaction error means the node doesn't
+// have the transaction (a mempool transaction can disappear, for example).
+func TxNotFound(err error) bool {
+	return rpcErrorCode(err) == -5
+}
+
+func getBlockRequest(height int) RPCRequest {
+	heightJSON, _ := json.Marshal(strconv.Itoa(height))
+	return RPCRequest{
+		Method: "getblock",
+		Params: []json.RawMessage{heightJSON, json.RawMessage("0")}, // non-verbose (raw hex)
+	}
+}
+
+// getRawBlock requests the non-verbose (raw hex) form of the block; the
+// notFound function recognizes the error for a block the node doesn't
+// have yet.
+func getRawBlock(height int, notFound func(error) bool) ([]byte, error) {
+	request := getBlockRequest(height)
+	result, rpcErr := rawRequest(request.Method, request.Params)
+	return decodeRawBlock(result, rpcErr, notFound)
+}
+
+func getRawBlocks(heights []int, notFound func(error) bool) ([][]byte, error) {
+	requests := make([]RPCRequest, len(heights))
+	for i, height := range heights {
+		requests[i] = getBlockRequest(height)
+	}
+	replies, err := BatchRequest(requests)
+	if isUnreachable(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error requesting blocks")
+	}
+	blocks := make([][]byte, len(heights))
+	for i, reply := range replies {
+		blocks[i], err = decodeRawBlock(reply.Result, reply.Err, notFound)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+func decodeRawBlock(result json.RawMessage, rpcErr error, notFound func(error) bool) ([]byte, error) {
+	// For some reason, the error responses are not JSON
+	if rpcErr != nil {
+		// Check to see if we are requesting a height the node doesn't have yet
+		if notFound(rpcErr) {
+			return nil, nil
+		}
+		if isUnreachable(rpcErr) {
+			return nil, rpcErr
+		}
+		return nil, errors.Wrap(rpcErr, "error requesting block")
+	}
+	var blockDataHex string
+	err := json.Unmarshal(result, &blockDataHex)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading JSON response")
+	}
+	blockData, err := hex.DecodeString(blockDataHex)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding getblock output")
+	}
+	return blockData, nil
+}
+
+// GetRawTransaction implements ChainBackend.
+func (ZcashdBackend) GetRawTransaction(txid []byte) ([]byte, int, error) {
+	request := getRawTransactionRequest(txid)
+	result, rpcErr := rawRequest(request.Method, request.Params)
+	return decodeRawTransaction(result, rpcErr)
+}
+
+// GetRawTransactions implements ChainBackend.
+func (ZcashdBackend) GetRawTransactions(txids [][]byte) ([]*walletrpc.RawTransaction, []error) {
+	return getRawTransactions(txids, decodeRawTransaction)
+}
+
+func getRawTransactions(txids [][]byte,
+	decode func(json.RawMessage, error) ([]byte, int, error)) ([]*walletrpc.RawTransaction, []error) {
+	requests := make([]RPCRequest, len(txids))
+	for i, txid := range txids {
+		requests[i] = getRawTransactionRequest(txid)
+	}
+	txs := make([]*walletrpc.RawTransaction, len(txids))
+	errs := make([]error, len(txids))
+	replies, err := BatchRequest(requests)
+	for i := range txids {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		var txBytes []byte
+		var height int
+		txBytes, height, errs[i] = decode(replies[i].Result, replies[i].Err)
+		if errs[i] == nil {
+			txs[i] = &walletrpc.RawTransaction{Data: txBytes, Height: uint64(height)}
+		}
+	}
+	return txs, errs
+}
+
+func getRawTransactionRequest(txid []byte) RPCRequest {
+	txidJSON, _ := json.Marshal(hex.EncodeToString(parser.Reverse(txid)))
+	return RPCRequest{
+		Method: "getrawtransaction",
+		Params: []json.RawMessage{txidJSON, json.RawMessage("1")},
+	}
+}
+
+func decodeRawTransaction(result json.RawMessage, rpcErr error) ([]byte, int, error) {
+	// For some reason, the error responses are not JSON
+	if rpcErr != nil {
+		return nil, 0, rpcErr
+	}
+	// Many other fields are returned, but we need only these two.
+	var txinfo ZcashdRpcReplyGetrawtransaction
+	err := json.Unmarshal(result, &txinfo)
+	if err != nil {
+		return nil, 0, err
+	}
+	txBytes, err := hex.DecodeString(txinfo.Hex)
+	if err != nil {
+		return nil, 0, err
+	}
+	return txBytes, txinfo.Height, nil
+}
+
+// GetTreeState implements ChainBackend. If the Sapling tree didn't change
+// in the requested block, zcashd returns the hash of the most recent block
+// in which it did, so follow that.
+func (ZcashdBackend) GetTreeState(heightOrHash string) (*ZcashdRpcReplyGettreestate, error) {
+	var reply ZcashdRpcReplyGettreestate
+	for {
+		if err := request(&reply, "z_gettreestate", heightOrHash); err != nil {
+			return nil, err
+		}
+		if reply.Sapling.Commitments.FinalState != "" {
+			break
+		}
+		if reply.Sapling.SkipHash == "" {
+			break
+		}
+		heightOrHash = reply.Sapling.SkipHash
+	}
+	return &reply, nil
+}
+
+// GetSubtreesByIndex implements ChainBackend.
+func (ZcashdBackend) GetSubtreesByIndex(pool string, start, limit uint32) (*ZcashdRpcReplyGetsubtreesbyindex, error) {
+	args := []interface{}{pool, start}
+	if limit > 0 {
+		args = append(args, limit)
+	}
+	var reply ZcashdRpcReplyGetsubtreesbyindex
+	if err := request(&reply, "z_getsubtreesbyindex", args...); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// GetRawMempool implements ChainBackend.
+func (ZcashdBackend) GetRawMempool() ([]string, error) {
+	var txids []string
+	if err := request(&txids, "getrawmempool"); err != nil {
+		return nil, err
+	}
+	return txids, nil
+}
+
+// SendRawTransaction implements ChainBackend.
+func (ZcashdBackend) SendRawTransaction(tx []byte) (string, error) {
+	// sendrawtransaction "hexstring" ( allowhighfees )
+	//
+	// Submits raw transaction (binary) to local node and network.
+	//
+	// Result:
+	// "hex"             (string) The transaction hash in hex
+	params, err := marshalParams(hex.EncodeToString(tx))
+	if err != nil {
+		return "", err
+	}
+	result, rpcErr := rawRequest("sendrawtransaction", params)
+	if rpcErr != nil {
+		return "", rpcErr
+	}
+	return string(result), nil
+}
+
+// GetAddressTxids implements ChainBackend.
+func (ZcashdBackend) GetAddressTxids(addresses []string, start, end uint64) ([]string, error) {
+	var txids []string
+	err := request(&txids, "getaddresstxids", &ZcashdRpcRequestGetaddresstxids{
+		Addresses: addresses,
+		Start:     start,
+		End:       end,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txids, nil
+}
+
+// GetAddressBalance implements ChainBackend.
+func (ZcashdBackend) GetAddressBalance(addresses []string) (int64, error) {
+	var reply ZcashdRpcReplyGetaddressbalance
+	err := request(&reply, "getaddressbalance", &ZcashdRpcRequestGetaddressbalance{
+		Addresses: addresses,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reply.Balance, nil
+}
+
+// GetAddressUtxos implements ChainBackend.
+func (ZcashdBackend) GetAddressUtxos(addresses []string) ([]ZcashdRpcReplyGetaddressutxos, error) {
+	var reply []ZcashdRpcReplyGetaddressutxos
+	err := request(&reply, "getaddressutxos", &ZcashdRpcRequestGetaddressutxos{
+		Addresses: addresses,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// zebradStub answers the way zebrad does where it differs from zcashd.
+func zebradStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	switch method {
+	case "getinfo":
+		return nil, errors.New("-32601: Method not found")
+	case "getblockchaininfo":
+		return json.RawMessage(`{"chain":"main","blocks":380642}`), nil
+	case "getblock":
+		var height string
+		json.Unmarshal(params[0], &height)
+		if height == "380641" {
+			return blocks[1], nil
+		}
+		return nil, errors.New("-5: Block not found")
+	case "getrawtransaction":
+		return json.RawMessage(`{"hex":"00","height":-1}`), nil
+	case "z_gettreestate":
+		return json.RawMessage(`{"height":380641,"sapling":{"commitments":{}}}`), nil
+	}
+	testT.Fatal("unexpected method", method)
+	return nil, nil
+}
+
+func TestZebradBackend(t *testing.T) {
+	testT = t
+	RawRequest = zebradStub
+	z := ZebradBackend{}
+
+	info, err := z.GetInfo()
+	if err != nil || info.Subversion != "/Zebra/" {
+		t.Fatal("GetInfo unexpected result", info, err)
+	}
+	chainInfo, err := z.GetBlockchainInfo()
+	if err != nil || chainInfo.EstimatedHeight != 380642 {
+		t.Fatal("GetBlockchainInfo unexpected result", chainInfo, err)
+	}
+	block, err := z.GetBlock(380641)
+	if err != nil || block == nil {
+		t.Fatal("GetBlock failed", err)
+	}
+	// zebrad doesn't have this block yet
+	block, err = z.GetBlock(380643)
+	if err != nil || block != nil {
+		t.Fatal("GetBlock beyond the tip unexpected result", block, err)
+	}
+	// mempool transaction
+	_, height, err := z.GetRawTransaction(make([]byte, 32))
+	if err != nil || height != 0 {
+		t.Fatal("GetRawTransaction unexpected result", height, err)
+	}
+	// no skipHash to follow; an empty tree is returned as is
+	treeState, err := z.GetTreeState("380641")
+	if err != nil || treeState.Height != 380641 {
+		t.Fatal("GetTreeState unexpected result", treeState, err)
+	}
+}
+
+func TestStubBackend(t *testing.T) {
+	stub := NewStubBackend("main", 419200)
+	for _, blockJSON := range blocks[:3] {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		if err := stub.AddBlock(blockData); err != nil {
+			t.Fatal("AddBlock failed", err)
+		}
+	}
+	chainInfo, _ := stub.GetBlockchainInfo()
+	if chainInfo.Blocks != 380642 || chainInfo.Chain != "main" {
+		t.Fatal("GetBlockchainInfo unexpected result", chainInfo)
+	}
+
+	// The ingestor's view of the stub chain
+	saveChain := Chain
+	defer func() { Chain = saveChain }()
+	Chain = stub
+	compact, err := getBlockFromRPC(380641)
+	if err != nil || compact.Height != 380641 {
+		t.Fatal("getBlockFromRPC unexpected result", compact, err)
+	}
+	compact, err = getBlockFromRPC(380643)
+	if err != nil || compact != nil {
+		t.Fatal("getBlockFromRPC beyond the tip unexpected result", compact, err)
+	}
+
+	// Transactions in the blocks can be looked up by txid.
+	txid := parser.Reverse(firstTxid(t, stub))
+	txBytes, height, err := stub.GetRawTransaction(txid)
+	if err != nil || len(txBytes) == 0 || height != 380640 {
+		t.Fatal("GetRawTransaction unexpected result", height, err)
+	}
+	if _, err := stub.SendRawTransaction(txBytes); err == nil {
+		t.Fatal("SendRawTransaction of a mined transaction should fail")
+	}
+	if _, err := stub.SendRawTransaction([]byte{1, 2, 3}); err == nil {
+		t.Fatal("SendRawTransaction of garbage should fail")
+	}
+
+	// Mining the block again (as after a reorg) removes later blocks.
+	var blockHex string
+	json.Unmarshal(blocks[1], &blockHex)
+	blockData, _ := hex.DecodeString(blockHex)
+	stub.AddBlock(blockData)
+	if block, _ := stub.GetBlock(380642); block != nil {
+		t.Fatal("block above the new tip should be gone")
+	}
+	if block, _ := stub.GetBlock(380641); !bytes.Equal(block, blockData) {
+		t.Fatal("GetBlock unexpected result")
+	}
+
+	stub.AddUtxo(ZcashdRpcReplyGetaddressutxos{Address: "t1a", Satoshis: 5, Height: 380640})
+	stub.AddUtxo(ZcashdRpcReplyGetaddressutxos{Address: "t1a", Satoshis: 7, Height: 380641})
+	balance, _ := stub.GetAddressBalance([]string{"t1a", "t1b"})
+	if balance != 12 {
+		t.Fatal("GetAddressBalance unexpected result", balance)
+	}
+	utxos, _ := stub.GetAddressUtxos([]string{"t1a"})
+	if len(utxos) != 2 {
+		t.Fatal("GetAddressUtxos unexpected result", utxos)
+	}
+	stub.AddAddressTxid("t1a", hex.EncodeToString(parser.Reverse(txid)))
+	txids, _ := stub.GetAddressTxids([]string{"t1a"}, 380641, 380643)
+	if len(txids) != 0 {
+		t.Fatal("GetAddressTxids should exclude txids outside the range", txids)
+	}
+	txids, _ = stub.GetAddressTxids([]string{"t1a"}, 380640, 380643)
+	if len(txids) != 1 {
+		t.Fatal("GetAddressTxids unexpected result", txids)
+	}
+}
+
+// firstTxid returns the big-endian txid of the first block's coinbase.
+func firstTxid(t *testing.T, stub *StubBackend) []byte {
+	blockData, err := stub.GetBlock(380640)
+	if err != nil {
+		t.Fatal("GetBlock failed", err)
+	}
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(blockData); err != nil {
+		t.Fatal("ParseFromSlice failed", err)
+	}
+	return block.Transactions()[0].GetDisplayHash()
+}
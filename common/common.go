@@ -7,14 +7,19 @@ package common
 import (
 	"encoding/hex"
 	"encoding/json"
-	"strconv"
-	"strings"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // 'make build' will overwrite this string with the output of git-describe (tag)
@@ -27,25 +32,41 @@ var (
 )
 
 type Options struct {
-	GRPCBindAddr        string `json:"grpc_bind_address,omitempty"`
-	GRPCLogging         bool   `json:"grpc_logging_insecure,omitempty"`
-	HTTPBindAddr        string `json:"http_bind_address,omitempty"`
-	TLSCertPath         string `json:"tls_cert_path,omitempty"`
-	TLSKeyPath          string `json:"tls_cert_key,omitempty"`
-	LogLevel            uint64 `json:"log_level,omitempty"`
-	LogFile             string `json:"log_file,omitempty"`
-	ZcashConfPath       string `json:"zcash_conf,omitempty"`
-	RPCUser             string `json:"rpcuser"`
-	RPCPassword         string `json:"rpcpassword"`
-	RPCHost             string `json:"rpchost"`
-	RPCPort             string `json:"rpcport"`
-	NoTLSVeryInsecure   bool   `json:"no_tls_very_insecure,omitempty"`
-	GenCertVeryInsecure bool   `json:"gen_cert_very_insecure,omitempty"`
-	Redownload          bool   `json:"redownload"`
-	DataDir             string `json:"data_dir"`
-	PingEnable          bool   `json:"ping_enable"`
-	Darkside            bool   `json:"darkside"`
-	DarksideTimeout     uint64 `json:"darkside_timeout"`
+	GRPCBindAddr        string   `json:"grpc_bind_address,omitempty"`
+	GRPCLogging         bool     `json:"grpc_logging_insecure,omitempty"`
+	HTTPBindAddr        string   `json:"http_bind_address,omitempty"`
+	TLSCertPath         string   `json:"tls_cert_path,omitempty"`
+	TLSKeyPath          string   `json:"tls_cert_key,omitempty"`
+	LogLevel            uint64   `json:"log_level,omitempty"`
+	LogFile             string   `json:"log_file,omitempty"`
+	ZcashConfPath       string   `json:"zcash_conf,omitempty"`
+	RPCUser             string   `json:"rpcuser"`
+	RPCPassword         string   `json:"rpcpassword"`
+	RPCHost             string   `json:"rpchost"`
+	RPCPort             string   `json:"rpcport"`
+	NoTLSVeryInsecure   bool     `json:"no_tls_very_insecure,omitempty"`
+	GenCertVeryInsecure bool     `json:"gen_cert_very_insecure,omitempty"`
+	Redownload          bool     `json:"redownload"`
+	DataDir             string   `json:"data_dir"`
+	PingEnable          bool     `json:"ping_enable"`
+	Darkside            bool     `json:"darkside"`
+	DarksideTimeout     uint64   `json:"darkside_timeout"`
+	MaxReorgDepth       int      `json:"max_reorg_depth"`
+	HTTPAdmin           bool     `json:"http_admin"`
+	ZcashdBackends      []string `json:"zcashd_backends"`
+	NodeType            string   `json:"node_type"`
+	ZcashdZmq           []string `json:"zcashd_zmq"`
+	SyncWorkers         int      `json:"sync_workers"`
+	ShutdownTimeout     int      `json:"shutdown_timeout"`
+	WatchConfig         bool     `json:"watch_config"`
+	CacheCompression    string   `json:"cache_compression"`
+	CacheStorage        string   `json:"cache_storage"`
+	CacheSize           int      `json:"cache_size"`
+	CacheLRUSize        int      `json:"cache_lru_size"`
+	TxIndex             bool     `json:"tx_index"`
+	NullifierIndex      bool     `json:"nullifier_index"`
+	SaplingTree         bool     `json:"sapling_tree"`
+	CompactBlockHeaders bool     `json:"compact_block_headers"`
 }
 
 // RawRequest points to the function to send a an RPC request to zcashd;
@@ -61,6 +82,34 @@ var Sleep func(d time.Duration)
 // Log as a global variable simplifies logging
 var Log *logrus.Entry
 
+// ErrZcashdUnavailable is returned by requests that need zcashd while
+// it can't be reached; requests that the cache can satisfy still work.
+var ErrZcashdUnavailable = status.Error(codes.Unavailable,
+	"zcashd is unreachable, only cached blocks are available")
+
+// ErrNoNullifierIndex is returned by nullifier queries when lightwalletd
+// doesn't keep the nullifier index.
+var ErrNoNullifierIndex = status.Error(codes.FailedPrecondition,
+	"lightwalletd isn't keeping the nullifier index (see --nullifier-index)")
+
+// ErrNullifierUnknown is returned by nullifier queries for a nullifier that
+// isn't spent in the cached blocks when they don't go back to Sapling
+// activation (see --cache-size): it may be spent in an older block.
+var ErrNullifierUnknown = status.Error(codes.OutOfRange,
+	"the nullifier isn't spent in the cached blocks, but they don't go back to Sapling activation")
+
+// Longest time between attempts to reach zcashd when it's down.
+const maxRetryDelay = 2 * time.Minute
+
+// CompactBlockHeaders is whether compact blocks made from zcashd's blocks
+// (and so cached) include the full block header, see
+// parser.Block.ToCompactWithHeader.
+var CompactBlockHeaders = false
+
+// MaxReorgDepth is the number of blocks the ingestor will back up looking
+// for a common ancestor with zcashd before it gives up and halts.
+var MaxReorgDepth = 100
+
 // The following are JSON zcashd rpc requests and replies.
 type (
 	// zcashd rpc "getblockchaininfo"
@@ -133,57 +182,98 @@ type (
 		Satoshis    uint64
 		Height      int
 	}
+
+	// zcashd rpc "z_getsubtreesbyindex"
+	ZcashdRpcReplyGetsubtreesbyindex struct {
+		Pool       string
+		StartIndex uint32 `json:"start_index"`
+		Subtrees   []ZcashdRpcReplySubtree
+	}
+	ZcashdRpcReplySubtree struct {
+		Root      string // hex, little-endian
+		EndHeight int    `json:"end_height"`
+	}
 )
 
 // FirstRPC tests that we can successfully reach zcashd through the RPC
-// interface. The specific RPC used here is not important.
-func FirstRPC() {
+// interface. The specific RPC used here is not important. It retries (with
+// backoff) up to the given number of times, then returns the error; if
+// there's a cache, the caller can still serve it (see StartUnavailable).
+func FirstRPC(retries int) error {
 	retryCount := 0
 	for {
-		result, rpcErr := RawRequest("getblockchaininfo", []json.RawMessage{})
+		_, rpcErr := Chain.GetBlockchainInfo()
 		if rpcErr == nil {
 			if retryCount > 0 {
 				Log.Warn("getblockchaininfo RPC successful")
 			}
-			var getblockchaininfo ZcashdRpcReplyGetblockchaininfo
-			err := json.Unmarshal(result, &getblockchaininfo)
-			if err != nil {
-				Log.Fatalf("error parsing JSON getblockchaininfo response: %v", err)
-			}
-			break
+			return nil
 		}
-		retryCount++
-		if retryCount > 10 {
-			Log.WithFields(logrus.Fields{
-				"timeouts": retryCount,
-			}).Fatal("unable to issue getblockchaininfo RPC call to zcashd node")
+		if retryCount >= retries {
+			return rpcErr
 		}
+		retryCount++
 		Log.WithFields(logrus.Fields{
 			"error": rpcErr.Error(),
 			"retry": retryCount,
 		}).Warn("error with getblockchaininfo rpc, retrying...")
-		Sleep(time.Duration(10+retryCount*5) * time.Second) // backoff
+		delay := time.Duration(10+retryCount*5) * time.Second // backoff
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		Sleep(delay)
 	}
 }
 
-func GetLightdInfo() (*walletrpc.LightdInfo, error) {
-	result, rpcErr := RawRequest("getinfo", []json.RawMessage{})
-	if rpcErr != nil {
-		return nil, rpcErr
+// StartUnavailable starts the server in the degraded mode (see
+// ZcashdUnavailable), for when zcashd can't be reached at startup but
+// there's a cache to serve; the ingestor leaves the mode once it reaches
+// zcashd. Until then, GetLightdInfo returns what the cache's metadata says.
+func StartUnavailable(metadata *CacheMetadata) {
+	setZcashdUnavailable(true)
+	vendor := "ECC LightWalletD"
+	if DarksideEnabled {
+		vendor = "ECC DarksideWalletD"
 	}
-	var getinfoReply ZcashdRpcReplyGetinfo
-	err := json.Unmarshal(result, &getinfoReply)
-	if err != nil {
-		return nil, rpcErr
+	lastLightdInfoMutex.Lock()
+	defer lastLightdInfoMutex.Unlock()
+	lastLightdInfo = &walletrpc.LightdInfo{
+		Version:                 Version,
+		Vendor:                  vendor,
+		TaddrSupport:            true,
+		ChainName:               metadata.ChainName,
+		SaplingActivationHeight: uint64(metadata.SaplingHeight),
+		GitCommit:               GitCommit,
+		Branch:                  Branch,
+		BuildDate:               BuildDate,
+		BuildUser:               BuildUser,
 	}
+}
+
+// The most recent successful GetLightdInfo() reply, returned when zcashd
+// is unavailable.
+var (
+	lastLightdInfo      *walletrpc.LightdInfo
+	lastLightdInfoMutex sync.Mutex
+)
 
-	result, rpcErr = RawRequest("getblockchaininfo", []json.RawMessage{})
+// GetLightdInfo returns information about this lightwalletd and its zcashd;
+// if zcashd is unreachable, it returns the last known information.
+func GetLightdInfo() (*walletrpc.LightdInfo, error) {
+	if ZcashdUnavailable() {
+		lastLightdInfoMutex.Lock()
+		defer lastLightdInfoMutex.Unlock()
+		if lastLightdInfo == nil {
+			return nil, ErrZcashdUnavailable
+		}
+		return proto.Clone(lastLightdInfo).(*walletrpc.LightdInfo), nil
+	}
+	getinfoReply, rpcErr := Chain.GetInfo()
 	if rpcErr != nil {
 		return nil, rpcErr
 	}
-	var getblockchaininfoReply ZcashdRpcReplyGetblockchaininfo
-	err = json.Unmarshal(result, &getblockchaininfoReply)
-	if err != nil {
+	getblockchaininfoReply, rpcErr := Chain.GetBlockchainInfo()
+	if rpcErr != nil {
 		return nil, rpcErr
 	}
 	// If the sapling consensus branch doesn't exist, it must be regtest
@@ -196,7 +286,7 @@ func GetLightdInfo() (*walletrpc.LightdInfo, error) {
 	if DarksideEnabled {
 		vendor = "ECC DarksideWalletD"
 	}
-	return &walletrpc.LightdInfo{
+	info := &walletrpc.LightdInfo{
 		Version:                 Version,
 		Vendor:                  vendor,
 		TaddrSupport:            true,
@@ -211,39 +301,43 @@ func GetLightdInfo() (*walletrpc.LightdInfo, error) {
 		EstimatedHeight:         uint64(getblockchaininfoReply.EstimatedHeight),
 		ZcashdBuild:             getinfoReply.Build,
 		ZcashdSubversion:        getinfoReply.Subversion,
-	}, nil
+	}
+	lastLightdInfoMutex.Lock()
+	lastLightdInfo = proto.Clone(info).(*walletrpc.LightdInfo)
+	lastLightdInfoMutex.Unlock()
+	return info, nil
 }
 
 func getBlockFromRPC(height int) (*walletrpc.CompactBlock, error) {
-	params := make([]json.RawMessage, 2)
-	heightJSON, err := json.Marshal(strconv.Itoa(height))
-	if err != nil {
-		return nil, errors.Wrap(err, "error marshaling height")
-	}
-	params[0] = heightJSON
-	params[1] = json.RawMessage("0") // non-verbose (raw hex)
-	result, rpcErr := RawRequest("getblock", params)
-
-	// For some reason, the error responses are not JSON
-	if rpcErr != nil {
-		// Check to see if we are requesting a height the zcashd doesn't have yet
-		if (strings.Split(rpcErr.Error(), ":"))[0] == "-8" {
-			return nil, nil
-		}
-		return nil, errors.Wrap(rpcErr, "error requesting block")
+	block, err := getFullBlockFromRPC(height)
+	if block == nil || err != nil {
+		return nil, err
 	}
+	return toCompact(block)
+}
 
-	var blockDataHex string
-	err = json.Unmarshal(result, &blockDataHex)
-	if err != nil {
-		return nil, errors.Wrap(err, "error reading JSON response")
+// toCompact returns the compact form of the block, with its full header if
+// CompactBlockHeaders is set.
+func toCompact(block *parser.Block) (*walletrpc.CompactBlock, error) {
+	if CompactBlockHeaders {
+		return block.ToCompactWithHeader()
 	}
+	return block.ToCompact(), nil
+}
 
-	blockData, err := hex.DecodeString(blockDataHex)
-	if err != nil {
-		return nil, errors.Wrap(err, "error decoding getblock output")
+// getFullBlockFromRPC is getBlockFromRPC, but returns the parsed full block
+// (with its transactions, for the tx index).
+func getFullBlockFromRPC(height int) (*parser.Block, error) {
+	blockData, err := Chain.GetBlock(height)
+	if blockData == nil || err != nil {
+		return nil, err
 	}
+	return parseBlock(blockData, height)
+}
 
+// parseBlock parses the serialized block, which should be at the given
+// height.
+func parseBlock(blockData []byte, height int) (*parser.Block, error) {
 	block := parser.NewBlock()
 	rest, err := block.ParseFromSlice(blockData)
 	if err != nil {
@@ -256,36 +350,185 @@ func getBlockFromRPC(height int) (*walletrpc.CompactBlock, error) {
 	if block.GetHeight() != height {
 		return nil, errors.New("received unexpected height block")
 	}
-
-	return block.ToCompact(), nil
+	return block, nil
 }
 
 var (
-	ingestorRunning  bool
+	ingestorRunning  bool // started by (darkside) startIngestor, protected by ingestorMutex
+	ingestorMutex    sync.Mutex
 	stopIngestorChan = make(chan struct{})
+	ingestorsActive  int32          // BlockIngestor goroutines (darkside can stop and start it)
+	ingestorsWG      sync.WaitGroup // also the BlockIngestor goroutines, to wait for them to exit
+
+	// The ingestor waits on this channel after it halts (because of a
+	// too-deep reorg) until an administrator rewinds or resyncs the cache.
+	resumeIngestorChan = make(chan struct{}, 1)
+
+	ingestorHaltedMutex  sync.Mutex
+	ingestorHaltedReason string // empty if the ingestor is not halted
+
+	// Set by the ingestor when it can't reach zcashd (or repeatedly gets
+	// errors from it).
+	zcashdUnavailable int32
+)
+
+var (
+	ingestorHaltedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_ingestor_halted",
+		Help: "1 if block ingestion has stopped and requires an administrator, else 0.",
+	})
+	reorgDepthGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_reorg_depth",
+		Help: "Number of blocks the ingestor has backed up in the current reorg.",
+	})
+	zcashdUnavailableGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_zcashd_unavailable",
+		Help: "1 if zcashd can't be reached and only cached blocks are served, else 0.",
+	})
 )
 
+func init() {
+	prometheus.MustRegister(ingestorHaltedGauge)
+	prometheus.MustRegister(reorgDepthGauge)
+	prometheus.MustRegister(zcashdUnavailableGauge)
+}
+
+// ZcashdUnavailable is true while the ingestor can't reach zcashd (the
+// server is then in a degraded, read-only mode). Requests that need zcashd
+// get ErrZcashdUnavailable, as do those that fail to reach it before the
+// ingestor notices (see zcashdUnreachableError).
+func ZcashdUnavailable() bool {
+	return atomic.LoadInt32(&zcashdUnavailable) != 0
+}
+
+func setZcashdUnavailable(unavailable bool) {
+	if unavailable {
+		atomic.StoreInt32(&zcashdUnavailable, 1)
+		zcashdUnavailableGauge.Set(1)
+	} else {
+		atomic.StoreInt32(&zcashdUnavailable, 0)
+		zcashdUnavailableGauge.Set(0)
+	}
+}
+
+// IngestorHalted returns the reason block ingestion has stopped, or the
+// empty string if it's running normally.
+func IngestorHalted() string {
+	ingestorHaltedMutex.Lock()
+	defer ingestorHaltedMutex.Unlock()
+	return ingestorHaltedReason
+}
+
+func setIngestorHalted(reason string) {
+	ingestorHaltedMutex.Lock()
+	defer ingestorHaltedMutex.Unlock()
+	ingestorHaltedReason = reason
+	if reason == "" {
+		ingestorHaltedGauge.Set(0)
+	} else {
+		ingestorHaltedGauge.Set(1)
+	}
+}
+
+// RewindIngestor removes all blocks above the given height from the cache
+// (use a height below the first block to resync from the beginning). If the
+// ingestor has halted, it resumes from the new tip.
+func RewindIngestor(c *BlockCache, height int) {
+	Log.Warning("Rewinding block cache to height ", height)
+	c.Reorg(height + 1)
+	if IngestorHalted() != "" {
+		select {
+		case resumeIngestorChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func startIngestor(c *BlockCache) {
+	ingestorMutex.Lock()
+	defer ingestorMutex.Unlock()
 	if !ingestorRunning {
 		ingestorRunning = true
 		go BlockIngestor(c, 0)
 	}
 }
+
+// StopIngestor asks the block ingestor to stop once it's done with the
+// current block (it stops sleeping or waiting for a block right away), and
+// waits up to the timeout for it to exit, so that the cache can be closed.
+// It returns false if the ingestor didn't stop in time.
+func StopIngestor(timeout time.Duration) bool {
+	if atomic.LoadInt32(&ingestorsActive) == 0 {
+		return true
+	}
+	deadline := time.After(timeout)
+	select {
+	case stopIngestorChan <- struct{}{}:
+	default:
+		// (Not racing the deadline if the ingestor is ready to stop.)
+		select {
+		case stopIngestorChan <- struct{}{}:
+		case <-deadline:
+			return false
+		}
+	}
+	ingestorMutex.Lock()
+	ingestorRunning = false
+	ingestorMutex.Unlock()
+	exited := make(chan struct{})
+	go func() {
+		ingestorsWG.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
 func stopIngestor() {
+	ingestorMutex.Lock()
+	defer ingestorMutex.Unlock()
 	if ingestorRunning {
 		ingestorRunning = false
 		stopIngestorChan <- struct{}{}
 	}
 }
 
+// ingestorSleep sleeps (see Sleep) for the given time, returning true early
+// if the ingestor is asked to stop.
+func ingestorSleep(d time.Duration) bool {
+	slept := make(chan struct{})
+	go func() {
+		Sleep(d)
+		close(slept)
+	}()
+	select {
+	case <-stopIngestorChan:
+		return true
+	case <-slept:
+		return false
+	}
+}
+
 // BlockIngestor runs as a goroutine and polls zcashd for new blocks, adding them
 // to the cache. The repetition count, rep, is nonzero only for unit-testing.
 func BlockIngestor(c *BlockCache, rep int) {
+	ingestorsWG.Add(1)
+	defer ingestorsWG.Done()
+	atomic.AddInt32(&ingestorsActive, 1)
+	defer atomic.AddInt32(&ingestorsActive, -1)
 	lastLog := time.Now()
 	reorgCount := 0
 	lastHeightLogged := 0
 	retryCount := 0
 	wait := true
+	// Catch up at the start, and when blocks are being added as fast as
+	// they're fetched (so there's no GetBlockchainInfo at the tip).
+	catchingUp := SyncWorkers > 1
+	added := 0
 
 	// Start listening for new blocks
 	for i := 0; rep == 0 || i < rep; i++ {
@@ -295,34 +538,59 @@ func BlockIngestor(c *BlockCache, rep int) {
 			return
 		default:
 		}
+		if catchingUp {
+			if stopped, _ := catchUp(c); stopped {
+				return
+			}
+			catchingUp = false
+			added = 0
+		}
 
 		height := c.GetNextHeight()
-		block, err := getBlockFromRPC(height)
+		block, err := getFullBlockFromRPC(height)
 		if err != nil {
 			Log.WithFields(logrus.Fields{
 				"height": height,
 				"error":  err,
 			}).Warn("error zcashd getblock rpc")
 			retryCount++
-			if retryCount > 10 {
+			delay := 10 * time.Second
+			if (isUnreachable(err) || retryCount > 10) && !ZcashdUnavailable() {
 				Log.WithFields(logrus.Fields{
 					"timeouts": retryCount,
-				}).Fatal("unable to issue RPC call to zcashd node")
+				}).Error("unable to issue RPC call to zcashd node, serving cached blocks only")
+				setZcashdUnavailable(true)
+			}
+			if retryCount > 10 {
+				// Back off, but keep trying; ingestion resumes by itself.
+				delay <<= uint(retryCount - 10)
+				if delay > maxRetryDelay || delay <= 0 {
+					delay = maxRetryDelay
+				}
 			}
 			// Delay then retry the same height.
 			c.Sync()
-			Sleep(10 * time.Second)
+			if ingestorSleep(delay) {
+				return
+			}
 			wait = true
 			continue
 		}
+		if ZcashdUnavailable() {
+			Log.Warn("zcashd is reachable again, resuming block ingestion")
+			setZcashdUnavailable(false)
+		}
 		retryCount = 0
 		if block == nil {
 			// No block at this height.
+			added = 0
 			if height == c.GetFirstHeight() {
 				Log.Info("Waiting for zcashd height to reach Sapling activation height ",
 					"(", c.GetFirstHeight(), ")...")
 				reorgCount = 0
-				Sleep(20 * time.Second)
+				if ingestorSleep(20 * time.Second) {
+					return
+				}
 				continue
 			}
 			if wait {
@@ -332,35 +600,53 @@ func BlockIngestor(c *BlockCache, rep int) {
 					Log.Info("Ingestor waiting for block: ", height)
 					lastHeightLogged = height - 1
 				}
-				Sleep(2 * time.Second)
+				if waitForBlock(2 * time.Second) {
+					return
+				}
 				wait = false
 				continue
 			}
 		}
-		if block == nil || c.HashMismatch(block.PrevHash) {
+		if block == nil || c.HashMismatch(block.GetPrevHash()) {
 			// This may not be a reorg; it may be we're at the tip
 			// and there's no new block yet, but we want to back up
 			// so we detect a reorg in which the new chain is the
 			// same length or shorter.
 			reorgCount++
-			if reorgCount > 100 {
-				Log.Fatal("Reorg exceeded max of 100 blocks! Help!")
+			if reorgCount > MaxReorgDepth {
+				reason := fmt.Sprint("reorg exceeded max depth of ", MaxReorgDepth, " blocks at height ", height)
+				Log.Error("Ingestor halted: ", reason, "; cached blocks are still being served, ",
+					"rewind or resync (see --http-admin) to continue")
+				setIngestorHalted(reason)
+				select {
+				case <-stopIngestorChan:
+					return
+				case <-resumeIngestorChan:
+				}
+				Log.Info("Ingestor resuming at height ", c.GetNextHeight())
+				setIngestorHalted("")
+				catchingUp = SyncWorkers > 1
+				reorgCount = 0
+				reorgDepthGauge.Set(0)
+				continue
 			}
 			// Print the hash of the block that is getting reorg-ed away
 			// as 'phash', not the prevhash of the block we just received.
 			if block != nil {
 				Log.WithFields(logrus.Fields{
 					"height": height,
-					"hash":   displayHash(block.Hash),
+					"hash":   displayHash(block.GetEncodableHash()),
 					"phash":  displayHash(c.GetLatestHash()),
 					"reorg":  reorgCount,
 				}).Warn("REORG")
+				reorgDepthGauge.Set(float64(reorgCount))
 			} else if reorgCount > 1 {
 				Log.WithFields(logrus.Fields{
 					"height": height,
 					"phash":  displayHash(c.GetLatestHash()),
 					"reorg":  reorgCount,
 				}).Warn("REORG")
+				reorgDepthGauge.Set(float64(reorgCount))
 			}
 			// Try backing up
 			c.Reorg(height - 1)
@@ -369,9 +655,14 @@ func BlockIngestor(c *BlockCache, rep int) {
 		// We have a valid block to add.
 		wait = true
 		reorgCount = 0
-		if err := c.Add(height, block); err != nil {
+		reorgDepthGauge.Set(0)
+		if err := c.AddBlock(height, block); err != nil {
 			Log.Fatal("Cache add failed:", err)
 		}
+		if added++; SyncWorkers > 1 && added >= catchUpMargin {
+			// Far enough behind the tip to catch up.
+			catchingUp = true
+		}
 		// Don't log these too often.
 		if time.Now().Sub(lastLog).Seconds() >= 4 && c.GetNextHeight() == height+1 && height != lastHeightLogged {
 			lastLog = time.Now()
@@ -392,6 +683,9 @@ func GetBlock(cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
 	}
 
 	// Not in the cache, ask zcashd
+	if ZcashdUnavailable() {
+		return nil, ErrZcashdUnavailable
+	}
 	block, err := getBlockFromRPC(height)
 	if err != nil {
 		return nil, err
@@ -403,8 +697,96 @@ func GetBlock(cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
 	return block, nil
 }
 
+// GetRawTransaction returns the transaction with the given (little-endian)
+// txid, and the height of its block (zero if it's in the mempool), first
+// from the cache's tx index, then, if it's not there, from zcashd.
+func GetRawTransaction(cache *BlockCache, txid []byte) (*walletrpc.RawTransaction, error) {
+	if tx := cache.GetTransaction(txid); tx != nil {
+		return tx, nil
+	}
+	if ZcashdUnavailable() {
+		return nil, ErrZcashdUnavailable
+	}
+	txBytes, height, err := Chain.GetRawTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+	return &walletrpc.RawTransaction{Data: txBytes, Height: uint64(height)}, nil
+}
+
+// GetRawTransactions is GetRawTransaction for several transactions,
+// getting the ones that aren't in the cache's tx index from zcashd in one
+// round trip; there's an error for each one (nil if it was found).
+func GetRawTransactions(cache *BlockCache, txids [][]byte) ([]*walletrpc.RawTransaction, []error) {
+	txs := make([]*walletrpc.RawTransaction, len(txids))
+	errs := make([]error, len(txids))
+	var missing []int
+	for i, txid := range txids {
+		if txs[i] = cache.GetTransaction(txid); txs[i] == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return txs, errs
+	}
+	if ZcashdUnavailable() {
+		for _, i := range missing {
+			errs[i] = ErrZcashdUnavailable
+		}
+		return txs, errs
+	}
+	fetch := make([][]byte, len(missing))
+	for j, i := range missing {
+		fetch[j] = txids[i]
+	}
+	fetched, fetchErrs := Chain.GetRawTransactions(fetch)
+	for j, i := range missing {
+		txs[i], errs[i] = fetched[j], fetchErrs[j]
+	}
+	return txs, errs
+}
+
+// GetMarshalledBlock is GetBlock, but returns the block in marshalled form
+// (as stored in the cache).
+func GetMarshalledBlock(cache *BlockCache, height int) ([]byte, error) {
+	if data := cache.GetMarshalled(height); data != nil {
+		return data, nil
+	}
+	block, err := GetBlock(cache, height)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(block)
+}
+
 // GetBlockRange returns a sequence of consecutive blocks in the given range.
 func GetBlockRange(cache *BlockCache, blockOut chan<- *walletrpc.CompactBlock, errOut chan<- error, start, end int) {
+	errOut <- forBlockRange(start, end, func(height int) error {
+		block, err := GetBlock(cache, height)
+		if err != nil {
+			return err
+		}
+		blockOut <- block
+		return nil
+	})
+}
+
+// GetMarshalledBlockRange is GetBlockRange, but returns the blocks in
+// marshalled form.
+func GetMarshalledBlockRange(cache *BlockCache, blockOut chan<- []byte, errOut chan<- error, start, end int) {
+	errOut <- forBlockRange(start, end, func(height int) error {
+		data, err := GetMarshalledBlock(cache, height)
+		if err != nil {
+			return err
+		}
+		blockOut <- data
+		return nil
+	})
+}
+
+// forBlockRange calls f for each height from start to end inclusive (in
+// reverse order if start > end), stopping at the first error.
+func forBlockRange(start, end int, f func(height int) error) error {
 	// Go over [start, end] inclusive
 	low := start
 	high := end
@@ -418,14 +800,11 @@ func GetBlockRange(cache *BlockCache, blockOut chan<- *walletrpc.CompactBlock, e
 			// reverse the order
 			j = high - (i - low)
 		}
-		block, err := GetBlock(cache, j)
-		if err != nil {
-			errOut <- err
-			return
+		if err := f(j); err != nil {
+			return err
 		}
-		blockOut <- block
 	}
-	errOut <- nil
+	return nil
 }
 
 func displayHash(hash []byte) string {
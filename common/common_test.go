@@ -5,10 +5,13 @@ package common
 
 import (
 	"bufio"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +19,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ------------------------------------------ Setup
@@ -112,7 +117,9 @@ func TestGetLightdInfo(t *testing.T) {
 	RawRequest = getLightdInfoStub
 	Sleep = sleepStub
 	// This calls the getblockchaininfo rpc just to establish connectivity with zcashd
-	FirstRPC()
+	if err := FirstRPC(10); err != nil {
+		t.Fatal("FirstRPC failed", err)
+	}
 
 	// Ensure the retry happened as expected
 	logFile, err := ioutil.ReadFile("test-log")
@@ -283,6 +290,189 @@ func TestBlockIngestor(t *testing.T) {
 	os.RemoveAll(unitTestPath)
 }
 
+// Every block zcashd returns is on a different chain from the cache's.
+func getblockStubForked(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var height string
+	err := json.Unmarshal(params[0], &height)
+	if err != nil {
+		testT.Fatal("could not unmarshal height")
+	}
+	step++
+	h, _ := strconv.Atoi(height)
+	block := append(json.RawMessage{}, blocks[h-380640]...)
+	// first byte of the prevhash
+	if block[9] == '0' {
+		block[9] = '1'
+	} else {
+		block[9] = '0'
+	}
+	return block, nil
+}
+
+func TestBlockIngestorReorgHalt(t *testing.T) {
+	testT = t
+	RawRequest = getblockStubForked
+	Sleep = sleepStub
+	MaxReorgDepth = 2
+	os.RemoveAll(unitTestPath)
+	testcache := NewBlockCache(unitTestPath, unitTestChain, 380640, false)
+	for i := 0; i < 3; i++ {
+		block, err := getBlockFromRPC(380640 + i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Add() doesn't check linkage (the ingestor does).
+		if err := testcache.Add(380640+i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	step = 0
+	done := make(chan struct{})
+	go func() {
+		// 380643, 380642, 380641 mismatch (halt), then 380640 after resync.
+		BlockIngestor(testcache, 4)
+		close(done)
+	}()
+	for IngestorHalted() == "" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Backing up stopped at the max depth; the remaining blocks are served.
+	if testcache.GetLatestHeight() != 380640 {
+		t.Fatal("unexpected latest height", testcache.GetLatestHeight())
+	}
+	if !strings.Contains(IngestorHalted(), "max depth of 2") {
+		t.Fatal("unexpected halt reason", IngestorHalted())
+	}
+	if step != 3 {
+		t.Fatal("unexpected step", step)
+	}
+
+	// Resync from the beginning.
+	RewindIngestor(testcache, 380639)
+	<-done
+	if IngestorHalted() != "" {
+		t.Fatal("ingestor should no longer be halted")
+	}
+	if testcache.GetLatestHeight() != 380640 {
+		t.Fatal("unexpected latest height", testcache.GetLatestHeight())
+	}
+	MaxReorgDepth = 100
+	step = 0
+	sleepCount = 0
+	sleepDuration = 0
+	os.RemoveAll(unitTestPath)
+}
+
+// zcashd is down for a while, then comes back.
+func getblockStubUnreachable(method string, params []json.RawMessage) (json.RawMessage, error) {
+	step++
+	if step == 2 && !ZcashdUnavailable() {
+		// A connection error is enough, no need to wait for more.
+		testT.Error("zcashd should be marked unavailable after one failure")
+	}
+	if step <= 12 {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	if !ZcashdUnavailable() {
+		testT.Error("zcashd should be marked unavailable")
+	}
+	// Cache misses don't go to zcashd while it's unavailable.
+	if _, err := GetBlock(&BlockCache{}, 380641); err != ErrZcashdUnavailable {
+		testT.Error("unexpected GetBlock error", err)
+	}
+	return blocks[0], nil
+}
+
+func TestBlockIngestorUnreachable(t *testing.T) {
+	testT = t
+	RawRequest = getblockStubUnreachable
+	Sleep = sleepStub
+	os.RemoveAll(unitTestPath)
+	testcache := NewBlockCache(unitTestPath, unitTestChain, 380640, false)
+	BlockIngestor(testcache, 13)
+	if step != 13 {
+		t.Error("unexpected final step", step)
+	}
+	if ZcashdUnavailable() {
+		t.Error("zcashd should be available again")
+	}
+	if testcache.GetLatestHeight() != 380640 {
+		t.Error("unexpected latest height", testcache.GetLatestHeight())
+	}
+	// 10 retries at 10 seconds, then backing off: 20, 40 seconds.
+	if sleepCount != 12 || sleepDuration != 160*time.Second {
+		t.Error("unexpected sleeps", sleepCount, sleepDuration)
+	}
+	step = 0
+	sleepCount = 0
+	sleepDuration = 0
+	os.RemoveAll(unitTestPath)
+}
+
+func TestStopIngestor(t *testing.T) {
+	// Nothing to stop
+	if !StopIngestor(time.Second) {
+		t.Fatal("StopIngestor with no ingestor should succeed")
+	}
+
+	stub := NewStubBackend("main", 380640)
+	for _, blockJSON := range blocks {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		stub.AddBlock(blockData)
+	}
+	saveChain, saveSleep := Chain, Sleep
+	defer func() { Chain, Sleep = saveChain, saveSleep }()
+	Chain = stub
+	// At the tip, the ingestor sleeps (waiting for a block) for longer
+	// than the test runs; stopping it must interrupt that.
+	Sleep = func(d time.Duration) { time.Sleep(time.Hour) }
+	os.RemoveAll(unitTestPath)
+	testcache := NewBlockCache(unitTestPath, unitTestChain, 380640, false)
+	events, _ := testcache.Subscribe()
+
+	done := make(chan struct{})
+	go func() {
+		BlockIngestor(testcache, 0)
+		close(done)
+	}()
+	// Wait for it to reach the tip.
+	for testcache.GetNextHeight() != 380644 {
+		time.Sleep(time.Millisecond)
+	}
+	if !StopIngestor(10 * time.Second) {
+		t.Fatal("StopIngestor timed out")
+	}
+	// It has exited by the time StopIngestor returns.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ingestor still running after StopIngestor")
+	}
+
+	testcache.CloseSubscriptions()
+	for range events {
+		// drain until closed
+	}
+	if !testcache.SubscriptionsClosed() {
+		t.Fatal("subscriptions should be closed")
+	}
+	if ch, _ := testcache.Subscribe(); ch == nil {
+		t.Fatal("Subscribe after close should return a (closed) channel")
+	} else if _, ok := <-ch; ok {
+		t.Fatal("Subscribe after close should return a closed channel")
+	}
+
+	// A closed cache ignores further changes.
+	testcache.Close()
+	testcache.Reorg(380641)
+	if testcache.GetNextHeight() != 380644 {
+		t.Fatal("Reorg after Close should do nothing")
+	}
+	os.RemoveAll(unitTestPath)
+}
+
 func TestGetBlockRange(t *testing.T) {
 	testT = t
 	RawRequest = getblockStub
@@ -414,3 +604,25 @@ func TestGenerateCerts(t *testing.T) {
 		t.Fatal("GenerateCerts returned nil")
 	}
 }
+
+func TestFirstRPCUnreachable(t *testing.T) {
+	saveRawRequest, saveSleep := RawRequest, Sleep
+	defer func() { RawRequest, Sleep = saveRawRequest, saveSleep }()
+	calls := 0
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		calls++
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	Sleep = func(time.Duration) {}
+	err := FirstRPC(2)
+	if calls != 3 {
+		t.Fatal("unexpected number of tries", calls)
+	}
+	// The client sees Unavailable, not the connection error.
+	if status.Code(err) != codes.Unavailable || strings.Contains(status.Convert(err).Message(), "refused") {
+		t.Fatal("unexpected FirstRPC error", err)
+	}
+	if !strings.Contains(err.Error(), "connection refused") {
+		t.Fatal("the error should keep its cause", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// chainEventBuffer is how many undelivered events a subscriber may have
+// before it's considered too slow and is dropped; the ingestor must never
+// block on a wallet's network connection.
+const chainEventBuffer = 100
+
+// chainEvents fans out chain tip changes to SubscribeChainEvents streams.
+type chainEvents struct {
+	subscribers map[chan *walletrpc.ChainEvent]struct{}
+	closed      bool // lightwalletd is shutting down
+	mutex       sync.Mutex
+}
+
+// Subscribe returns a channel on which chain events are delivered, and
+// a function to cancel the subscription. The channel is closed if the
+// subscriber falls too far behind.
+func (c *BlockCache) Subscribe() (<-chan *walletrpc.ChainEvent, func()) {
+	e := &c.events
+	ch := make(chan *walletrpc.ChainEvent, chainEventBuffer)
+	e.mutex.Lock()
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan *walletrpc.ChainEvent]struct{})
+	}
+	if e.closed {
+		close(ch)
+	} else {
+		e.subscribers[ch] = struct{}{}
+	}
+	e.mutex.Unlock()
+	cancel := func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// CloseSubscriptions closes all subscribers' channels, and those of any
+// later subscribers, so their streams end (when shutting down).
+func (c *BlockCache) CloseSubscriptions() {
+	e := &c.events
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.closed = true
+	for ch := range e.subscribers {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+}
+
+// SubscriptionsClosed is true after CloseSubscriptions().
+func (c *BlockCache) SubscriptionsClosed() bool {
+	c.events.mutex.Lock()
+	defer c.events.mutex.Unlock()
+	return c.events.closed
+}
+
+// publish delivers the event to all subscribers without blocking.
+func (e *chainEvents) publish(event *walletrpc.ChainEvent) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			Log.Warning("chain event subscriber is not keeping up, dropping it")
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) publishAdd(height int, block *walletrpc.CompactBlock) {
+	oldHash, replaced := c.removedHashes[height]
+	if replaced {
+		if bytes.Equal(oldHash, block.Hash) {
+			// The ingestor backed up (as it does when it's at the tip)
+			// and then re-added the same block; nothing has changed.
+			delete(c.removedHashes, height)
+			return
+		}
+		// A block we had served at this height has been replaced; anything
+		// that was removed above it is implied by this one event.
+		c.removedHashes = nil
+		c.events.publish(&walletrpc.ChainEvent{
+			Kind:    walletrpc.ChainEvent_REORG,
+			Height:  uint64(height - 1),
+			Hash:    block.Hash,
+			OldHash: oldHash,
+		})
+	}
+	c.events.publish(&walletrpc.ChainEvent{
+		Kind:   walletrpc.ChainEvent_BLOCK_ADDED,
+		Height: uint64(height),
+		Hash:   block.Hash,
+	})
+}
+
+// Remember the hashes of blocks being removed (heights height and above)
+// so that a later Add() can tell whether the chain really changed.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) rememberRemoved(height int) {
+	if c.removedHashes == nil {
+		c.removedHashes = make(map[int][]byte)
+	}
+	for h := height; h < c.nextBlock; h++ {
+		if _, ok := c.removedHashes[h]; ok {
+			continue
+		}
+		if block := c.readBlock(h); block != nil {
+			c.removedHashes[h] = block.Hash
+		}
+	}
+}
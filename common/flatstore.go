@@ -0,0 +1,545 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// flatFileStore is the original BlockStore: the blocks file has each
+// block, preceded by its checksum, one after another; the lengths file
+// has the length of each (4 bytes, little-endian).
+//
+// The commit file has the heights of the first block and of the next one
+// as of the last commit (Sync), when both files were flushed to disk. After
+// a crash, the blocks added since then may be missing or partly written, so
+// those are checked when the store is opened, and any from the first bad
+// one on are discarded (without the loss of committed blocks). A prune is
+// journaled in the commit file too (see Prune).
+type flatFileStore struct {
+	lengthsName, blocksName string // pathnames
+	commitName              string
+	lengthsFile, blocksFile *os.File
+	starts                  []int64 // Starting offset of each block within blocksFile
+	first                   int     // height of the first block
+	committed               int     // next height as of the last commit
+}
+
+// The flat store changes its files only through these (mockable for
+// testing, to simulate a crash at any point).
+var (
+	fileWrite    = func(f *os.File, b []byte) (int, error) { return f.Write(b) }
+	fileTruncate = func(f *os.File, size int64) error { return f.Truncate(size) }
+	fileSync     = func(f *os.File) error { return f.Sync() }
+	fileRename   = os.Rename
+)
+
+// fileWriter is an io.Writer that writes using fileWrite.
+type fileWriter struct {
+	f *os.File
+}
+
+func (w fileWriter) Write(b []byte) (int, error) {
+	return fileWrite(w.f, b)
+}
+
+func dbFileNames(dbPath string, chainName string) (string, string) {
+	return filepath.Join(dbPath, chainName, "lengths"),
+		filepath.Join(dbPath, chainName, "blocks")
+}
+
+func openFlatFileStore(dbPath, chainName string, startHeight int, redownload bool) (*flatFileStore, error) {
+	s := &flatFileStore{first: startHeight, committed: startHeight}
+	s.lengthsName, s.blocksName = dbFileNames(dbPath, chainName)
+	s.commitName = filepath.Join(dbPath, chainName, "commit")
+	var err error
+	if err := os.MkdirAll(filepath.Join(dbPath, chainName), 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir "+dbPath+" failed")
+	}
+	if redownload {
+		if err := os.Remove(s.commitName); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "remove "+s.commitName+" failed")
+		}
+	}
+	if err := s.readCommit(); err != nil {
+		return nil, err
+	}
+	s.blocksFile, err = os.OpenFile(s.blocksName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open "+s.blocksName+" failed")
+	}
+	s.lengthsFile, err = os.OpenFile(s.lengthsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		s.blocksFile.Close()
+		return nil, errors.Wrap(err, "open "+s.lengthsName+" failed")
+	}
+	if redownload {
+		if err := fileTruncate(s.lengthsFile, 0); err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "truncate lengths file failed")
+		}
+		if err := fileTruncate(s.blocksFile, 0); err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "truncate blocks file failed")
+		}
+	}
+	lengths, err := ioutil.ReadFile(s.lengthsName)
+	if err != nil {
+		s.Close()
+		return nil, errors.Wrap(err, "read "+s.lengthsName+" failed")
+	}
+
+	// The last entry in starts[] is where to write the next block.
+	var offset int64
+	s.starts = append(s.starts, 0)
+	for i := 0; i < len(lengths)/4; i++ {
+		length := binary.LittleEndian.Uint32(lengths[i*4 : (i+1)*4])
+		if length < minStoredBlockSize || length > maxStoredBlockSize {
+			s.badEntry(s.first+i, fmt.Sprint("lengths file has impossible value ", length))
+			break
+		}
+		offset += int64(length) + 8
+		s.starts = append(s.starts, offset)
+	}
+	if _, next := s.Heights(); len(lengths)%4 != 0 && next == s.first+len(lengths)/4 {
+		s.badEntry(next, "lengths file has a partial entry")
+	}
+	// Check the uncommitted blocks.
+	for height := s.committed; height < s.first+len(s.starts)-1; height++ {
+		if _, err := s.Read(height); err != nil {
+			s.badEntry(height, err.Error())
+			s.starts = s.starts[:height-s.first+1]
+			break
+		}
+	}
+	// Remove any partial, impossible or bad entries (and their blocks).
+	if err := s.Truncate(s.first + len(s.starts) - 1); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// badEntry reports that the entry at the given height (and any after it)
+// will be discarded. That's expected for an uncommitted block, after a
+// crash, but otherwise it's corruption.
+func (s *flatFileStore) badEntry(height int, reason string) {
+	if height >= s.committed {
+		Log.Info("discarding uncommitted blocks from height ", height, ": ", reason)
+		return
+	}
+	Log.Warning(reason)
+	s.saveCorrupted()
+}
+
+// readCommit reads the commit file, finishing a prune that was interrupted.
+func (s *flatFileStore) readCommit() error {
+	first, next, prune, found, err := readCommitRecord(s.commitName)
+	if err != nil || !found {
+		return err
+	}
+	s.first, s.committed = first, next
+	if prune {
+		Log.Info("finishing an interrupted prune to height ", first)
+		return s.finishPrune(first, next)
+	}
+	return nil
+}
+
+// readCommitRecord returns the heights in the commit record, and whether
+// it's for a prune that's to be finished; found is false if there's no
+// record.
+func readCommitRecord(commitName string) (first, next int, prune, found bool, err error) {
+	record, err := ioutil.ReadFile(commitName)
+	if os.IsNotExist(err) {
+		return 0, 0, false, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, false, errors.Wrap(err, "read "+commitName+" failed")
+	}
+	fields := strings.Fields(string(record))
+	if len(fields) < 2 {
+		return 0, 0, false, false, errors.New("bad commit record in " + commitName)
+	}
+	if first, err = strconv.Atoi(fields[0]); err == nil {
+		next, err = strconv.Atoi(fields[1])
+	}
+	if err != nil {
+		return 0, 0, false, false, errors.Wrap(err, "read "+commitName+" failed")
+	}
+	return first, next, len(fields) > 2 && fields[2] == "prune", true, nil
+}
+
+// scanFlatFileStore calls f with each block in the flat store's files, in
+// order, or the problem with it (and a nil block), until f returns false,
+// without changing them (see VerifyCache). If the first height isn't
+// recorded, it's the first block's. It returns the range of heights of the
+// entries in the files.
+func scanFlatFileStore(dbPath, chainName string, f func(height int, data []byte, problem string) bool) (int, int, error) {
+	lengthsName, blocksName := dbFileNames(dbPath, chainName)
+	first, _, prune, found, err := readCommitRecord(filepath.Join(dbPath, chainName, "commit"))
+	if err != nil {
+		return 0, 0, err
+	}
+	if prune {
+		return 0, 0, errors.New("a prune was interrupted (it's finished when lightwalletd next starts)")
+	}
+	lengths, err := ioutil.ReadFile(lengthsName)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "read "+lengthsName+" failed")
+	}
+	blocksFile, err := os.Open(blocksName)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "open "+blocksName+" failed")
+	}
+	defer blocksFile.Close()
+	n := len(lengths) / 4
+	var offset int64
+	for i := 0; i < n; i++ {
+		length := binary.LittleEndian.Uint32(lengths[i*4 : (i+1)*4])
+		problem := ""
+		b := make([]byte, int(length)+8)
+		if length < minStoredBlockSize || length > maxStoredBlockSize {
+			problem = fmt.Sprint("impossible length ", length, " in the lengths file")
+		} else if _, err := blocksFile.ReadAt(b, offset); err != nil {
+			problem = "missing from the blocks file"
+		}
+		if !found {
+			if problem != "" {
+				return 0, 0, errors.New("can't tell the first block's height: " + problem)
+			}
+			block, _, p := decodeStoredBlock(b[8:])
+			if block == nil {
+				return 0, 0, errors.New("can't tell the first block's height: " + p)
+			}
+			first, found = int(block.Height), true
+		}
+		if problem != "" {
+			// The blocks after this can't be located.
+			f(first+i, nil, problem)
+			return first, first + n, nil
+		}
+		offset += int64(len(b))
+		if !bytes.Equal(checksum(first+i, b[8:]), b[:8]) {
+			if !f(first+i, nil, "bad checksum") {
+				return first, first + n, nil
+			}
+			continue
+		}
+		if !f(first+i, b[8:], "") {
+			return first, first + n, nil
+		}
+	}
+	if len(lengths)%4 != 0 {
+		f(first+n, nil, "partial entry at the end of the lengths file")
+	}
+	return first, first + n, nil
+}
+
+// writeCommit atomically replaces the commit record with the given
+// heights; prune means that the pruned files are ready (see Prune).
+func (s *flatFileStore) writeCommit(first, next int, prune bool) error {
+	record := fmt.Sprintf("%d %d", first, next)
+	if prune {
+		record += " prune"
+	}
+	tmp := s.commitName + ".tmp"
+	if err := writeFileSync(tmp, strings.NewReader(record+"\n")); err != nil {
+		return err
+	}
+	if err := fileRename(tmp, s.commitName); err != nil {
+		return errors.Wrap(err, "rename "+tmp+" failed")
+	}
+	syncDir(filepath.Dir(s.commitName))
+	s.committed = next
+	return nil
+}
+
+// Heights implements BlockStore.
+func (s *flatFileStore) Heights() (int, int) {
+	return s.first, s.first + len(s.starts) - 1
+}
+
+// Calculate the 8-byte checksum that precedes each block in the blocks file.
+func checksum(height int, b []byte) []byte {
+	h := make([]byte, 8)
+	binary.LittleEndian.PutUint64(h, uint64(height))
+	cs := fnv.New64a()
+	cs.Write(h)
+	cs.Write(b)
+	return cs.Sum(nil)
+}
+
+// Append implements BlockStore.
+func (s *flatFileStore) Append(height int, data []byte) error {
+	if len(s.starts) == 1 && height != s.first {
+		// Empty, starting at a different height.
+		if err := s.writeCommit(height, height, false); err != nil {
+			return err
+		}
+		s.first = height
+	}
+	if _, next := s.Heights(); height != next {
+		return errors.Errorf("append at height %d, expecting %d", height, next)
+	}
+	b := append(checksum(height, data), data...)
+	n, err := fileWrite(s.blocksFile, b)
+	if err != nil {
+		return errors.Wrap(err, "blocks write failed")
+	}
+	if n != len(b) {
+		return errors.Errorf("blocks write incorrect length: expected: %d written: %d", len(b), n)
+	}
+	b = make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	n, err = fileWrite(s.lengthsFile, b)
+	if err != nil {
+		return errors.Wrap(err, "lengths write failed")
+	}
+	if n != len(b) {
+		return errors.Errorf("lengths write incorrect length: expected: %d written: %d", len(b), n)
+	}
+	offset := s.starts[len(s.starts)-1]
+	s.starts = append(s.starts, offset+int64(len(data)+8))
+	return nil
+}
+
+// Truncate implements BlockStore.
+func (s *flatFileStore) Truncate(height int) error {
+	if height < s.first {
+		height = s.first
+	}
+	index := height - s.first
+	if index >= len(s.starts) {
+		return nil
+	}
+	if err := fileTruncate(s.lengthsFile, int64(index*4)); err != nil {
+		return errors.Wrap(err, "truncate lengths file failed")
+	}
+	if err := fileTruncate(s.blocksFile, s.starts[index]); err != nil {
+		return errors.Wrap(err, "truncate blocks file failed")
+	}
+	s.starts = s.starts[:index+1]
+	if height < s.committed {
+		// Commit now, so that blocks appended at these heights aren't
+		// taken for committed ones after a crash.
+		return s.Sync()
+	}
+	return nil
+}
+
+// Prune implements BlockStore. It copies the blocks that remain to new
+// files, then commits, journaling that these are to replace the old files,
+// then renames them; if that's interrupted, it's finished when the store is
+// next opened.
+func (s *flatFileStore) Prune(height int) error {
+	if height <= s.first {
+		return nil
+	}
+	_, next := s.Heights()
+	if height >= next {
+		if err := s.Truncate(s.first); err != nil {
+			return err
+		}
+		if err := s.writeCommit(height, height, false); err != nil {
+			return err
+		}
+		s.first = height
+		return nil
+	}
+	index := height - s.first
+	lengths := make([]byte, 4*(next-height))
+	if _, err := s.lengthsFile.ReadAt(lengths, int64(4*index)); err != nil {
+		return errors.Wrap(err, "read lengths file failed")
+	}
+	end := s.starts[len(s.starts)-1]
+	blocks := io.NewSectionReader(s.blocksFile, s.starts[index], end-s.starts[index])
+	if err := writeFileSync(s.blocksName+".tmp", blocks); err != nil {
+		return err
+	}
+	if err := writeFileSync(s.lengthsName+".tmp", bytes.NewReader(lengths)); err != nil {
+		return err
+	}
+	if err := s.writeCommit(height, next, true); err != nil {
+		return err
+	}
+	if err := s.finishPrune(height, next); err != nil {
+		return err
+	}
+	// Reopen the replaced files.
+	s.lengthsFile.Close()
+	s.blocksFile.Close()
+	var err error
+	s.blocksFile, err = os.OpenFile(s.blocksName, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open "+s.blocksName+" failed")
+	}
+	s.lengthsFile, err = os.OpenFile(s.lengthsName, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open "+s.lengthsName+" failed")
+	}
+	s.first = height
+	offset := s.starts[index]
+	s.starts = s.starts[index:]
+	for i := range s.starts {
+		s.starts[i] -= offset
+	}
+	return nil
+}
+
+// finishPrune replaces the lengths and blocks files with the pruned ones
+// (those that haven't been already), then commits the pruned heights.
+func (s *flatFileStore) finishPrune(first, next int) error {
+	for _, name := range []string{s.blocksName, s.lengthsName} {
+		if err := fileRename(name+".tmp", name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "rename "+name+".tmp failed")
+		}
+	}
+	return s.writeCommit(first, next, false)
+}
+
+// writeFileSync creates (or replaces) the named file with the given
+// contents and flushes it to disk.
+func writeFileSync(name string, contents io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "create "+name+" failed")
+	}
+	if _, err := io.Copy(fileWriter{f}, contents); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write "+name+" failed")
+	}
+	if err := fileSync(f); err != nil {
+		f.Close()
+		return errors.Wrap(err, "sync "+name+" failed")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close "+name+" failed")
+	}
+	return nil
+}
+
+// syncDir flushes the directory (a rename in it) to disk, where that's
+// possible; it isn't on all operating systems, so errors are ignored.
+func syncDir(name string) {
+	if d, err := os.Open(name); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Read implements BlockStore; it verifies the block's checksum.
+func (s *flatFileStore) Read(height int) ([]byte, error) {
+	index := height - s.first
+	if index < 0 || index >= len(s.starts)-1 {
+		return nil, errors.Errorf("no block at height %d", height)
+	}
+	// not including the checksum
+	blockLen := int(s.starts[index+1] - s.starts[index] - 8)
+	b := make([]byte, blockLen+8)
+	offset := s.starts[index]
+	n, err := s.blocksFile.ReadAt(b, offset)
+	if err != nil || n != len(b) {
+		return nil, errors.Errorf("blocks read offset: %d failed: %d %v", offset, n, err)
+	}
+	diskcs := b[:8]
+	b = b[8 : blockLen+8]
+	if !bytes.Equal(checksum(height, b), diskcs) {
+		return nil, errors.Errorf("bad block checksum at height: %d offset: %d", height, offset)
+	}
+	return b, nil
+}
+
+// Iterate implements BlockStore.
+func (s *flatFileStore) Iterate(start int, f func(int, []byte) bool) error {
+	if start < s.first {
+		start = s.first
+	}
+	for {
+		// (The blocks may be truncated by f.)
+		if _, next := s.Heights(); start >= next {
+			return nil
+		}
+		data, err := s.Read(start)
+		if err != nil {
+			return err
+		}
+		if !f(start, data) {
+			return nil
+		}
+		start++
+	}
+}
+
+// Sync implements BlockStore; it commits the blocks.
+func (s *flatFileStore) Sync() error {
+	if err := fileSync(s.lengthsFile); err != nil {
+		return err
+	}
+	if err := fileSync(s.blocksFile); err != nil {
+		return err
+	}
+	if first, next := s.Heights(); next != s.committed {
+		return s.writeCommit(first, next, false)
+	}
+	return nil
+}
+
+// Close implements BlockStore.
+func (s *flatFileStore) Close() error {
+	// Some operating system require you to close files before you can remove them.
+	err := s.lengthsFile.Close()
+	if blocksErr := s.blocksFile.Close(); err == nil {
+		err = blocksErr
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// checksummed implements checksummedStore.
+func (s *flatFileStore) checksummed() {}
+
+// saveCorrupted implements corruptionSaver.
+func (s *flatFileStore) saveCorrupted() {
+	// Save the corrupted files for post-mortem analysis.
+	save := s.lengthsName + "-corrupted"
+	if err := copyFile(s.lengthsName, save); err != nil {
+		Log.Warning("Could not copy db lengths file: ", err)
+	}
+	save = s.blocksName + "-corrupted"
+	if err := copyFile(s.blocksName, save); err != nil {
+		Log.Warning("Could not copy db blocks file: ", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	blockLRUHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_cache_lru_hits_total",
+		Help: "Number of cached blocks read from the in-memory cache of recent blocks.",
+	})
+	blockLRUMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_cache_lru_misses_total",
+		Help: "Number of cached blocks read from storage because they weren't in memory.",
+	})
+	blockLRUBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_cache_lru_bytes",
+		Help: "Size of the marshalled blocks in the in-memory cache of recent blocks.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(blockLRUHits)
+	prometheus.MustRegister(blockLRUMisses)
+	prometheus.MustRegister(blockLRUBytes)
+}
+
+// BlockLRUSize is the most memory (in bytes of marshalled blocks) that each
+// BlockCache uses to keep recently added or read blocks; zero disables it.
+var BlockLRUSize = 64 * 1000 * 1000
+
+// blockLRU keeps recently used blocks, marshalled (not compressed), up to
+// a total size, discarding the least recently used ones to stay under it.
+// It has its own lock because blocks are read under BlockCache's read lock.
+// A nil blockLRU (when BlockLRUSize is zero) keeps nothing.
+type blockLRU struct {
+	mutex    sync.Mutex
+	maxBytes int
+	bytes    int
+	order    *list.List            // most recently used at the front
+	byHeight map[int]*list.Element // Value is *lruBlock
+}
+
+type lruBlock struct {
+	height int
+	data   []byte
+}
+
+func newBlockLRU(maxBytes int) *blockLRU {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &blockLRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		byHeight: make(map[int]*list.Element),
+	}
+}
+
+// get returns the block at the given height, or nil if it's not here.
+func (l *blockLRU) get(height int) []byte {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	e, ok := l.byHeight[height]
+	if !ok {
+		blockLRUMisses.Inc()
+		return nil
+	}
+	blockLRUHits.Inc()
+	l.order.MoveToFront(e)
+	return e.Value.(*lruBlock).data
+}
+
+// put adds (or replaces) the block at the given height; the data must not
+// be changed afterward.
+func (l *blockLRU) put(height int, data []byte) {
+	if l == nil || len(data) > l.maxBytes {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if e, ok := l.byHeight[height]; ok {
+		l.remove(e)
+	}
+	l.byHeight[height] = l.order.PushFront(&lruBlock{height: height, data: data})
+	l.bytes += len(data)
+	for l.bytes > l.maxBytes {
+		l.remove(l.order.Back())
+	}
+	blockLRUBytes.Set(float64(l.bytes))
+}
+
+// Caller should hold l.mutex.
+func (l *blockLRU) remove(e *list.Element) {
+	b := l.order.Remove(e).(*lruBlock)
+	delete(l.byHeight, b.height)
+	l.bytes -= len(b.data)
+}
+
+// removeIf removes the blocks whose heights satisfy the predicate.
+func (l *blockLRU) removeIf(f func(height int) bool) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for height, e := range l.byHeight {
+		if f(height) {
+			l.remove(e)
+		}
+	}
+	blockLRUBytes.Set(float64(l.bytes))
+}
+
+// removeFrom removes the blocks at the given height and above.
+func (l *blockLRU) removeFrom(height int) {
+	l.removeIf(func(h int) bool { return h >= height })
+}
+
+// removeBelow removes the blocks below the given height.
+func (l *blockLRU) removeBelow(height int) {
+	l.removeIf(func(h int) bool { return h < height })
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// CacheMetadata is what lightwalletd records about a chain's cache, in the
+// metadata file (JSON) in the chain's db directory. It lets lightwalletd
+// start serving the cache while zcashd is down, when it can't ask zcashd
+// which chain it's on.
+type CacheMetadata struct {
+	ChainName     string `json:"chain_name"`
+	SaplingHeight int    `json:"sapling_height"`
+	// CompactBlockHeaders is the setting the cached blocks were made with.
+	CompactBlockHeaders bool `json:"compact_block_headers"`
+}
+
+func cacheMetadataName(dbPath, chainName string) string {
+	return filepath.Join(dbPath, chainName, "metadata")
+}
+
+// WriteCacheMetadata replaces the metadata of the chain's cache.
+func WriteCacheMetadata(dbPath string, m *CacheMetadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	name := cacheMetadataName(dbPath, m.ChainName)
+	tmp := name + ".tmp"
+	if err := writeFileSync(tmp, bytes.NewReader(append(data, '\n'))); err != nil {
+		return err
+	}
+	if err := fileRename(tmp, name); err != nil {
+		return errors.Wrap(err, "rename "+tmp+" failed")
+	}
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+// ReadCacheMetadata returns the metadata of the chain's cache, or nil if
+// there isn't any.
+func ReadCacheMetadata(dbPath, chainName string) (*CacheMetadata, error) {
+	name := cacheMetadataName(dbPath, chainName)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read "+name+" failed")
+	}
+	m := &CacheMetadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "bad metadata in "+name)
+	}
+	return m, nil
+}
+
+// FindCacheMetadata returns the metadata of the cache in dbPath when the
+// chain isn't known; it's an error if there's no cache, or there are
+// caches for several chains.
+func FindCacheMetadata(dbPath string) (*CacheMetadata, error) {
+	names, err := filepath.Glob(cacheMetadataName(dbPath, "*"))
+	if err != nil {
+		return nil, err
+	}
+	switch len(names) {
+	case 0:
+		return nil, errors.New("there's no cache in " + dbPath)
+	case 1:
+		m, err := ReadCacheMetadata(dbPath, filepath.Base(filepath.Dir(names[0])))
+		if m == nil && err == nil {
+			err = errors.New("there's no cache in " + dbPath)
+		}
+		return m, err
+	}
+	return nil, errors.New("there are caches for several chains in " + dbPath)
+}
+
+// CheckCompactBlockHeaders returns an error if the chain's cache has blocks
+// that were made with a different CompactBlockHeaders setting, so clients
+// would get a mix of blocks with and without headers. (A cache without
+// metadata predates the setting; its blocks don't have headers.)
+func CheckCompactBlockHeaders(dbPath, chainName string, cache *BlockCache) error {
+	if cache.GetLatestHeight() < 0 {
+		return nil
+	}
+	m, err := ReadCacheMetadata(dbPath, chainName)
+	if err != nil {
+		return err
+	}
+	if headers := m != nil && m.CompactBlockHeaders; headers != CompactBlockHeaders {
+		return errors.Errorf("the cached blocks were made with compact-block-headers %v; "+
+			"use that, or redownload the cache", headers)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheMetadata(t *testing.T) {
+	os.RemoveAll(unitTestPath)
+	defer os.RemoveAll(unitTestPath)
+	if _, err := FindCacheMetadata(unitTestPath); err == nil {
+		t.Fatal("FindCacheMetadata should fail with no cache")
+	}
+	if m, err := ReadCacheMetadata(unitTestPath, "main"); m != nil || err != nil {
+		t.Fatal("ReadCacheMetadata should find nothing", m, err)
+	}
+	os.MkdirAll(filepath.Join(unitTestPath, "main"), 0755)
+	if err := WriteCacheMetadata(unitTestPath, &CacheMetadata{ChainName: "main", SaplingHeight: 419200}); err != nil {
+		t.Fatal("WriteCacheMetadata failed", err)
+	}
+	m, err := FindCacheMetadata(unitTestPath)
+	if err != nil || m.ChainName != "main" || m.SaplingHeight != 419200 {
+		t.Fatal("unexpected metadata", m, err)
+	}
+
+	// Which chain's cache to serve is ambiguous.
+	os.MkdirAll(filepath.Join(unitTestPath, "test"), 0755)
+	if err := WriteCacheMetadata(unitTestPath, &CacheMetadata{ChainName: "test", SaplingHeight: 280000}); err != nil {
+		t.Fatal("WriteCacheMetadata failed", err)
+	}
+	if _, err := FindCacheMetadata(unitTestPath); err == nil {
+		t.Fatal("FindCacheMetadata should fail with several chains")
+	}
+}
+
+func TestCheckCompactBlockHeaders(t *testing.T) {
+	defer func() { CompactBlockHeaders = false }()
+	os.RemoveAll(unitTestPath)
+	defer os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 380640, true)
+	defer c.Close()
+	CompactBlockHeaders = true
+	if err := CheckCompactBlockHeaders(unitTestPath, unitTestChain, c); err != nil {
+		t.Fatal("an empty cache can have either setting", err)
+	}
+
+	// A cache without metadata has blocks without headers.
+	var blockHex string
+	json.Unmarshal(blocks[0], &blockHex)
+	blockData, _ := hex.DecodeString(blockHex)
+	block, _ := parseBlock(blockData, 380640)
+	CompactBlockHeaders = false
+	c.AddBlock(380640, block)
+	if err := CheckCompactBlockHeaders(unitTestPath, unitTestChain, c); err != nil {
+		t.Fatal("unexpected mismatch", err)
+	}
+	CompactBlockHeaders = true
+	if err := CheckCompactBlockHeaders(unitTestPath, unitTestChain, c); err == nil {
+		t.Fatal("mismatch not found")
+	}
+	m := &CacheMetadata{ChainName: unitTestChain, SaplingHeight: 380640, CompactBlockHeaders: true}
+	if err := WriteCacheMetadata(unitTestPath, m); err != nil {
+		t.Fatal("WriteCacheMetadata failed", err)
+	}
+	if err := CheckCompactBlockHeaders(unitTestPath, unitTestChain, c); err != nil {
+		t.Fatal("unexpected mismatch", err)
+	}
+}
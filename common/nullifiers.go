@@ -0,0 +1,72 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// NullifierIndex keeps the nullifiers of the cached blocks, with the
+// transactions that reveal them (that spend their notes). The BlockCache
+// keeps it in step with its blocks (see SetNullifierIndex).
+type NullifierIndex struct {
+	*blockIndex
+}
+
+// A nullifier's key is its protocol (one byte) then the nullifier.
+const nullifierSize = 32
+
+// OpenNullifierIndex opens (or creates) the chain's nullifier index in
+// dbPath, emptying it if redownload is set.
+func OpenNullifierIndex(dbPath, chainName string, redownload bool) (*NullifierIndex, error) {
+	index, err := openBlockIndex(dbPath, chainName, "nullifiers.db", 1+nullifierSize, redownload)
+	if err != nil {
+		return nil, err
+	}
+	return &NullifierIndex{index}, nil
+}
+
+func nullifierKey(protocol walletrpc.ShieldedProtocol, nf []byte) []byte {
+	return append([]byte{byte(protocol)}, nf...)
+}
+
+// nullifierEntries returns the index entries for the block's nullifiers:
+// each one's value is the txid of its transaction.
+func nullifierEntries(block *walletrpc.CompactBlock) []indexEntry {
+	var entries []indexEntry
+	for _, tx := range block.Vtx {
+		for _, spend := range tx.Spends {
+			if len(spend.Nf) == nullifierSize {
+				entries = append(entries, indexEntry{
+					key:   nullifierKey(walletrpc.ShieldedProtocol_sapling, spend.Nf),
+					value: tx.Hash,
+				})
+			}
+		}
+	}
+	return entries
+}
+
+// Get returns the status of the given nullifier.
+func (n *NullifierIndex) Get(nf *walletrpc.Nullifier) (*walletrpc.NullifierStatus, error) {
+	if nf.Protocol != walletrpc.ShieldedProtocol_sapling {
+		return nil, errors.Errorf("%s nullifiers aren't indexed", nf.Protocol)
+	}
+	if len(nf.Nf) != nullifierSize {
+		return nil, errors.New("nullifier has invalid length")
+	}
+	height, txid, err := n.get(nullifierKey(nf.Protocol, nf.Nf))
+	if err != nil {
+		return nil, err
+	}
+	status := &walletrpc.NullifierStatus{Nf: nf.Nf}
+	if height >= 0 {
+		status.Spent = true
+		status.Height = uint64(height)
+		status.Txid = txid
+	}
+	return status, nil
+}
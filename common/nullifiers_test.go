@@ -0,0 +1,166 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+func TestNullifierIndex(t *testing.T) {
+	const start = 1000
+	// Block i has one transaction, which spends the notes with nullifiers
+	// nf(i, 0) and nf(i, 1).
+	nf := func(height, i int) []byte {
+		return bytes.Repeat([]byte{byte(height - start), byte(i)}, 16)
+	}
+	txid := func(height int) []byte {
+		return bytes.Repeat([]byte{0xff, byte(height - start)}, 16)
+	}
+	var compactBlocks []*walletrpc.CompactBlock
+	for i := 0; i < 4; i++ {
+		height := start + i
+		block := &walletrpc.CompactBlock{
+			Height: uint64(height),
+			Hash:   bytes.Repeat([]byte{byte(i + 1)}, 32),
+			Vtx: []*walletrpc.CompactTx{{
+				Hash: txid(height),
+				Spends: []*walletrpc.CompactSpend{
+					{Nf: nf(height, 0)},
+					{Nf: nf(height, 1)},
+				},
+			}},
+		}
+		if i > 0 {
+			block.PrevHash = compactBlocks[i-1].Hash
+		}
+		compactBlocks = append(compactBlocks, block)
+	}
+	status := func(cache *BlockCache, nf []byte) *walletrpc.NullifierStatus {
+		s, err := cache.GetNullifierStatus(&walletrpc.Nullifier{Nf: nf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, start, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCacheFromStore(store, start)
+	if _, err := cache.GetNullifierStatus(&walletrpc.Nullifier{Nf: nf(start, 0)}); err != ErrNoNullifierIndex {
+		t.Fatal("unexpected error without the index: ", err)
+	}
+
+	// The nullifiers of the blocks cached before the index is set are
+	// added to it then.
+	for i, block := range compactBlocks[:2] {
+		if err := cache.Add(start+i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nfIndex, err := OpenNullifierIndex(unitTestPath, unitTestChain, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetNullifierIndex(nfIndex); err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range compactBlocks[2:] {
+		if err := cache.Add(start+2+i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for height := start; height < start+4; height++ {
+		for i := 0; i < 2; i++ {
+			s := status(cache, nf(height, i))
+			if !s.Spent || s.Height != uint64(height) ||
+				!bytes.Equal(s.Txid, txid(height)) || !bytes.Equal(s.Nf, nf(height, i)) {
+				t.Fatal("unexpected status at height ", height, ": ", s)
+			}
+		}
+	}
+	if s := status(cache, nf(start+4, 0)); s.Spent || s.Height != 0 || s.Txid != nil {
+		t.Fatal("unexpected status of an unspent nullifier: ", s)
+	}
+
+	// Only Sapling nullifiers (of the right length) are indexed.
+	if _, err := cache.GetNullifierStatus(&walletrpc.Nullifier{
+		Protocol: walletrpc.ShieldedProtocol_orchard,
+		Nf:       nf(start, 0),
+	}); err == nil {
+		t.Fatal("orchard nullifier unexpected success")
+	}
+	if _, err := cache.GetNullifierStatus(&walletrpc.Nullifier{Nf: nf(start, 0)[:31]}); err == nil {
+		t.Fatal("short nullifier unexpected success")
+	}
+
+	// A reorg makes the nullifiers of the removed blocks unspent.
+	cache.Reorg(start + 2)
+	if s := status(cache, nf(start+2, 0)); s.Spent {
+		t.Fatal("nullifier of a removed block still spent")
+	}
+	if s := status(cache, nf(start+1, 1)); !s.Spent {
+		t.Fatal("nullifier of a kept block not spent")
+	}
+
+	// Nullifiers of blocks that aren't in the cache (here, because it lost
+	// its last block) are removed from the index when it's reopened.
+	if err := cache.Add(start+2, compactBlocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Truncate(start + 2)
+	cache = NewBlockCacheFromStore(store, start)
+	if nfIndex, err = OpenNullifierIndex(unitTestPath, unitTestChain, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetNullifierIndex(nfIndex); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(cache, nf(start+1, 0)); !s.Spent {
+		t.Fatal("nullifier of a cached block not spent after reopening")
+	}
+	if s := status(cache, nf(start+2, 0)); s.Spent {
+		t.Fatal("nullifier of a block not in the cache spent after reopening")
+	}
+	cache.Close()
+
+	// Once blocks are pruned, a nullifier that isn't spent in the cached
+	// blocks may be spent in a pruned one, so its status is unknown.
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Prune(start + 1); err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start)
+	if nfIndex, err = OpenNullifierIndex(unitTestPath, unitTestChain, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetNullifierIndex(nfIndex); err != nil {
+		t.Fatal(err)
+	}
+	if cache.GetFirstHeight() != start+1 {
+		t.Fatal("unexpected first height after pruning ", cache.GetFirstHeight())
+	}
+	if _, err := cache.GetNullifierStatus(&walletrpc.Nullifier{Nf: nf(start, 0)}); err != ErrNullifierUnknown {
+		t.Fatal("unexpected status of a nullifier spent in a pruned block: ", err)
+	}
+	if s := status(cache, nf(start+1, 0)); !s.Spent || s.Height != start+1 {
+		t.Fatal("unexpected status of a nullifier spent in a cached block after pruning: ", s)
+	}
+	cache.Close()
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package sapling
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// An fe is an element of the field Jubjub is over, the integers modulo q
+// (the BLS12-381 scalar field), in Montgomery form (times 2^256 mod q), as
+// little-endian 64-bit limbs. math/big is much too slow for hashing the
+// commitment tree, so it's only used for the rare inversions and square
+// roots.
+type fe [4]uint64
+
+var (
+	q      = fromHex("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001")
+	qLimbs = limbs(q)
+	// -1/q mod 2^64
+	qInv = new(big.Int).Sub(twoTo(64), new(big.Int).ModInverse(q, twoTo(64))).Uint64()
+	// 2^512 mod q, to convert to Montgomery form
+	r2     = limbs(new(big.Int).Mod(twoTo(512), q))
+	feZero = fe{}
+	feOne  = feFromBig(big.NewInt(1))
+)
+
+func fromHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("bad hex constant " + s)
+	}
+	return n
+}
+
+func twoTo(n uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), n)
+}
+
+// limbs returns n (which is less than 2^256) as little-endian limbs.
+func limbs(n *big.Int) fe {
+	var b [32]byte
+	nb := n.Bytes()
+	copy(b[32-len(nb):], nb)
+	var z fe
+	for i := range z {
+		for j := 0; j < 8; j++ {
+			z[i] |= uint64(b[31-8*i-j]) << uint(8*j)
+		}
+	}
+	return z
+}
+
+func (z fe) big() *big.Int {
+	n := new(big.Int)
+	for i := 3; i >= 0; i-- {
+		n.Lsh(n, 64)
+		n.Or(n, new(big.Int).SetUint64(z[i]))
+	}
+	return n
+}
+
+// feFromBig returns n (which is less than q) as a field element.
+func feFromBig(n *big.Int) fe {
+	z := limbs(n)
+	return feMul(z, r2)
+}
+
+// toBig returns the field element as an integer less than q.
+func (z fe) toBig() *big.Int {
+	return feMul(z, fe{1}).big()
+}
+
+// feFromBytes returns the field element with the given little-endian
+// encoding, or false if it isn't canonical.
+func feFromBytes(b []byte) (fe, bool) {
+	n := new(big.Int).SetBytes(reverse(b))
+	if n.Cmp(q) >= 0 {
+		return feZero, false
+	}
+	return feFromBig(n), true
+}
+
+// bytes returns the little-endian encoding of the field element.
+func (z fe) bytes() []byte {
+	var b [32]byte
+	nb := z.toBig().Bytes()
+	copy(b[32-len(nb):], nb)
+	return reverse(b[:])
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+// reduce subtracts q from z (less than 2q) if it's at least q.
+func reduce(z fe) fe {
+	var r fe
+	var borrow uint64
+	for i := range z {
+		r[i], borrow = bits.Sub64(z[i], qLimbs[i], borrow)
+	}
+	if borrow != 0 {
+		return z
+	}
+	return r
+}
+
+func feAdd(x, y fe) fe {
+	// q < 2^255, so this can't overflow.
+	var z fe
+	var carry uint64
+	for i := range z {
+		z[i], carry = bits.Add64(x[i], y[i], carry)
+	}
+	return reduce(z)
+}
+
+func feSub(x, y fe) fe {
+	var z fe
+	var borrow uint64
+	for i := range z {
+		z[i], borrow = bits.Sub64(x[i], y[i], borrow)
+	}
+	if borrow != 0 {
+		var carry uint64
+		for i := range z {
+			z[i], carry = bits.Add64(z[i], qLimbs[i], carry)
+		}
+	}
+	return z
+}
+
+func feNeg(x fe) fe {
+	return feSub(feZero, x)
+}
+
+// feMul returns x*y/2^256 mod q (which is the product, in Montgomery
+// form), by word-by-word Montgomery reduction. The carries out of the top
+// word can be skipped because q's top word is less than 2^63 - 1.
+func feMul(x, y fe) fe {
+	var t0, t1, t2, t3 uint64
+	{
+		var c0, c1, c2 uint64
+		v := x[0]
+		c1, c0 = bits.Mul64(v, y[0])
+		m := c0 * qInv
+		c2 = madd0(m, qLimbs[0], c0)
+		c1, c0 = madd1(v, y[1], c1)
+		c2, t0 = madd2(m, qLimbs[1], c2, c0)
+		c1, c0 = madd1(v, y[2], c1)
+		c2, t1 = madd2(m, qLimbs[2], c2, c0)
+		c1, c0 = madd1(v, y[3], c1)
+		t3, t2 = madd3(m, qLimbs[3], c0, c2, c1)
+	}
+	for i := 1; i < 4; i++ {
+		var c0, c1, c2 uint64
+		v := x[i]
+		c1, c0 = madd1(v, y[0], t0)
+		m := c0 * qInv
+		c2 = madd0(m, qLimbs[0], c0)
+		c1, c0 = madd2(v, y[1], c1, t1)
+		c2, t0 = madd2(m, qLimbs[1], c2, c0)
+		c1, c0 = madd2(v, y[2], c1, t2)
+		c2, t1 = madd2(m, qLimbs[2], c2, c0)
+		c1, c0 = madd2(v, y[3], c1, t3)
+		t3, t2 = madd3(m, qLimbs[3], c0, c2, c1)
+	}
+	return reduce(fe{t0, t1, t2, t3})
+}
+
+// madd0 returns the high word of a*b + c.
+func madd0(a, b, c uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	_, carry := bits.Add64(lo, c, 0)
+	return hi + carry
+}
+
+// madd1 returns a*b + c.
+func madd1(a, b, c uint64) (uint64, uint64) {
+	hi, lo := bits.Mul64(a, b)
+	lo, carry := bits.Add64(lo, c, 0)
+	return hi + carry, lo
+}
+
+// madd2 returns a*b + c + d.
+func madd2(a, b, c, d uint64) (uint64, uint64) {
+	hi, lo := bits.Mul64(a, b)
+	c, carry := bits.Add64(c, d, 0)
+	hi += carry
+	lo, carry = bits.Add64(lo, c, 0)
+	return hi + carry, lo
+}
+
+// madd3 returns a*b + c + d + e*2^64.
+func madd3(a, b, c, d, e uint64) (uint64, uint64) {
+	hi, lo := bits.Mul64(a, b)
+	c, carry := bits.Add64(c, d, 0)
+	hi += carry
+	lo, carry = bits.Add64(lo, c, 0)
+	return hi + e + carry, lo
+}
+
+func feInv(x fe) fe {
+	return feFromBig(new(big.Int).ModInverse(x.toBig(), q))
+}
+
+// feSqrt returns a square root of x, or false if it has none.
+func feSqrt(x fe) (fe, bool) {
+	r := new(big.Int).ModSqrt(x.toBig(), q)
+	if r == nil {
+		return feZero, false
+	}
+	return feFromBig(r), true
+}
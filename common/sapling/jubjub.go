@@ -0,0 +1,111 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package sapling
+
+import "math/big"
+
+// The Jubjub curve: -u^2 + v^2 = 1 + d*u^2*v^2 over the field of integers
+// modulo q, where d = -(10240/10241). See section 5.4.9.3 of the Zcash
+// protocol specification.
+var (
+	jubjubD = feNeg(feMul(feFromBig(big.NewInt(10240)),
+		feInv(feFromBig(big.NewInt(10241)))))
+	jubjubD2 = feAdd(jubjubD, jubjubD)
+)
+
+// A point is a Jubjub point in extended coordinates: u = x/z, v = y/z and
+// t = u*v*z.
+type point struct {
+	x, y, z, t fe
+}
+
+func identity() *point {
+	return &point{feZero, feOne, feOne, feZero}
+}
+
+// add returns p + r, using the complete addition formula for twisted
+// Edwards curves with a = -1 ("add-2008-hwcd-3").
+func (p *point) add(r *point) *point {
+	a := feMul(feSub(p.y, p.x), feSub(r.y, r.x))
+	b := feMul(feAdd(p.y, p.x), feAdd(r.y, r.x))
+	c := feMul(feMul(p.t, jubjubD2), r.t)
+	d := feMul(feAdd(p.z, p.z), r.z)
+	return combine(a, b, c, d)
+}
+
+// addNiels returns p + r, given r in the form niels returns.
+func (p *point) addNiels(r *nielsPoint) *point {
+	a := feMul(feSub(p.y, p.x), r.vMinusU)
+	b := feMul(feAdd(p.y, p.x), r.vPlusU)
+	c := feMul(p.t, r.t2d)
+	d := feAdd(p.z, p.z)
+	return combine(a, b, c, d)
+}
+
+func combine(a, b, c, d fe) *point {
+	e := feSub(b, a)
+	f := feSub(d, c)
+	g := feAdd(d, c)
+	h := feAdd(b, a)
+	return &point{feMul(e, f), feMul(g, h), feMul(f, g), feMul(e, h)}
+}
+
+func (p *point) double() *point {
+	return p.add(p)
+}
+
+func (p *point) isIdentity() bool {
+	return p.x == feZero && p.y == p.z
+}
+
+// affine returns the point's u and v.
+func (p *point) affine() (fe, fe) {
+	zInv := feInv(p.z)
+	return feMul(p.x, zInv), feMul(p.y, zInv)
+}
+
+// A nielsPoint is a point (with z = 1) in the form that's cheapest to add:
+// v - u, v + u and 2*d*u*v; negating it swaps the first two and negates
+// the last.
+type nielsPoint struct {
+	vMinusU, vPlusU, t2d fe
+}
+
+func (p *point) niels() *nielsPoint {
+	u, v := p.affine()
+	return &nielsPoint{feSub(v, u), feAdd(v, u), feMul(feMul(u, v), jubjubD2)}
+}
+
+func (p *nielsPoint) neg() *nielsPoint {
+	return &nielsPoint{p.vPlusU, p.vMinusU, feNeg(p.t2d)}
+}
+
+// decodePoint returns the point with the given encoding (its v, with the
+// sign of u in the top bit), or nil if there isn't one; see abst_J in
+// section 5.4.9.3 of the protocol specification.
+func decodePoint(b []byte) *point {
+	vBytes := append([]byte{}, b...)
+	sign := vBytes[31] >> 7
+	vBytes[31] &= 0x7f
+	v, ok := feFromBytes(vBytes)
+	if !ok {
+		return nil
+	}
+	// u^2 = (v^2 - 1) / (d*v^2 + 1), where the denominator can't be zero
+	// because d isn't a square.
+	v2 := feMul(v, v)
+	u, ok := feSqrt(feMul(feSub(v2, feOne), feInv(feAdd(feMul(jubjubD, v2), feOne))))
+	if !ok {
+		return nil
+	}
+	uBytes := u.bytes()
+	if u == feZero && sign == 1 {
+		return nil
+	}
+	if uBytes[0]&1 != sign {
+		u = feNeg(u)
+	}
+	return &point{u, v, feOne, feMul(u, v)}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package sapling
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// The Pedersen hash (section 5.4.1.7 of the protocol specification) splits
+// its input into 3-bit chunks, each of which selects a multiple (-4..4,
+// not 0) of its own power of 2^4 of a generator, and sums them; a generator
+// covers 63 chunks. Only the Merkle hash (MerkleCRH) is needed here, whose
+// input is 6 + 2*255 bits, so 3 generators.
+const (
+	chunksPerGenerator = 63
+	merkleHashBits     = 6 + 2*255
+	merkleGenerators   = (merkleHashBits/3 + chunksPerGenerator - 1) / chunksPerGenerator
+)
+
+// The generators are found by the group hash (section 5.4.9.5) with this
+// personalization; its BLAKE2s input begins with the URS.
+const (
+	pedersenPersonalization = "Zcash_PH"
+	groupHashURS            = "096b36a5804bfacef1691e173c366a47ff5ba84a44f26ddd7e8d9f79d5b42df0"
+)
+
+var (
+	// pedersenTable[j][k][e] is (e+1) * 2^(4k) times the j'th generator.
+	pedersenTable     [merkleGenerators][chunksPerGenerator][4]*nielsPoint
+	pedersenTableOnce sync.Once
+)
+
+// groupHash returns the Jubjub point for the tag, or nil if there isn't
+// one.
+func groupHash(tag []byte) *point {
+	h := blake2s256(pedersenPersonalization, append([]byte(groupHashURS), tag...))
+	p := decodePoint(h[:])
+	if p == nil {
+		return nil
+	}
+	// Multiply by the cofactor, 8, to get a point in the prime-order subgroup.
+	p = p.double().double().double()
+	if p.isIdentity() {
+		return nil
+	}
+	return p
+}
+
+// findGroupHash returns the first point found by the group hash for the
+// message followed by a counter byte.
+func findGroupHash(m []byte) *point {
+	tag := append(append([]byte{}, m...), 0)
+	for i := 0; i < 256; i++ {
+		tag[len(m)] = byte(i)
+		if p := groupHash(tag); p != nil {
+			return p
+		}
+	}
+	panic("no group hash found")
+}
+
+func makePedersenTable() {
+	for j := range pedersenTable {
+		var m [4]byte
+		binary.LittleEndian.PutUint32(m[:], uint32(j))
+		g := findGroupHash(m[:])
+		for k := range pedersenTable[j] {
+			p := g
+			for e := range pedersenTable[j][k] {
+				pedersenTable[j][k][e] = p.niels()
+				p = p.add(g)
+			}
+			g = g.double().double().double().double()
+		}
+	}
+}
+
+// pedersenHash returns the Pedersen hash (the u-coordinate of the point,
+// little-endian) of the bits.
+func pedersenHash(bits []bool) []byte {
+	pedersenTableOnce.Do(makePedersenTable)
+	bit := func(i int) bool {
+		return i < len(bits) && bits[i]
+	}
+	acc := identity()
+	for i := 0; i < len(bits); i += 3 {
+		e := 0
+		if bit(i) {
+			e++
+		}
+		if bit(i + 1) {
+			e += 2
+		}
+		chunk := i / 3
+		p := pedersenTable[chunk/chunksPerGenerator][chunk%chunksPerGenerator][e]
+		if bit(i + 2) {
+			p = p.neg()
+		}
+		acc = acc.addNiels(p)
+	}
+	u, _ := acc.affine()
+	return u.bytes()
+}
+
+// merkleHash returns MerkleCRH^Sapling (section 5.4.1.3) of the two nodes
+// (little-endian) at the given depth, counting up from the leaves at 0.
+func merkleHash(depth int, left, right []byte) []byte {
+	bits := make([]bool, 0, merkleHashBits)
+	for i := 0; i < 6; i++ {
+		bits = append(bits, depth>>uint(i)&1 == 1)
+	}
+	for _, node := range [][]byte{left, right} {
+		for i := 0; i < 255; i++ {
+			bits = append(bits, node[i/8]>>uint(i%8)&1 == 1)
+		}
+	}
+	return pedersenHash(bits)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package sapling
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestEmptyRoots(t *testing.T) {
+	// The roots of empty subtrees, as in the Sapling test vectors, and of
+	// the empty tree (hashFinalSaplingRoot before any Sapling outputs).
+	for depth, root := range map[int]string{
+		0:  "0100000000000000000000000000000000000000000000000000000000000000",
+		1:  "817de36ab2d57feb077634bca77819c8e0bd298c04f6fed0e6a83cc1356ca155",
+		2:  "ffe9fc03f18b176c998806439ff0bb8ad193afdb27b2ccbc88856916dd804e34",
+		32: "fbc2f4300c01f0b7820d00e3347c8da4ee614674376cbc45359daa54f9b5493e",
+	} {
+		if got := hex.EncodeToString(emptyRoot(depth)); got != root {
+			t.Fatal("empty root at depth ", depth, ": ", got, " expected ", root)
+		}
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() *big.Int {
+		return new(big.Int).Rand(rng, q)
+	}
+	qMinus1 := new(big.Int).Sub(q, big.NewInt(1))
+	for i := 0; i < 1000; i++ {
+		a, b := random(), random()
+		if i == 0 {
+			a, b = qMinus1, qMinus1
+		}
+		x, y := feFromBig(a), feFromBig(b)
+		if x.toBig().Cmp(a) != 0 {
+			t.Fatal("round trip of ", a)
+		}
+		for _, c := range []struct {
+			name     string
+			got      fe
+			expected *big.Int
+		}{
+			{"add", feAdd(x, y), new(big.Int).Add(a, b)},
+			{"sub", feSub(x, y), new(big.Int).Sub(a, b)},
+			{"mul", feMul(x, y), new(big.Int).Mul(a, b)},
+		} {
+			if c.got.toBig().Cmp(c.expected.Mod(c.expected, q)) != 0 {
+				t.Fatal(c.name, " of ", a, " and ", b, " unexpected result ", c.got.toBig())
+			}
+		}
+	}
+}
+
+// naiveRoot returns the root of the tree of the given depth with the given
+// leaves, computed level by level.
+func naiveRoot(leaves [][]byte, depth int) []byte {
+	nodes := leaves
+	for d := 0; d < depth; d++ {
+		var parents [][]byte
+		for i := 0; i < len(nodes); i += 2 {
+			right := emptyRoot(d)
+			if i+1 < len(nodes) {
+				right = nodes[i+1]
+			}
+			parents = append(parents, merkleHash(d, nodes[i], right))
+		}
+		nodes = parents
+	}
+	if len(nodes) == 0 {
+		return emptyRoot(depth)
+	}
+	return nodes[0]
+}
+
+func TestTree(t *testing.T) {
+	tree := NewTree()
+	if hex.EncodeToString(tree.Bytes()) != "000000" {
+		t.Fatal("unexpected empty tree serialization ", tree.Bytes())
+	}
+	var leaves [][]byte
+	for i := 0; i < 17; i++ {
+		if !bytes.Equal(tree.Root(), naiveRoot(leaves, TreeDepth)) {
+			t.Fatal("unexpected root with ", i, " leaves")
+		}
+		if tree.Size() != uint64(i) {
+			t.Fatal("unexpected size ", tree.Size(), " with ", i, " leaves")
+		}
+		for depth := 0; depth < 4; depth++ {
+			root := tree.SubtreeRoot(depth)
+			n := 1 << uint(depth)
+			if i == 0 || i%n != 0 {
+				if root != nil {
+					t.Fatal("unexpected subtree root of depth ", depth, " with ", i, " leaves")
+				}
+			} else if !bytes.Equal(root, naiveRoot(leaves[i-n:], depth)) {
+				t.Fatal("wrong subtree root of depth ", depth, " with ", i, " leaves")
+			}
+		}
+		parsed, err := ParseTree(tree.Bytes())
+		if err != nil || !bytes.Equal(parsed.Bytes(), tree.Bytes()) {
+			t.Fatal("serialization round trip failed with ", i, " leaves: ", err)
+		}
+		// Any canonical field element will do as a leaf.
+		leaf := make([]byte, 32)
+		leaf[0], leaf[1] = byte(i), 0xaa
+		leaves = append(leaves, leaf)
+		if err := tree.Append(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Copies are independent.
+	c := tree.Copy()
+	c.Append(leaves[0])
+	if bytes.Equal(c.Root(), tree.Root()) || !bytes.Equal(tree.Root(), naiveRoot(leaves, TreeDepth)) {
+		t.Fatal("appending to a copy changed the tree")
+	}
+
+	if err := tree.Append(make([]byte, 31)); err == nil {
+		t.Fatal("short note commitment unexpected success")
+	}
+	for _, bad := range []string{"", "00", "0000", "000020", "02000000", "01aa", "000001", "00000000"} {
+		b, _ := hex.DecodeString(bad)
+		if _, err := ParseTree(b); err == nil {
+			t.Fatal("parsing bad tree ", bad, " unexpected success")
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+// Package sapling maintains the Sapling note commitment tree (section 3.8
+// of the Zcash protocol specification), so lightwalletd can compute tree
+// states itself rather than asking zcashd.
+package sapling
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// TreeDepth is the depth of the Sapling note commitment tree.
+	TreeDepth = 32
+
+	// SubtreeDepth is the depth of the subtrees whose roots wallets can
+	// sync the tree by (as zcashd's z_getsubtreesbyindex returns them).
+	SubtreeDepth = 16
+)
+
+var (
+	// emptyRoots[d] is the root of an empty subtree of depth d.
+	emptyRoots     [TreeDepth + 1][]byte
+	emptyRootsOnce sync.Once
+)
+
+func emptyRoot(depth int) []byte {
+	emptyRootsOnce.Do(func() {
+		// Uncommitted^Sapling, the leaf with no note commitment
+		emptyRoots[0] = make([]byte, 32)
+		emptyRoots[0][0] = 1
+		for d := 0; d < TreeDepth; d++ {
+			emptyRoots[d+1] = merkleHash(d, emptyRoots[d], emptyRoots[d])
+		}
+	})
+	return emptyRoots[depth]
+}
+
+// Tree is the frontier of a note commitment tree: the nodes needed to
+// append to it and compute its root, as zcashd's IncrementalMerkleTree
+// keeps them. Left and right are the last (pair of) leaves, and parents[d]
+// is the left child, if it's complete, of the subtree of depth d+1 that
+// right (or left, if right is nil) is the last leaf of.
+type Tree struct {
+	left, right []byte
+	parents     [][]byte
+}
+
+// NewTree returns an empty tree.
+func NewTree() *Tree {
+	return &Tree{}
+}
+
+// Append adds the note commitment (its u-coordinate, cmu, little-endian)
+// to the tree.
+func (t *Tree) Append(cmu []byte) error {
+	if len(cmu) != 32 {
+		return errors.New("note commitment has invalid length")
+	}
+	cmu = append([]byte{}, cmu...)
+	switch {
+	case t.left == nil:
+		t.left = cmu
+	case t.right == nil:
+		t.right = cmu
+	default:
+		if t.isFull() {
+			return errors.New("note commitment tree is full")
+		}
+		node := merkleHash(0, t.left, t.right)
+		t.left, t.right = cmu, nil
+		for d := range t.parents {
+			if t.parents[d] == nil {
+				t.parents[d] = node
+				return nil
+			}
+			node = merkleHash(d+1, t.parents[d], node)
+			t.parents[d] = nil
+		}
+		t.parents = append(t.parents, node)
+	}
+	return nil
+}
+
+func (t *Tree) isFull() bool {
+	if t.right == nil || len(t.parents) < TreeDepth-1 {
+		return false
+	}
+	for _, parent := range t.parents {
+		if parent == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Root returns the tree's root (little-endian), as in a block header's
+// hashFinalSaplingRoot.
+func (t *Tree) Root() []byte {
+	left, right := t.left, t.right
+	if left == nil {
+		left = emptyRoot(0)
+	}
+	if right == nil {
+		right = emptyRoot(0)
+	}
+	root := merkleHash(0, left, right)
+	d := 1
+	for _, parent := range t.parents {
+		if parent != nil {
+			root = merkleHash(d, parent, root)
+		} else {
+			root = merkleHash(d, root, emptyRoot(d))
+		}
+		d++
+	}
+	for ; d < TreeDepth; d++ {
+		root = merkleHash(d, root, emptyRoot(d))
+	}
+	return root
+}
+
+// Size returns the number of leaves (note commitments) in the tree.
+func (t *Tree) Size() uint64 {
+	var size uint64
+	if t.left != nil {
+		size++
+	}
+	if t.right != nil {
+		size++
+	}
+	for d, parent := range t.parents {
+		if parent != nil {
+			size += 1 << uint(d+1)
+		}
+	}
+	return size
+}
+
+// SubtreeRoot returns the root of the subtree of the given depth that the
+// last leaf completes, or nil if it doesn't complete one.
+func (t *Tree) SubtreeRoot(depth int) []byte {
+	size := t.Size()
+	if size == 0 || size%(1<<uint(depth)) != 0 {
+		return nil
+	}
+	if depth == 0 {
+		if t.right != nil {
+			return t.right
+		}
+		return t.left
+	}
+	// The subtree is complete, so all of these nodes are there.
+	root := merkleHash(0, t.left, t.right)
+	for d := 1; d < depth; d++ {
+		root = merkleHash(d, t.parents[d-1], root)
+	}
+	return root
+}
+
+// Copy returns a copy of the tree, which can be appended to independently.
+func (t *Tree) Copy() *Tree {
+	c := *t
+	c.parents = append([][]byte{}, t.parents...)
+	return &c
+}
+
+// Bytes returns the tree serialized as zcashd does (and returns it, hex
+// encoded, as the finalState of z_gettreestate): left, right and the
+// number of parents then each one, every node preceded by 1 if it's there
+// or just 0 if it isn't.
+func (t *Tree) Bytes() []byte {
+	var buf bytes.Buffer
+	writeNode := func(node []byte) {
+		if node == nil {
+			buf.WriteByte(0)
+			return
+		}
+		buf.WriteByte(1)
+		buf.Write(node)
+	}
+	writeNode(t.left)
+	writeNode(t.right)
+	// (The number of parents is a CompactSize, which is one byte when
+	// it's less than 253.)
+	buf.WriteByte(byte(len(t.parents)))
+	for _, parent := range t.parents {
+		writeNode(parent)
+	}
+	return buf.Bytes()
+}
+
+// ParseTree returns the tree serialized as by Bytes.
+func ParseTree(data []byte) (*Tree, error) {
+	readNode := func() ([]byte, error) {
+		if len(data) == 0 || data[0] > 1 || (data[0] == 1 && len(data) < 33) {
+			return nil, errors.New("could not read tree node")
+		}
+		if data[0] == 0 {
+			data = data[1:]
+			return nil, nil
+		}
+		node := append([]byte{}, data[1:33]...)
+		data = data[33:]
+		return node, nil
+	}
+	t := &Tree{}
+	var err error
+	if t.left, err = readNode(); err != nil {
+		return nil, err
+	}
+	if t.right, err = readNode(); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || data[0] >= TreeDepth {
+		return nil, errors.New("could not read tree parents")
+	}
+	t.parents = make([][]byte, data[0])
+	data = data[1:]
+	for i := range t.parents {
+		if t.parents[i], err = readNode(); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > 0 || (t.left == nil && (t.right != nil || len(t.parents) > 0)) {
+		return nil, errors.New("invalid tree")
+	}
+	return t, nil
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/common/sapling"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// SaplingTreeIndex keeps checkpoints of the Sapling note commitment tree
+// as of the cached blocks: the tree as of a block is computed from the
+// latest checkpoint at or below it. It also keeps the roots of the tree's
+// complete subtrees (see sapling.SubtreeDepth), by index, with the hashes
+// of the blocks that completed them, including those before the cache's
+// first block. The BlockCache keeps it in step with its blocks (see
+// SetSaplingTreeIndex).
+type SaplingTreeIndex struct {
+	*blockIndex
+	subtrees *blockIndex
+}
+
+var (
+	// The tree is checkpointed after the cache's first block, every this
+	// many blocks, and after any block that leaves at least this many
+	// commitments since the last checkpoint, so that computing the tree
+	// from its checkpoint doesn't take long.
+	saplingTreeCheckpointBlocks      = 100
+	saplingTreeCheckpointCommitments = 1000
+
+	// The depth of the subtrees whose roots the index keeps (a variable
+	// so that tests can complete subtrees with few commitments).
+	saplingSubtreeDepth = sapling.SubtreeDepth
+)
+
+// OpenSaplingTreeIndex opens (or creates) the chain's Sapling tree index
+// in dbPath, emptying it if redownload is set.
+func OpenSaplingTreeIndex(dbPath, chainName string, redownload bool) (*SaplingTreeIndex, error) {
+	index, err := openBlockIndex(dbPath, chainName, "saplingtree.db", 8, redownload)
+	if err != nil {
+		return nil, err
+	}
+	subtrees, err := openBlockIndex(dbPath, chainName, "saplingsubtrees.db", 8, redownload)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	subtrees.keep = true
+	return &SaplingTreeIndex{index, subtrees}, nil
+}
+
+// subtreeEntry returns the subtrees index entry for the subtree root.
+func subtreeEntry(index uint32, root *walletrpc.SubtreeRoot) indexEntry {
+	return indexEntry{
+		key:   boltHeightKey(int(index)),
+		value: append(append([]byte{}, root.RootHash...), root.CompletingBlockHash...),
+	}
+}
+
+// getSubtreeRoots returns the roots of the complete subtrees from the
+// given index, as many as there are in a row, at most max (or all if it's
+// zero).
+func (x *SaplingTreeIndex) getSubtreeRoots(start, max uint32) ([]*walletrpc.SubtreeRoot, error) {
+	var roots []*walletrpc.SubtreeRoot
+	next := start
+	err := x.subtrees.scan(boltHeightKey(int(start)), func(key []byte, height int, value []byte) bool {
+		if !bytes.Equal(key, boltHeightKey(int(next))) || len(value) != 64 {
+			return false
+		}
+		value = append([]byte{}, value...)
+		roots = append(roots, &walletrpc.SubtreeRoot{
+			RootHash:              value[:32],
+			CompletingBlockHash:   value[32:],
+			CompletingBlockHeight: uint64(height),
+		})
+		next++
+		return max == 0 || next-start < max
+	})
+	return roots, err
+}
+
+// fillSubtreeRoots adds zcashd's roots of the first count subtrees that
+// the index doesn't have.
+func (x *SaplingTreeIndex) fillSubtreeRoots(count uint32) error {
+	roots, err := x.getSubtreeRoots(0, count)
+	if err != nil {
+		return err
+	}
+	start := uint32(len(roots))
+	if start >= count {
+		return nil
+	}
+	roots, err = getSubtreeRootsFromRPC("sapling", start, count-start, getBlockHashFromRPC)
+	if err != nil {
+		return err
+	}
+	for i, root := range roots {
+		err := x.subtrees.add(int(root.CompletingBlockHeight), []indexEntry{subtreeEntry(start+uint32(i), root)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addCheckpoint records the tree as of the block at the given height.
+func (x *SaplingTreeIndex) addCheckpoint(height int, tree *sapling.Tree) error {
+	return x.add(height, []indexEntry{{key: boltHeightKey(height), value: tree.Bytes()}})
+}
+
+// checkpoint returns the latest checkpoint at or below the given height
+// and its height, or nil if there isn't one.
+func (x *SaplingTreeIndex) checkpoint(height int) (int, *sapling.Tree, error) {
+	h, treeBytes, err := x.floor(boltHeightKey(height))
+	if h < 0 || err != nil {
+		return -1, nil, err
+	}
+	tree, err := sapling.ParseTree(treeBytes)
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "bad tree checkpoint at height "+strconv.Itoa(h))
+	}
+	return h, tree, nil
+}
+
+// appendCommitments appends the note commitments of the block's outputs to
+// the tree, returning how many there were and the subtrees index entries
+// for the subtrees they completed.
+func appendCommitments(tree *sapling.Tree, block *walletrpc.CompactBlock) (int, []indexEntry, error) {
+	n := 0
+	var subtrees []indexEntry
+	for _, tx := range block.Vtx {
+		for _, output := range tx.Outputs {
+			if err := tree.Append(output.Cmu); err != nil {
+				return n, nil, err
+			}
+			n++
+			if root := tree.SubtreeRoot(saplingSubtreeDepth); root != nil {
+				index := uint32(tree.Size()>>uint(saplingSubtreeDepth)) - 1
+				subtrees = append(subtrees, subtreeEntry(index, &walletrpc.SubtreeRoot{
+					RootHash:            root,
+					CompletingBlockHash: block.Hash,
+				}))
+			}
+		}
+	}
+	return n, subtrees, nil
+}
+
+// getSubtreeRootsFromRPC returns zcashd's roots of the complete subtrees of
+// the pool's note commitment tree from the given index, at most max (or all
+// if it's zero), with the hashes of the blocks that completed them from
+// the given function.
+func getSubtreeRootsFromRPC(pool string, start, max uint32, blockHash func(height int) ([]byte, error)) ([]*walletrpc.SubtreeRoot, error) {
+	reply, err := Chain.GetSubtreesByIndex(pool, start, max)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]*walletrpc.SubtreeRoot, len(reply.Subtrees))
+	for i, subtree := range reply.Subtrees {
+		rootHash, err := hex.DecodeString(subtree.Root)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := blockHash(subtree.EndHeight)
+		if err != nil {
+			return nil, err
+		}
+		roots[i] = &walletrpc.SubtreeRoot{
+			RootHash:              rootHash,
+			CompletingBlockHash:   hash,
+			CompletingBlockHeight: uint64(subtree.EndHeight),
+		}
+	}
+	return roots, nil
+}
+
+// getBlockHashFromRPC returns the (little-endian) hash of the block at the
+// given height, from zcashd.
+func getBlockHashFromRPC(height int) ([]byte, error) {
+	blockData, err := Chain.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	if blockData == nil {
+		return nil, errors.Errorf("block %d not found", height)
+	}
+	hdr := parser.NewBlockHeader()
+	if _, err := hdr.ParseFromSlice(blockData); err != nil {
+		return nil, errors.Wrap(err, "parsing block header")
+	}
+	return hdr.GetEncodableHash(), nil
+}
+
+// GetSubtreeRoots returns the roots of the complete subtrees of the given
+// protocol's note commitment tree from the given index, at most max (or
+// all if it's zero), with the blocks that completed them. Sapling roots
+// come from the cache if it keeps the tree (see SetSaplingTreeIndex),
+// otherwise (and for Orchard) from zcashd.
+func GetSubtreeRoots(cache *BlockCache, protocol walletrpc.ShieldedProtocol, start, max uint32) ([]*walletrpc.SubtreeRoot, error) {
+	if protocol == walletrpc.ShieldedProtocol_sapling {
+		if roots := cache.GetSubtreeRoots(start, max); roots != nil {
+			return roots, nil
+		}
+	}
+	if ZcashdUnavailable() {
+		return nil, ErrZcashdUnavailable
+	}
+	return getSubtreeRootsFromRPC(protocol.String(), start, max, func(height int) ([]byte, error) {
+		if block := cache.Get(height); block != nil {
+			return block.Hash, nil
+		}
+		return getBlockHashFromRPC(height)
+	})
+}
+
+// getSaplingTreeFromRPC returns the Sapling tree as of the block at the
+// given height, from zcashd.
+func getSaplingTreeFromRPC(height int) (*sapling.Tree, error) {
+	reply, err := Chain.GetTreeState(strconv.Itoa(height))
+	if err != nil {
+		return nil, err
+	}
+	if reply.Sapling.Commitments.FinalState == "" {
+		return nil, errors.New("zcashd did not return treestate")
+	}
+	treeBytes, err := hex.DecodeString(reply.Sapling.Commitments.FinalState)
+	if err != nil {
+		return nil, err
+	}
+	return sapling.ParseTree(treeBytes)
+}
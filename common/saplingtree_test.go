@@ -0,0 +1,337 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/common/sapling"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+func TestSaplingTree(t *testing.T) {
+	const start = 1000
+	saveBlocks, saveChain := saplingTreeCheckpointBlocks, Chain
+	defer func() {
+		saplingTreeCheckpointBlocks, Chain = saveBlocks, saveChain
+	}()
+	saplingTreeCheckpointBlocks = 3
+	Chain = NewStubBackend("main", start)
+
+	// Block i has two outputs (any canonical field elements will do as
+	// their note commitments), except every third block has none.
+	cmu := func(height, i, fork int) []byte {
+		return append([]byte{byte(height - start), byte(i), byte(fork)}, make([]byte, 29)...)
+	}
+	makeBlock := func(height, fork int) *walletrpc.CompactBlock {
+		block := &walletrpc.CompactBlock{
+			Height:   uint64(height),
+			PrevHash: bytes.Repeat([]byte{byte(height - start - 1), byte(fork)}, 16),
+			Hash:     bytes.Repeat([]byte{byte(height - start), byte(fork)}, 16),
+			Time:     uint32(height),
+		}
+		if height%3 != 0 {
+			block.Vtx = []*walletrpc.CompactTx{{Outputs: []*walletrpc.CompactOutput{
+				{Cmu: cmu(height, 0, fork)},
+				{Cmu: cmu(height, 1, fork)},
+			}}}
+		}
+		return block
+	}
+	// expected[h] is the tree as of the block at height h.
+	expected := map[int]*sapling.Tree{start - 1: sapling.NewTree()}
+	expect := func(height int, block *walletrpc.CompactBlock) {
+		tree := expected[height-1].Copy()
+		if _, _, err := appendCommitments(tree, block); err != nil {
+			t.Fatal(err)
+		}
+		expected[height] = tree
+	}
+	check := func(cache *BlockCache, height int) {
+		treeState := cache.GetTreeState(height)
+		if treeState == nil {
+			t.Fatal("no tree state at height ", height)
+		}
+		block := cache.Get(height)
+		if treeState.Height != uint64(height) || treeState.Time != block.Time ||
+			treeState.Hash != hex.EncodeToString(parser.Reverse(block.Hash)) ||
+			treeState.Tree != hex.EncodeToString(expected[height].Bytes()) {
+			t.Fatal("unexpected tree state at height ", height, ": ", treeState)
+		}
+	}
+
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, start, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCacheFromStore(store, start)
+	if cache.GetTreeState(start) != nil {
+		t.Fatal("tree state without the index")
+	}
+	// The tree as of the blocks cached before the index is set is
+	// computed then.
+	for height := start; height < start+4; height++ {
+		block := makeBlock(height, 0)
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		expect(height, block)
+	}
+	treeIndex, err := OpenSaplingTreeIndex(unitTestPath, unitTestChain, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err != nil {
+		t.Fatal(err)
+	}
+	for height := start + 4; height < start+10; height++ {
+		block := makeBlock(height, 0)
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		expect(height, block)
+	}
+	for height := start; height < start+10; height++ {
+		check(cache, height)
+	}
+	if cache.GetTreeState(start+10) != nil {
+		t.Fatal("tree state of a block not in the cache")
+	}
+
+	// After a reorg, the tree follows the new blocks.
+	cache.Reorg(start + 5)
+	if cache.GetTreeState(start+5) != nil {
+		t.Fatal("tree state of a removed block")
+	}
+	for height := start + 5; height < start+8; height++ {
+		block := makeBlock(height, 1)
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		expect(height, block)
+	}
+	for height := start + 4; height < start+8; height++ {
+		check(cache, height)
+	}
+
+	// The tree is computed from the checkpoints when the index is reopened.
+	cache.Close()
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start)
+	if treeIndex, err = OpenSaplingTreeIndex(unitTestPath, unitTestChain, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err != nil {
+		t.Fatal(err)
+	}
+	for height := start; height < start+8; height++ {
+		check(cache, height)
+	}
+	cache.Close()
+
+	// A cache that begins after Sapling activation starts from zcashd's
+	// tree before its first block.
+	os.RemoveAll(unitTestPath)
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start+5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start+5)
+	if treeIndex, err = OpenSaplingTreeIndex(unitTestPath, unitTestChain, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err == nil {
+		t.Fatal("unexpected success without zcashd's tree state")
+	}
+	treeState := &ZcashdRpcReplyGettreestate{Height: start + 4}
+	treeState.Sapling.Commitments.FinalState = hex.EncodeToString(expected[start+4].Bytes())
+	Chain.(*StubBackend).AddTreeState(treeState)
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err != nil {
+		t.Fatal(err)
+	}
+	for height := start + 5; height < start+8; height++ {
+		if err := cache.Add(height, makeBlock(height, 1)); err != nil {
+			t.Fatal(err)
+		}
+		check(cache, height)
+	}
+	cache.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func TestSubtreeRoots(t *testing.T) {
+	const start = 1000
+	saveDepth, saveChain := saplingSubtreeDepth, Chain
+	defer func() {
+		saplingSubtreeDepth, Chain = saveDepth, saveChain
+	}()
+	// Subtrees of four commitments; every block has three.
+	saplingSubtreeDepth = 2
+	stub := NewStubBackend("main", start)
+	Chain = stub
+
+	makeBlock := func(height, fork int) *walletrpc.CompactBlock {
+		block := &walletrpc.CompactBlock{
+			Height:   uint64(height),
+			PrevHash: bytes.Repeat([]byte{byte(height - start - 1), byte(fork)}, 16),
+			Hash:     bytes.Repeat([]byte{byte(height - start), byte(fork)}, 16),
+			Vtx:      []*walletrpc.CompactTx{{}},
+		}
+		for i := 0; i < 3; i++ {
+			block.Vtx[0].Outputs = append(block.Vtx[0].Outputs, &walletrpc.CompactOutput{
+				Cmu: append([]byte{byte(height - start), byte(i), byte(fork)}, make([]byte, 29)...),
+			})
+		}
+		return block
+	}
+	// expected returns the roots of the subtrees completed by the blocks
+	// (from the first Sapling block), computed separately.
+	expected := func(blocks []*walletrpc.CompactBlock) []*walletrpc.SubtreeRoot {
+		var roots []*walletrpc.SubtreeRoot
+		subtree := sapling.NewTree()
+		for _, block := range blocks {
+			for _, output := range block.Vtx[0].Outputs {
+				subtree.Append(output.Cmu)
+				if subtree.Size() == 4 {
+					roots = append(roots, &walletrpc.SubtreeRoot{
+						RootHash:              subtree.SubtreeRoot(2),
+						CompletingBlockHash:   block.Hash,
+						CompletingBlockHeight: block.Height,
+					})
+					subtree = sapling.NewTree()
+				}
+			}
+		}
+		return roots
+	}
+	check := func(cache *BlockCache, startIndex, max uint32, want []*walletrpc.SubtreeRoot) {
+		roots, err := GetSubtreeRoots(cache, walletrpc.ShieldedProtocol_sapling, startIndex, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roots) != len(want) {
+			t.Fatal("unexpected number of subtree roots from ", startIndex, ": ", len(roots))
+		}
+		for i := range roots {
+			if !proto.Equal(roots[i], want[i]) {
+				t.Fatal("unexpected subtree root ", startIndex+uint32(i), ": ", roots[i])
+			}
+		}
+	}
+
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, start, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCacheFromStore(store, start)
+	treeIndex, err := OpenSaplingTreeIndex(unitTestPath, unitTestChain, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err != nil {
+		t.Fatal(err)
+	}
+	var chain []*walletrpc.CompactBlock
+	for height := start; height < start+10; height++ {
+		block := makeBlock(height, 0)
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	// (The stub has no subtrees, so these come from the cache.)
+	roots := expected(chain)
+	if len(roots) != 7 {
+		t.Fatal("unexpected number of expected roots ", len(roots))
+	}
+	check(cache, 0, 0, roots)
+	check(cache, 2, 3, roots[2:5])
+	check(cache, 7, 0, nil)
+
+	// After a reorg, the roots follow the new blocks.
+	cache.Reorg(start + 5)
+	chain = chain[:5]
+	for height := start + 5; height < start+10; height++ {
+		block := makeBlock(height, 1)
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	roots = expected(chain)
+	check(cache, 0, 0, roots)
+
+	// Orchard roots come from zcashd, with the hashes of cached blocks.
+	stub.AddSubtree("orchard", ZcashdRpcReplySubtree{Root: hex.EncodeToString(roots[0].RootHash), EndHeight: int(roots[0].CompletingBlockHeight)})
+	orchardRoots, err := GetSubtreeRoots(cache, walletrpc.ShieldedProtocol_orchard, 0, 0)
+	if err != nil || len(orchardRoots) != 1 || !proto.Equal(orchardRoots[0], roots[0]) {
+		t.Fatal("unexpected Orchard subtree roots ", orchardRoots, err)
+	}
+	cache.Close()
+
+	// A cache that begins after Sapling activation gets the roots of the
+	// subtrees completed before its first block from zcashd, with the
+	// hashes of the blocks that completed them (any will do here).
+	os.RemoveAll(unitTestPath)
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, start+5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, start+5)
+	if treeIndex, err = OpenSaplingTreeIndex(unitTestPath, unitTestChain, true); err != nil {
+		t.Fatal(err)
+	}
+	tree := sapling.NewTree()
+	for _, block := range chain[:5] {
+		for _, output := range block.Vtx[0].Outputs {
+			tree.Append(output.Cmu)
+		}
+	}
+	treeState := &ZcashdRpcReplyGettreestate{Height: start + 4}
+	treeState.Sapling.Commitments.FinalState = hex.EncodeToString(tree.Bytes())
+	stub.AddTreeState(treeState)
+	for i, blockJSON := range blocks[:3] {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		if err := stub.AddBlock(blockData); err != nil {
+			t.Fatal(err)
+		}
+		block := parser.NewBlock()
+		block.ParseFromSlice(blockData)
+		stub.AddSubtree("sapling", ZcashdRpcReplySubtree{
+			Root:      hex.EncodeToString(roots[i].RootHash),
+			EndHeight: block.GetHeight(),
+		})
+		roots[i] = &walletrpc.SubtreeRoot{
+			RootHash:              roots[i].RootHash,
+			CompletingBlockHash:   block.GetEncodableHash(),
+			CompletingBlockHeight: uint64(block.GetHeight()),
+		}
+	}
+	if err := cache.SetSaplingTreeIndex(treeIndex, start); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range chain[5:] {
+		if err := cache.Add(int(block.Height), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// (Those added to the stub after this don't matter.)
+	stub.AddSubtree("sapling", ZcashdRpcReplySubtree{Root: "00", EndHeight: start})
+	check(cache, 0, 0, roots)
+	cache.Close()
+	os.RemoveAll(unitTestPath)
+}
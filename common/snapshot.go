@@ -0,0 +1,292 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// A cache snapshot has a header: the magic string, the format version (4
+// bytes), the chain name and the tip (last block's) hash (each a length
+// byte then the bytes), the first height and the number of blocks (8 bytes
+// each). Then each compact block, marshalled, preceded by its length (4
+// bytes), then the SHA-256 hash of all of the above. The numbers are
+// little-endian.
+const (
+	snapshotMagic   = "lwdcache"
+	snapshotVersion = 1
+)
+
+// A SnapshotHeader describes a cache snapshot.
+type SnapshotHeader struct {
+	ChainName   string
+	TipHash     []byte
+	First, Next int // the range of heights of the blocks
+}
+
+// snapshotWriter writes a snapshot, given its header then its blocks.
+type snapshotWriter struct {
+	w    *bufio.Writer
+	hash hash.Hash
+}
+
+func newSnapshotWriter(w io.Writer, header *SnapshotHeader) (*snapshotWriter, error) {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), hash: sha256.New()}
+	var b bytes.Buffer
+	b.WriteString(snapshotMagic)
+	binary.Write(&b, binary.LittleEndian, uint32(snapshotVersion))
+	for _, field := range [][]byte{[]byte(header.ChainName), header.TipHash} {
+		if len(field) > 255 {
+			return nil, errors.New("snapshot header field too long")
+		}
+		b.WriteByte(byte(len(field)))
+		b.Write(field)
+	}
+	binary.Write(&b, binary.LittleEndian, uint64(header.First))
+	binary.Write(&b, binary.LittleEndian, uint64(header.Next-header.First))
+	return sw, sw.write(b.Bytes())
+}
+
+func (sw *snapshotWriter) write(b []byte) error {
+	sw.hash.Write(b)
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// writeBlock writes the next block, marshalled.
+func (sw *snapshotWriter) writeBlock(data []byte) error {
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(data)))
+	if err := sw.write(length); err != nil {
+		return err
+	}
+	return sw.write(data)
+}
+
+// close writes the checksum.
+func (sw *snapshotWriter) close() error {
+	if _, err := sw.w.Write(sw.hash.Sum(nil)); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// ExportCache writes a snapshot of the cache's blocks, using the given kind
+// of store, from height start to end inclusive (zero for the first or last
+// cached block) to w. It checks that the blocks are valid and linked (each
+// block's prev hash is the hash of the one before it).
+func ExportCache(kind, dbPath, chainName string, start, end int, w io.Writer) (*SnapshotHeader, error) {
+	store, err := OpenExistingBlockStore(kind, dbPath, chainName)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	first, next := store.Heights()
+	if start == 0 {
+		start = first
+	}
+	if end == 0 {
+		end = next - 1
+	}
+	if start < first || end >= next || start > end {
+		return nil, errors.Errorf("the cache has heights %d to %d, not %d to %d", first, next-1, start, end)
+	}
+	data, err := store.Read(end)
+	if err != nil {
+		return nil, err
+	}
+	tip, _, problem := checkBlock(end, data)
+	if tip == nil {
+		return nil, errors.Errorf("bad block at height %d: %s", end, problem)
+	}
+	header := &SnapshotHeader{ChainName: chainName, TipHash: tip.Hash, First: start, Next: end + 1}
+	sw, err := newSnapshotWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+	var prevHash []byte
+	err = store.Iterate(start, func(height int, data []byte) bool {
+		if height > end {
+			return false
+		}
+		var block *walletrpc.CompactBlock
+		block, data, problem = checkBlock(height, data)
+		if block != nil && prevHash != nil && !bytes.Equal(block.PrevHash, prevHash) {
+			block, problem = nil, "prev hash isn't the previous block's hash"
+		}
+		if block == nil {
+			err = errors.Errorf("bad block at height %d: %s", height, problem)
+			return false
+		}
+		prevHash = block.Hash
+		err = sw.writeBlock(data)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return header, sw.close()
+}
+
+// snapshotReader reads a snapshot, checking its checksum at the end.
+type snapshotReader struct {
+	r    *bufio.Reader
+	hash hash.Hash
+}
+
+func (sr *snapshotReader) read(b []byte) error {
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return errors.Wrap(err, "snapshot read failed")
+	}
+	sr.hash.Write(b)
+	return nil
+}
+
+func (sr *snapshotReader) readField() ([]byte, error) {
+	length := make([]byte, 1)
+	if err := sr.read(length); err != nil {
+		return nil, err
+	}
+	field := make([]byte, length[0])
+	return field, sr.read(field)
+}
+
+func (sr *snapshotReader) readHeader() (*SnapshotHeader, error) {
+	magic := make([]byte, len(snapshotMagic)+4)
+	if err := sr.read(magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a cache snapshot")
+	}
+	if version := binary.LittleEndian.Uint32(magic[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version %d", version)
+	}
+	chainName, err := sr.readField()
+	if err != nil {
+		return nil, err
+	}
+	tipHash, err := sr.readField()
+	if err != nil {
+		return nil, err
+	}
+	heights := make([]byte, 16)
+	if err := sr.read(heights); err != nil {
+		return nil, err
+	}
+	first := int(binary.LittleEndian.Uint64(heights[:8]))
+	count := int(binary.LittleEndian.Uint64(heights[8:]))
+	if first < 0 || count <= 0 || first+count < first {
+		return nil, errors.New("bad snapshot heights")
+	}
+	return &SnapshotHeader{ChainName: string(chainName), TipHash: tipHash, First: first, Next: first + count}, nil
+}
+
+// readBlock reads the next block.
+func (sr *snapshotReader) readBlock() ([]byte, error) {
+	length := make([]byte, 4)
+	if err := sr.read(length); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(length)
+	if n > maxStoredBlockSize {
+		return nil, errors.Errorf("impossible block length %d in snapshot", n)
+	}
+	data := make([]byte, n)
+	return data, sr.read(data)
+}
+
+// checkSum checks the snapshot's checksum, at the end.
+func (sr *snapshotReader) checkSum() error {
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(sr.r, sum); err != nil {
+		return errors.Wrap(err, "snapshot read failed")
+	}
+	if !bytes.Equal(sum, sr.hash.Sum(nil)) {
+		return errors.New("bad snapshot checksum")
+	}
+	return nil
+}
+
+// ImportCache reads a snapshot (see ExportCache) into a new cache, using
+// the given kind of store, for the snapshot's chain, in dbPath, which
+// mustn't have a cache for that chain. It checks that the blocks are at
+// the right heights and linked, ending at the tip hash, and the checksum,
+// before the cache is put in place. It returns the snapshot's header.
+func ImportCache(kind, dbPath string, r io.Reader) (*SnapshotHeader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), hash: sha256.New()}
+	header, err := sr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	chainPath := filepath.Join(dbPath, header.ChainName)
+	if _, err := os.Stat(chainPath); !os.IsNotExist(err) {
+		return nil, errors.New("there's already a cache in " + chainPath)
+	}
+	importName := header.ChainName + ".import"
+	importPath := filepath.Join(dbPath, importName)
+	store, err := OpenBlockStore(kind, dbPath, importName, header.First, true)
+	if err != nil {
+		return nil, err
+	}
+	err = importBlocks(sr, header, store)
+	if err == nil {
+		err = sr.checkSum()
+	}
+	if err == nil {
+		err = store.Sync()
+	}
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(importPath, chainPath)
+	}
+	if err != nil {
+		os.RemoveAll(importPath)
+		return nil, err
+	}
+	return header, nil
+}
+
+// importBlocks reads the snapshot's blocks into the store.
+func importBlocks(sr *snapshotReader, header *SnapshotHeader, store BlockStore) error {
+	var prevHash []byte
+	for height := header.First; height < header.Next; height++ {
+		data, err := sr.readBlock()
+		if err != nil {
+			return err
+		}
+		block := &walletrpc.CompactBlock{}
+		if err := proto.Unmarshal(data, block); err != nil {
+			return errors.Wrap(err, "bad block in snapshot")
+		}
+		if int(block.Height) != height {
+			return errors.Errorf("snapshot has block %d at height %d", block.Height, height)
+		}
+		if prevHash != nil && !bytes.Equal(block.PrevHash, prevHash) {
+			return errors.Errorf("snapshot block at height %d doesn't follow the one before it", height)
+		}
+		if err := store.Append(height, encodeBlock(data)); err != nil {
+			return err
+		}
+		prevHash = block.Hash
+	}
+	if !bytes.Equal(prevHash, header.TipHash) {
+		return errors.New("snapshot's last block isn't its tip")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	exportPath := filepath.Join(unitTestPath, "export")
+	importPath := filepath.Join(unitTestPath, "import")
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", exportPath, unitTestChain, 289460, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, compact := range compacts {
+		data, _ := proto.Marshal(compact)
+		if err := store.Append(289460+i, encodeBlock(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Sync()
+	store.Close()
+
+	var snapshot bytes.Buffer
+	header, err := ExportCache("flat", exportPath, unitTestChain, 289461, 289464, &snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.First != 289461 || header.Next != 289465 || !bytes.Equal(header.TipHash, compacts[4].Hash) {
+		t.Fatal("unexpected export header ", header)
+	}
+	if _, err := ExportCache("flat", exportPath, unitTestChain, 289461, 289466, &bytes.Buffer{}); err == nil {
+		t.Fatal("export beyond the cache unexpected success")
+	}
+	snapshotData := snapshot.Bytes()
+
+	// Any change to the snapshot is detected, and nothing is imported.
+	for _, offset := range []int{3, 20, 100, len(snapshotData) - 1} {
+		bad := append([]byte{}, snapshotData...)
+		bad[offset]++
+		if _, err := ImportCache("bbolt", importPath, bytes.NewReader(bad)); err == nil {
+			t.Fatal("import of changed snapshot unexpected success, offset ", offset)
+		}
+		if _, err := os.Stat(filepath.Join(importPath, unitTestChain)); !os.IsNotExist(err) {
+			t.Fatal("cache imported from changed snapshot")
+		}
+	}
+	if _, err := ImportCache("bbolt", importPath, bytes.NewReader(snapshotData[:len(snapshotData)-1])); err == nil {
+		t.Fatal("import of truncated snapshot unexpected success")
+	}
+
+	// Blocks that aren't linked are detected, even with a good checksum.
+	var unlinked bytes.Buffer
+	sw, err := newSnapshotWriter(&unlinked, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 5; i++ {
+		block := compacts[i]
+		if i == 3 {
+			block = proto.Clone(block).(*walletrpc.CompactBlock)
+			block.PrevHash = make([]byte, 32)
+		}
+		data, _ := proto.Marshal(block)
+		sw.writeBlock(data)
+	}
+	sw.close()
+	_, err = ImportCache("bbolt", importPath, &unlinked)
+	if err == nil || !strings.Contains(err.Error(), "doesn't follow") {
+		t.Fatal("import of unlinked snapshot unexpected result ", err)
+	}
+
+	header, err = ImportCache("bbolt", importPath, bytes.NewReader(snapshotData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.ChainName != unitTestChain || header.First != 289461 || header.Next != 289465 {
+		t.Fatal("unexpected import header ", header)
+	}
+	store, err = OpenExistingBlockStore("bbolt", importPath, unitTestChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, next := store.Heights(); first != 289461 || next != 289465 {
+		t.Fatal("unexpected imported heights ", first, next)
+	}
+	for i := 1; i < 5; i++ {
+		data, _ := store.Read(289460 + i)
+		if block, _, _ := checkBlock(289460+i, data); block == nil || !proto.Equal(block, compacts[i]) {
+			t.Fatal("unexpected imported block at ", 289460+i)
+		}
+	}
+	store.Close()
+
+	// Only into a new cache.
+	_, err = ImportCache("bbolt", importPath, bytes.NewReader(snapshotData))
+	if err == nil || !strings.Contains(err.Error(), "already a cache") {
+		t.Fatal("import over a cache unexpected result ", err)
+	}
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"github.com/pkg/errors"
+)
+
+// BlockStore is where a BlockCache keeps its blocks: the stored form of
+// each block (see encodeBlock), by height, with no gaps. The BlockCache
+// serializes calls that change the store; reads may be concurrent.
+type BlockStore interface {
+	// Heights returns the range [first, next) of the stored blocks' heights;
+	// first == next if the store is empty.
+	Heights() (first, next int)
+
+	// Append stores the block at the given height, which must be next (any
+	// height if the store is empty).
+	Append(height int, data []byte) error
+
+	// Truncate removes the blocks at the given height and above.
+	Truncate(height int) error
+
+	// Prune removes the blocks below the given height, so the store then
+	// starts at that height (even if it's empty).
+	Prune(height int) error
+
+	// Read returns the block at the given height, which must be stored.
+	Read(height int) ([]byte, error)
+
+	// Iterate calls f with each block from the given height up, in order,
+	// until f returns false or there are no more blocks.
+	Iterate(start int, f func(height int, data []byte) bool) error
+
+	// Sync flushes the changes to stable storage; after a crash, the store
+	// has (at least) the blocks it had at the last Sync, all intact.
+	Sync() error
+
+	// Close releases the store's resources; it's not usable afterward.
+	Close() error
+}
+
+// The bounds of a stored block's size. (A compressed block may be a little
+// smaller than the smallest uncompressed one, 74 bytes, but its two hashes
+// don't compress.)
+const (
+	minStoredBlockSize = 66
+	maxStoredBlockSize = 4 * 1000 * 1000
+)
+
+// A corruptionSaver can keep a copy of its files, when corruption is
+// detected, for post-mortem analysis.
+type corruptionSaver interface {
+	saveCorrupted()
+}
+
+// A checksummedStore checks each block it reads, so one that's read
+// without an error is as it was stored.
+type checksummedStore interface {
+	checksummed()
+}
+
+// CacheStorage is the kind of BlockStore that NewBlockCache uses: "flat"
+// (the lengths and blocks files), "bbolt" (an embedded key-value database)
+// or "memory" (nothing is saved).
+var CacheStorage = "flat"
+
+// OpenBlockStore returns the given kind of store for the chain's blocks in
+// the dbPath directory, emptying it if redownload is true. The startHeight
+// is the height of the first block, if the store doesn't record that.
+func OpenBlockStore(kind, dbPath, chainName string, startHeight int, redownload bool) (BlockStore, error) {
+	switch kind {
+	case "flat":
+		return openFlatFileStore(dbPath, chainName, startHeight, redownload)
+	case "bbolt":
+		return openBoltStore(dbPath, chainName, startHeight, redownload)
+	case "memory":
+		return newMemoryStore(startHeight), nil
+	}
+	return nil, errors.New("unknown cache storage " + kind)
+}
+
+// OpenExistingBlockStore is OpenBlockStore for a store that has blocks
+// (only the flat and bbolt kinds do), starting at whatever height.
+func OpenExistingBlockStore(kind, dbPath, chainName string) (BlockStore, error) {
+	scan, err := scannerFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	// The flat store may need to be told its first height.
+	first, _, err := scan(dbPath, chainName, func(int, []byte, string) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	return OpenBlockStore(kind, dbPath, chainName, first, false)
+}
+
+// A storeScanner calls f with each block in the store in dbPath, in
+// order, or the problem with it, until f returns false, without changing
+// the store. It returns the range of heights of the stored blocks.
+type storeScanner func(dbPath, chainName string, f func(height int, data []byte, problem string) bool) (first, next int, err error)
+
+// scannerFor returns the scanner for the given kind of store.
+func scannerFor(kind string) (storeScanner, error) {
+	switch kind {
+	case "flat":
+		return scanFlatFileStore, nil
+	case "bbolt":
+		return scanBoltStore, nil
+	}
+	return nil, errors.New("can't read cache storage " + kind + " offline")
+}
+
+// memoryStore keeps the blocks in memory, for testing and darkside.
+type memoryStore struct {
+	first  int
+	blocks [][]byte
+}
+
+func newMemoryStore(startHeight int) *memoryStore {
+	return &memoryStore{first: startHeight}
+}
+
+// Heights implements BlockStore.
+func (m *memoryStore) Heights() (int, int) {
+	return m.first, m.first + len(m.blocks)
+}
+
+// Append implements BlockStore.
+func (m *memoryStore) Append(height int, data []byte) error {
+	if len(m.blocks) == 0 {
+		m.first = height
+	}
+	if height != m.first+len(m.blocks) {
+		return errors.Errorf("append at height %d, expecting %d", height, m.first+len(m.blocks))
+	}
+	m.blocks = append(m.blocks, append([]byte{}, data...))
+	return nil
+}
+
+// Truncate implements BlockStore.
+func (m *memoryStore) Truncate(height int) error {
+	if height < m.first {
+		height = m.first
+	}
+	if height < m.first+len(m.blocks) {
+		m.blocks = m.blocks[:height-m.first]
+	}
+	return nil
+}
+
+// Prune implements BlockStore.
+func (m *memoryStore) Prune(height int) error {
+	if height <= m.first {
+		return nil
+	}
+	if height >= m.first+len(m.blocks) {
+		m.blocks = nil
+	} else {
+		// (Copy, so that the pruned blocks can be freed.)
+		m.blocks = append([][]byte{}, m.blocks[height-m.first:]...)
+	}
+	m.first = height
+	return nil
+}
+
+// Read implements BlockStore.
+func (m *memoryStore) Read(height int) ([]byte, error) {
+	if height < m.first || height >= m.first+len(m.blocks) {
+		return nil, errors.Errorf("no block at height %d", height)
+	}
+	return m.blocks[height-m.first], nil
+}
+
+// Iterate implements BlockStore.
+func (m *memoryStore) Iterate(start int, f func(int, []byte) bool) error {
+	if start < m.first {
+		start = m.first
+	}
+	for height := start; height < m.first+len(m.blocks); height++ {
+		if !f(height, m.blocks[height-m.first]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Sync implements BlockStore.
+func (m *memoryStore) Sync() error {
+	return nil
+}
+
+// Close implements BlockStore.
+func (m *memoryStore) Close() error {
+	m.blocks = nil
+	return nil
+}
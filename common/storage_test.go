@@ -0,0 +1,470 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+func testBlockData(height int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("block %d;", height)), 10)
+}
+
+func TestBlockStores(t *testing.T) {
+	for _, kind := range []string{"flat", "bbolt", "memory"} {
+		os.RemoveAll(unitTestPath)
+		s, err := OpenBlockStore(kind, unitTestPath, unitTestChain, 1000, true)
+		if err != nil {
+			t.Fatal(kind, err)
+		}
+		if first, next := s.Heights(); first != 1000 || next != 1000 {
+			t.Fatal(kind, " unexpected heights of empty store ", first, next)
+		}
+		for h := 1000; h < 1010; h++ {
+			if err := s.Append(h, testBlockData(h)); err != nil {
+				t.Fatal(kind, err)
+			}
+		}
+		if err := s.Append(1011, testBlockData(1011)); err == nil {
+			t.Fatal(kind, " append with a gap unexpected success")
+		}
+		if err := s.Truncate(1005); err != nil {
+			t.Fatal(kind, err)
+		}
+		if first, next := s.Heights(); first != 1000 || next != 1005 {
+			t.Fatal(kind, " unexpected heights after truncate ", first, next)
+		}
+		if _, err := s.Read(1005); err == nil {
+			t.Fatal(kind, " read of truncated block unexpected success")
+		}
+		if err := s.Append(1005, testBlockData(1005)); err != nil {
+			t.Fatal(kind, err)
+		}
+		if err := s.Sync(); err != nil {
+			t.Fatal(kind, err)
+		}
+
+		if kind != "memory" {
+			// Simulate a restart.
+			s.Close()
+			s, err = OpenBlockStore(kind, unitTestPath, unitTestChain, 1000, false)
+			if err != nil {
+				t.Fatal(kind, err)
+			}
+		}
+		if first, next := s.Heights(); first != 1000 || next != 1006 {
+			t.Fatal(kind, " unexpected heights ", first, next)
+		}
+		data, err := s.Read(1003)
+		if err != nil || !bytes.Equal(data, testBlockData(1003)) {
+			t.Fatal(kind, " unexpected read ", err)
+		}
+		next := 1002
+		err = s.Iterate(1002, func(height int, data []byte) bool {
+			if height != next || !bytes.Equal(data, testBlockData(height)) {
+				t.Fatal(kind, " unexpected block from iterate at ", height)
+			}
+			next++
+			return height < 1004
+		})
+		if err != nil || next != 1005 {
+			t.Fatal(kind, " unexpected iterate result ", err, next)
+		}
+
+		// Pruning moves the first height, which is kept across a restart.
+		if err := s.Prune(1002); err != nil {
+			t.Fatal(kind, err)
+		}
+		if kind != "memory" {
+			s.Close()
+			s, err = OpenBlockStore(kind, unitTestPath, unitTestChain, 1000, false)
+			if err != nil {
+				t.Fatal(kind, err)
+			}
+		}
+		if first, next := s.Heights(); first != 1002 || next != 1006 {
+			t.Fatal(kind, " unexpected heights after prune ", first, next)
+		}
+		if _, err := s.Read(1001); err == nil {
+			t.Fatal(kind, " read of pruned block unexpected success")
+		}
+		data, err = s.Read(1005)
+		if err != nil || !bytes.Equal(data, testBlockData(1005)) {
+			t.Fatal(kind, " unexpected read after prune ", err)
+		}
+		if err := s.Append(1006, testBlockData(1006)); err != nil {
+			t.Fatal(kind, err)
+		}
+
+		// Emptied, the store can start at a different height.
+		if err := s.Truncate(0); err != nil {
+			t.Fatal(kind, err)
+		}
+		if err := s.Append(2000, testBlockData(2000)); err != nil {
+			t.Fatal(kind, err)
+		}
+		if first, next := s.Heights(); first != 2000 || next != 2001 {
+			t.Fatal(kind, " unexpected heights after restart ", first, next)
+		}
+		s.Close()
+	}
+	os.RemoveAll(unitTestPath)
+}
+
+func TestBlockCacheStores(t *testing.T) {
+	defer func() { CacheStorage = "flat" }()
+	for _, kind := range []string{"memory", "bbolt"} {
+		CacheStorage = kind
+		os.RemoveAll(unitTestPath)
+		c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+		for i, compact := range compacts {
+			if err := c.Add(289460+i, compact); err != nil {
+				t.Fatal(kind, err)
+			}
+		}
+		c.Reorg(289462)
+		if c.GetLatestHeight() != 289461 {
+			t.Fatal(kind, " unexpected latest height ", c.GetLatestHeight())
+		}
+		if !proto.Equal(c.Get(289461), compacts[1]) {
+			t.Fatal(kind, " unexpected block contents")
+		}
+		if data, _ := proto.Marshal(compacts[1]); !bytes.Equal(c.GetMarshalled(289461), data) {
+			t.Fatal(kind, " unexpected marshalled block contents")
+		}
+		if c.GetMarshalled(289462) != nil {
+			t.Fatal(kind, " unexpected marshalled block after reorg")
+		}
+		if kind == "memory" {
+			// The memory store doesn't check its blocks, so the cache does.
+			store := c.store.(*memoryStore)
+			store.blocks[1] = store.blocks[0]
+			c.recent = newBlockLRU(BlockLRUSize)
+			if c.GetMarshalled(289461) != nil {
+				t.Fatal("bad marshalled block returned")
+			}
+		}
+		c.Close()
+	}
+
+	// The bbolt cache is there after a restart.
+	CacheStorage = "bbolt"
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, false)
+	if c.GetNextHeight() != 289462 {
+		t.Fatal("unexpected next height ", c.GetNextHeight())
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+// TestBlockCacheCorruption checks that a block found to be bad when it's
+// read is redownloaded, with the blocks after it, but not those before it.
+func TestBlockCacheCorruption(t *testing.T) {
+	os.RemoveAll(unitTestPath)
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+	for i, compact := range compacts {
+		if err := c.Add(289460+i, compact); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Sync()
+	_, blocksName := dbFileNames(unitTestPath, unitTestChain)
+	f, err := os.OpenFile(blocksName, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("X"), c.store.(*flatFileStore).starts[3]+8)
+	f.Close()
+	c.recent = newBlockLRU(BlockLRUSize)
+	if c.GetMarshalled(289463) != nil {
+		t.Fatal("corrupted block unexpectedly returned")
+	}
+	for i := 0; c.GetNextHeight() != 289463; i++ {
+		if i == 100 {
+			t.Fatal("unexpected next height ", c.GetNextHeight())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !proto.Equal(c.Get(289462), compacts[2]) {
+		t.Fatal("unexpected block contents")
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func TestCacheRetention(t *testing.T) {
+	saveChain := Chain
+	defer func() { CacheStorage, Chain = "flat", saveChain }()
+	// (The Sapling tree size before the first block, for chain metadata)
+	stub := NewStubBackend("main", 289460)
+	treeState := &ZcashdRpcReplyGettreestate{Height: 289460 + 990}
+	treeState.Sapling.Commitments.FinalState = "000000"
+	stub.AddTreeState(treeState)
+	Chain = stub
+	block := func(height int) *walletrpc.CompactBlock {
+		b := proto.Clone(compacts[0]).(*walletrpc.CompactBlock)
+		b.Height = uint64(height)
+		return b
+	}
+	for _, kind := range []string{"memory", "flat"} {
+		CacheStorage = kind
+		os.RemoveAll(unitTestPath)
+		c := NewBlockCache(unitTestPath, unitTestChain, 289460, true)
+
+		// Far behind the tip, the cache starts over near it.
+		c.SetRetention(10, 289460+1000)
+		if c.GetFirstHeight() != 289460+991 || c.GetNextHeight() != 289460+991 {
+			t.Fatal(kind, " unexpected heights ", c.GetFirstHeight(), c.GetNextHeight())
+		}
+		for h := 289460 + 991; h < 289460+1200; h++ {
+			if err := c.Add(h, block(h)); err != nil {
+				t.Fatal(kind, err)
+			}
+			if n := c.GetNextHeight() - c.GetFirstHeight(); n > 10+100 {
+				t.Fatal(kind, " too many blocks in the cache: ", n)
+			}
+		}
+		first := c.GetFirstHeight()
+		if first <= 289460+991 {
+			t.Fatal(kind, " cache not pruned")
+		}
+		if c.Get(first-1) != nil {
+			t.Fatal(kind, " pruned block unexpectedly in the cache")
+		}
+		if c.Get(289460+1199) == nil {
+			t.Fatal(kind, " latest block missing")
+		}
+		c.Close()
+	}
+
+	// The flat store's pruned blocks stay pruned after a restart.
+	CacheStorage = "flat"
+	c := NewBlockCache(unitTestPath, unitTestChain, 289460, false)
+	if c.GetFirstHeight() <= 289460+991 || c.GetNextHeight() != 289460+1200 {
+		t.Fatal("unexpected heights after restart ", c.GetFirstHeight(), c.GetNextHeight())
+	}
+	c.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+func TestFlatFileStoreCorruption(t *testing.T) {
+	os.RemoveAll(unitTestPath)
+	s, err := openFlatFileStore(unitTestPath, unitTestChain, 1000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h := 1000; h < 1003; h++ {
+		if err := s.Append(h, testBlockData(h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A partial entry at the end of the lengths file (an uncommitted block)
+	// is dropped, and isn't corruption.
+	lengthsName, blocksName := dbFileNames(unitTestPath, unitTestChain)
+	f, err := os.OpenFile(lengthsName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2})
+	f.Close()
+	s, err = openFlatFileStore(unitTestPath, unitTestChain, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, next := s.Heights(); first != 1000 || next != 1003 {
+		t.Fatal("unexpected heights ", first, next)
+	}
+	corruptedName := filepath.Join(unitTestPath, unitTestChain, "lengths-corrupted")
+	if _, err := os.Stat(corruptedName); err == nil {
+		t.Fatal("uncommitted partial entry saved as corrupted")
+	}
+
+	// A block with a bad checksum isn't returned.
+	f, err = os.OpenFile(blocksName, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("X"), s.starts[1]+8)
+	f.Close()
+	if _, err := s.Read(1001); err == nil {
+		t.Fatal("read of corrupted block unexpected success")
+	}
+	s.Close()
+
+	// An impossible length of a committed block is corruption.
+	f, err = os.OpenFile(lengthsName, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{1, 0, 0, 0}, 4)
+	f.Close()
+	s, err = openFlatFileStore(unitTestPath, unitTestChain, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, next := s.Heights(); first != 1000 || next != 1001 {
+		t.Fatal("unexpected heights ", first, next)
+	}
+	if _, err := os.Stat(corruptedName); err != nil {
+		t.Fatal("corrupted lengths file not saved")
+	}
+	s.Close()
+	os.RemoveAll(unitTestPath)
+}
+
+// testCrash is raised (by panic) to simulate a crash.
+type testCrash struct{}
+
+// TestFlatFileStoreCrash simulates a crash at each change to the store's
+// files in turn, checking that the store (reopened) then has at least the
+// committed blocks, all intact. Each crash is simulated twice: as a crash of
+// the process, and as a power failure, which also loses the data written to
+// the blocks file since it was last flushed to disk (the worst case).
+func TestFlatFileStoreCrash(t *testing.T) {
+	origWrite, origTruncate, origSync, origRename := fileWrite, fileTruncate, fileSync, fileRename
+	defer func() {
+		fileWrite, fileTruncate, fileSync, fileRename = origWrite, origTruncate, origSync, origRename
+	}()
+	_, blocksName := dbFileNames(unitTestPath, unitTestChain)
+	for i := 2; ; i++ {
+		crashAt, powerFailure := i/2, i%2 == 1
+		os.RemoveAll(unitTestPath)
+		s, err := openFlatFileStore(unitTestPath, unitTestChain, 1000, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := 0
+		crash := func() bool {
+			ops++
+			return ops == crashAt
+		}
+		fileWrite = func(f *os.File, b []byte) (int, error) {
+			if crash() {
+				// Crash part way through the write.
+				origWrite(f, b[:len(b)/2])
+				panic(testCrash{})
+			}
+			return origWrite(f, b)
+		}
+		fileTruncate = func(f *os.File, size int64) error {
+			if crash() {
+				panic(testCrash{})
+			}
+			return origTruncate(f, size)
+		}
+		// The size of each file as of when it was last flushed to disk.
+		synced := make(map[string]int64)
+		fileSync = func(f *os.File) error {
+			if info, err := f.Stat(); err == nil {
+				synced[f.Name()] = info.Size()
+			}
+			return origSync(f)
+		}
+		fileRename = func(oldpath, newpath string) error {
+			if crash() {
+				panic(testCrash{})
+			}
+			synced[newpath] = synced[oldpath]
+			return origRename(oldpath, newpath)
+		}
+
+		// After the crash, the store may start at any of firsts, and must
+		// have the blocks below minNext (those committed, less any that the
+		// operation in progress removes).
+		firsts := []int{1000}
+		minNext := 1000
+		appendBlocks := func(from, to int) {
+			for h := from; h < to; h++ {
+				if err := s.Append(h, testBlockData(h)); err != nil {
+					t.Fatal(crashAt, err)
+				}
+			}
+		}
+		sync := func() {
+			if err := s.Sync(); err != nil {
+				t.Fatal(crashAt, err)
+			}
+			_, minNext = s.Heights()
+		}
+		crashed := func() (crashed bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					if _, ok := r.(testCrash); !ok {
+						panic(r)
+					}
+					crashed = true
+				}
+			}()
+			appendBlocks(1000, 1010)
+			sync()
+			appendBlocks(1010, 1015)
+			minNext = 1008
+			if err := s.Truncate(1008); err != nil {
+				t.Fatal(crashAt, err)
+			}
+			appendBlocks(1008, 1012)
+			sync()
+			firsts = append(firsts, 1004)
+			if err := s.Prune(1004); err != nil {
+				t.Fatal(crashAt, err)
+			}
+			firsts = firsts[1:]
+			appendBlocks(1012, 1014)
+			sync()
+			return false
+		}()
+		fileWrite, fileTruncate, fileSync, fileRename = origWrite, origTruncate, origSync, origRename
+		s.Close()
+		if crashed && powerFailure {
+			if info, err := os.Stat(blocksName); err == nil && info.Size() > synced[blocksName] {
+				os.Truncate(blocksName, synced[blocksName])
+			}
+		}
+		if !crashed {
+			if crashAt < 50 {
+				t.Fatal("too few changes to the files: ", crashAt-1)
+			}
+			break
+		}
+
+		s, err = openFlatFileStore(unitTestPath, unitTestChain, 1000, false)
+		if err != nil {
+			t.Fatal(crashAt, err)
+		}
+		first, next := s.Heights()
+		if first != firsts[0] && first != firsts[len(firsts)-1] {
+			t.Fatal(crashAt, " unexpected first height ", first)
+		}
+		if next < minNext {
+			t.Fatal(crashAt, " committed blocks lost, next height ", next, " expecting ", minNext)
+		}
+		for h := first; h < next; h++ {
+			data, err := s.Read(h)
+			if err != nil || !bytes.Equal(data, testBlockData(h)) {
+				t.Fatal(crashAt, " bad block at height ", h, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(unitTestPath, unitTestChain, "lengths-corrupted")); err == nil {
+			t.Fatal(crashAt, " crash taken for corruption")
+		}
+		if err := s.Append(next, testBlockData(next)); err != nil {
+			t.Fatal(crashAt, err)
+		}
+		s.Close()
+	}
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// StubBackend implements ChainBackend entirely in memory, so lightwalletd
+// (and its tests) can run without a full node. Blocks and transactions
+// are added by the caller; transactions that are sent go to the mempool.
+type StubBackend struct {
+	mutex      sync.Mutex
+	chainName  string
+	upgrades   map[string]Upgradeinfo
+	branchID   string
+	blocks     map[int][]byte
+	tip        int
+	txs        map[string][]byte // key is big-endian txid hex
+	txHeights  map[string]int    // mined transactions only
+	mempool    []string
+	treeStates map[int]*ZcashdRpcReplyGettreestate
+	subtrees   map[string][]ZcashdRpcReplySubtree
+	addrTxids  map[string][]string
+	balances   map[string]int64
+	utxos      map[string][]ZcashdRpcReplyGetaddressutxos
+	sent       [][]byte
+}
+
+// NewStubBackend returns an empty stub chain with the given name ("main",
+// "test" or "regtest") and Sapling activation height.
+func NewStubBackend(chainName string, saplingHeight int) *StubBackend {
+	return &StubBackend{
+		chainName: chainName,
+		upgrades: map[string]Upgradeinfo{
+			"76b809bb": {ActivationHeight: saplingHeight, Status: "active"}, // Sapling
+		},
+		branchID:   "76b809bb",
+		blocks:     make(map[int][]byte),
+		txs:        make(map[string][]byte),
+		txHeights:  make(map[string]int),
+		treeStates: make(map[int]*ZcashdRpcReplyGettreestate),
+		subtrees:   make(map[string][]ZcashdRpcReplySubtree),
+		addrTxids:  make(map[string][]string),
+		balances:   make(map[string]int64),
+		utxos:      make(map[string][]ZcashdRpcReplyGetaddressutxos),
+	}
+}
+
+// AddBlock adds (or replaces) the serialized block at its height, making
+// it the tip, and removes its transactions from the mempool.
+func (s *StubBackend) AddBlock(blockData []byte) error {
+	block := parser.NewBlock()
+	rest, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("extra data after block")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height := block.GetHeight()
+	for h := range s.blocks {
+		if h > height {
+			delete(s.blocks, h)
+		}
+	}
+	s.blocks[height] = blockData
+	s.tip = height
+	for _, tx := range block.Transactions() {
+		txid := hex.EncodeToString(tx.GetDisplayHash())
+		s.txs[txid] = tx.Bytes()
+		s.txHeights[txid] = height
+		s.removeFromMempool(txid)
+	}
+	return nil
+}
+
+// AddTreeState sets the z_gettreestate reply for its height.
+func (s *StubBackend) AddTreeState(treeState *ZcashdRpcReplyGettreestate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.treeStates[treeState.Height] = treeState
+}
+
+// AddSubtree adds the root of the pool's next complete subtree.
+func (s *StubBackend) AddSubtree(pool string, subtree ZcashdRpcReplySubtree) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subtrees[pool] = append(s.subtrees[pool], subtree)
+}
+
+// AddAddressTxid records that the (big-endian hex) txid involves the address.
+func (s *StubBackend) AddAddressTxid(address, txid string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addrTxids[address] = append(s.addrTxids[address], txid)
+}
+
+// AddUtxo adds an unspent output, and its value to the address's balance.
+func (s *StubBackend) AddUtxo(utxo ZcashdRpcReplyGetaddressutxos) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.utxos[utxo.Address] = append(s.utxos[utxo.Address], utxo)
+	s.balances[utxo.Address] += int64(utxo.Satoshis)
+}
+
+// Sent returns the transactions that have been submitted, oldest first.
+func (s *StubBackend) Sent() [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]byte{}, s.sent...)
+}
+
+// Caller should hold s.mutex.
+func (s *StubBackend) removeFromMempool(txid string) {
+	for i, t := range s.mempool {
+		if t == txid {
+			s.mempool = append(s.mempool[:i], s.mempool[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetBlockchainInfo implements ChainBackend.
+func (s *StubBackend) GetBlockchainInfo() (*ZcashdRpcReplyGetblockchaininfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &ZcashdRpcReplyGetblockchaininfo{
+		Chain:           s.chainName,
+		Upgrades:        s.upgrades,
+		Blocks:          s.tip,
+		Consensus:       ConsensusInfo{Nextblock: s.branchID, Chaintip: s.branchID},
+		EstimatedHeight: s.tip,
+	}, nil
+}
+
+// GetInfo implements ChainBackend.
+func (s *StubBackend) GetInfo() (*ZcashdRpcReplyGetinfo, error) {
+	return &ZcashdRpcReplyGetinfo{Build: "stub", Subversion: "/StubBackend/"}, nil
+}
+
+// GetBlock implements ChainBackend.
+func (s *StubBackend) GetBlock(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if height > s.tip {
+		return nil, nil
+	}
+	block, ok := s.blocks[height]
+	if !ok {
+		return nil, errors.New("-8: Block height out of range")
+	}
+	return block, nil
+}
+
+// GetBlocks implements ChainBackend.
+func (s *StubBackend) GetBlocks(heights []int) ([][]byte, error) {
+	blocks := make([][]byte, len(heights))
+	for i, height := range heights {
+		block, err := s.GetBlock(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+	return blocks, nil
+}
+
+// GetRawTransaction implements ChainBackend.
+func (s *StubBackend) GetRawTransaction(txid []byte) ([]byte, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := hex.EncodeToString(parser.Reverse(txid))
+	tx, ok := s.txs[key]
+	if !ok {
+		return nil, 0, errors.New("-5: No such mempool or blockchain transaction")
+	}
+	return tx, s.txHeights[key], nil
+}
+
+// GetRawTransactions implements ChainBackend.
+func (s *StubBackend) GetRawTransactions(txids [][]byte) ([]*walletrpc.RawTransaction, []error) {
+	txs := make([]*walletrpc.RawTransaction, len(txids))
+	errs := make([]error, len(txids))
+	for i, txid := range txids {
+		var txBytes []byte
+		var height int
+		txBytes, height, errs[i] = s.GetRawTransaction(txid)
+		if errs[i] == nil {
+			txs[i] = &walletrpc.RawTransaction{Data: txBytes, Height: uint64(height)}
+		}
+	}
+	return txs, errs
+}
+
+// GetTreeState implements ChainBackend; the block may be specified only
+// by height.
+func (s *StubBackend) GetTreeState(heightOrHash string) (*ZcashdRpcReplyGettreestate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height, err := strconv.Atoi(heightOrHash)
+	if err != nil {
+		return nil, errors.New("-8: stub backend requires a block height")
+	}
+	treeState, ok := s.treeStates[height]
+	if !ok {
+		return nil, errors.New("-8: Block height out of range")
+	}
+	return treeState, nil
+}
+
+// GetSubtreesByIndex implements ChainBackend.
+func (s *StubBackend) GetSubtreesByIndex(pool string, start, limit uint32) (*ZcashdRpcReplyGetsubtreesbyindex, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reply := &ZcashdRpcReplyGetsubtreesbyindex{Pool: pool, StartIndex: start}
+	subtrees := s.subtrees[pool]
+	for i := int(start); i < len(subtrees) && (limit == 0 || i < int(start+limit)); i++ {
+		reply.Subtrees = append(reply.Subtrees, subtrees[i])
+	}
+	return reply, nil
+}
+
+// GetRawMempool implements ChainBackend.
+func (s *StubBackend) GetRawMempool() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.mempool...), nil
+}
+
+// SendRawTransaction implements ChainBackend; the transaction is added to
+// the mempool. Like zcashd's, the reply is the JSON (quoted) txid.
+func (s *StubBackend) SendRawTransaction(txBytes []byte) (string, error) {
+	tx := parser.NewTransaction()
+	rest, err := tx.ParseFromSlice(txBytes)
+	if err != nil || len(rest) != 0 {
+		return "", errors.New("-22: TX decode failed")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	txid := hex.EncodeToString(tx.GetDisplayHash())
+	if _, ok := s.txs[txid]; ok {
+		return "", errors.New("-27: transaction already in block chain")
+	}
+	s.txs[txid] = txBytes
+	s.mempool = append(s.mempool, txid)
+	s.sent = append(s.sent, txBytes)
+	return strconv.Quote(txid), nil
+}
+
+// GetAddressTxids implements ChainBackend.
+func (s *StubBackend) GetAddressTxids(addresses []string, start, end uint64) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	txids := make([]string, 0)
+	for _, address := range addresses {
+		for _, txid := range s.addrTxids[address] {
+			height, ok := s.txHeights[txid]
+			if ok && uint64(height) >= start && uint64(height) <= end {
+				txids = append(txids, txid)
+			}
+		}
+	}
+	sort.Slice(txids, func(i, j int) bool {
+		return s.txHeights[txids[i]] < s.txHeights[txids[j]]
+	})
+	return txids, nil
+}
+
+// GetAddressBalance implements ChainBackend.
+func (s *StubBackend) GetAddressBalance(addresses []string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var balance int64
+	for _, address := range addresses {
+		balance += s.balances[address]
+	}
+	return balance, nil
+}
+
+// GetAddressUtxos implements ChainBackend.
+func (s *StubBackend) GetAddressUtxos(addresses []string) ([]ZcashdRpcReplyGetaddressutxos, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	utxos := make([]ZcashdRpcReplyGetaddressutxos, 0)
+	for _, address := range addresses {
+		utxos = append(utxos, s.utxos[address]...)
+	}
+	return utxos, nil
+}
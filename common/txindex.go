@@ -0,0 +1,48 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// TxIndex keeps the full transactions of the cached blocks, by txid, so
+// that they can be served without zcashd (which needs -txindex for
+// getrawtransaction). The BlockCache keeps it in step with its blocks (see
+// SetTxIndex).
+type TxIndex struct {
+	*blockIndex
+}
+
+// OpenTxIndex opens (or creates) the chain's transaction index in dbPath,
+// emptying it if redownload is set.
+func OpenTxIndex(dbPath, chainName string, redownload bool) (*TxIndex, error) {
+	index, err := openBlockIndex(dbPath, chainName, "txindex.db", 32, redownload)
+	if err != nil {
+		return nil, err
+	}
+	return &TxIndex{index}, nil
+}
+
+// txIndexEntries returns the index entries for the transactions: each one
+// keyed by its (little-endian) txid.
+func txIndexEntries(txs []*parser.Transaction) []indexEntry {
+	entries := make([]indexEntry, len(txs))
+	for i, tx := range txs {
+		entries[i] = indexEntry{key: tx.GetEncodableHash(), value: tx.Bytes()}
+	}
+	return entries
+}
+
+// Get returns the transaction with the given (little-endian) txid and the
+// height of its block, or nil if it isn't in the index.
+func (t *TxIndex) Get(txid []byte) (*walletrpc.RawTransaction, error) {
+	height, txBytes, err := t.get(txid)
+	if height < 0 || err != nil {
+		return nil, err
+	}
+	return &walletrpc.RawTransaction{Data: txBytes, Height: uint64(height)}, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/zcash/lightwalletd/parser"
+)
+
+func TestTxIndex(t *testing.T) {
+	var rawBlocks [][]byte
+	var fullBlocks []*parser.Block
+	for _, blockJSON := range blocks {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			t.Fatal(err)
+		}
+		rawBlocks = append(rawBlocks, blockData)
+		fullBlocks = append(fullBlocks, block)
+	}
+	txid := func(height, i int) []byte {
+		return fullBlocks[height-380640].Transactions()[i].GetEncodableHash()
+	}
+	saveChain := Chain
+	defer func() { Chain = saveChain }()
+	// zcashd doesn't have the transactions (no -txindex).
+	Chain = NewStubBackend("main", 380640)
+
+	os.RemoveAll(unitTestPath)
+	store, err := OpenBlockStore("flat", unitTestPath, unitTestChain, 380640, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCacheFromStore(store, 380640)
+	txIndex, err := OpenTxIndex(unitTestPath, unitTestChain, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetTxIndex(txIndex); err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range fullBlocks {
+		if err := cache.AddBlock(380640+i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, block := range fullBlocks {
+		for _, tx := range block.Transactions() {
+			rawTx, err := GetRawTransaction(cache, tx.GetEncodableHash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rawTx.Data, tx.Bytes()) || rawTx.Height != uint64(380640+i) {
+				t.Fatal("unexpected transaction from the index at height ", 380640+i)
+			}
+		}
+	}
+
+	// A reorg removes the transactions of the removed blocks.
+	cache.Reorg(380642)
+	if _, err := GetRawTransaction(cache, txid(380642, 0)); err == nil {
+		t.Fatal("transaction of a removed block unexpected success")
+	}
+	txs, errs := GetRawTransactions(cache, [][]byte{txid(380640, 0), txid(380643, 0)})
+	if errs[0] != nil || txs[0].Height != 380640 || errs[1] == nil {
+		t.Fatal("unexpected GetRawTransactions result ", txs, errs)
+	}
+
+	// The ones that aren't in the index come from zcashd.
+	stub := NewStubBackend("main", 380640)
+	for _, blockData := range rawBlocks {
+		if err := stub.AddBlock(blockData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Chain = stub
+	txs, errs = GetRawTransactions(cache, [][]byte{txid(380640, 0), txid(380643, 0)})
+	if errs[0] != nil || errs[1] != nil || txs[1].Height != 380643 {
+		t.Fatal("unexpected GetRawTransactions result with zcashd ", txs, errs)
+	}
+
+	// Transactions of blocks that aren't in the cache (here, because it
+	// lost its last block) are removed from the index when it's reopened.
+	cache.AddBlock(380642, fullBlocks[2])
+	cache.Close()
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, 380640, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Truncate(380642)
+	cache = NewBlockCacheFromStore(store, 380640)
+	if txIndex, err = OpenTxIndex(unitTestPath, unitTestChain, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetTxIndex(txIndex); err != nil {
+		t.Fatal(err)
+	}
+	Chain = NewStubBackend("main", 380640)
+	if _, err := GetRawTransaction(cache, txid(380641, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRawTransaction(cache, txid(380642, 0)); err == nil {
+		t.Fatal("transaction of a block not in the cache unexpected success")
+	}
+
+	// The transactions of the latest blocks are found before they're
+	// written, without writing them.
+	cache.Sync()
+	cache.AddBlock(380642, fullBlocks[2])
+	cache.AddBlock(380643, fullBlocks[3])
+	if rawTx, err := GetRawTransaction(cache, txid(380643, 0)); err != nil || rawTx.Height != 380643 {
+		t.Fatal("unexpected pending transaction", rawTx, err)
+	}
+	if len(txIndex.pending) != 2 {
+		t.Fatal("reading shouldn't write the pending entries", len(txIndex.pending))
+	}
+	// A crash loses them, but not the blocks; those blocks are removed
+	// (to be downloaded again) when the cache is reopened.
+	cache.store.Sync()
+	cache.store.Close()
+	txIndex.db.Close()
+	store, err = OpenBlockStore("flat", unitTestPath, unitTestChain, 380640, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewBlockCacheFromStore(store, 380640)
+	if cache.GetNextHeight() != 380644 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	if txIndex, err = OpenTxIndex(unitTestPath, unitTestChain, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetTxIndex(txIndex); err != nil {
+		t.Fatal(err)
+	}
+	if cache.GetNextHeight() != 380642 {
+		t.Fatal("blocks without their transactions should be removed", cache.GetNextHeight())
+	}
+	cache.Close()
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// A CacheProblem is a bad block found by VerifyCache.
+type CacheProblem struct {
+	Height int
+	Reason string
+}
+
+// A CacheReport is the result of VerifyCache.
+type CacheReport struct {
+	First, Next int // the range of heights of the stored blocks
+	Problems    []CacheProblem
+}
+
+// decodeStoredBlock returns the block and its marshalled form, given its
+// stored form, or the problem with it.
+func decodeStoredBlock(b []byte) (*walletrpc.CompactBlock, []byte, string) {
+	if len(b) < minStoredBlockSize || len(b) > maxStoredBlockSize {
+		return nil, nil, fmt.Sprint("impossible length ", len(b))
+	}
+	b, err := decodeBlock(b)
+	if err != nil {
+		return nil, nil, "decode failed: " + err.Error()
+	}
+	block := &walletrpc.CompactBlock{}
+	if err := proto.Unmarshal(b, block); err != nil {
+		return nil, nil, "unmarshal failed: " + err.Error()
+	}
+	return block, b, ""
+}
+
+// checkBlock is decodeStoredBlock, also checking that the block is at the
+// given height.
+func checkBlock(height int, b []byte) (*walletrpc.CompactBlock, []byte, string) {
+	block, data, problem := decodeStoredBlock(b)
+	if block != nil && int(block.Height) != height {
+		return nil, nil, fmt.Sprint("unexpected block height ", block.Height)
+	}
+	return block, data, problem
+}
+
+// VerifyCache checks every block in the cache, using the given kind of
+// store, without changing it: the flat store's lengths and checksums, that
+// there are no gaps, that each block decodes and unmarshals and has the
+// right height, and that each block's prev hash is the hash of the block
+// before it. lightwalletd shouldn't be using the cache meanwhile.
+func VerifyCache(kind, dbPath, chainName string) (*CacheReport, error) {
+	scan, err := scannerFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	report := &CacheReport{}
+	var prevHash []byte
+	report.First, report.Next, err = scan(dbPath, chainName, func(height int, data []byte, problem string) bool {
+		var block *walletrpc.CompactBlock
+		if problem == "" {
+			block, _, problem = checkBlock(height, data)
+		}
+		if block != nil && prevHash != nil && !bytes.Equal(block.PrevHash, prevHash) {
+			problem = "prev hash isn't the previous block's hash"
+		}
+		if problem != "" {
+			report.Problems = append(report.Problems, CacheProblem{Height: height, Reason: problem})
+		}
+		// (The linkage of the block after a bad one can't be checked.)
+		prevHash = nil
+		if block != nil {
+			prevHash = append([]byte{}, block.Hash...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RepairCache repairs the problems that VerifyCache reported. If refetch
+// is false, it truncates the cache at the first problem. Otherwise, it
+// copies the cache to a new one, fetching the bad blocks from zcashd (see
+// Chain) in place of the originals, and replaces the cache with it; if
+// zcashd's block doesn't follow the one before it (there's been a reorg),
+// the cache ends there. lightwalletd shouldn't be using the cache meanwhile.
+func RepairCache(kind, dbPath, chainName string, report *CacheReport, refetch bool) error {
+	if len(report.Problems) == 0 {
+		return nil
+	}
+	store, err := OpenBlockStore(kind, dbPath, chainName, report.First, false)
+	if err != nil {
+		return err
+	}
+	if !refetch {
+		err := store.Truncate(report.Problems[0].Height)
+		if err == nil {
+			err = store.Sync()
+		}
+		if closeErr := store.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	repairName := chainName + ".repair"
+	repairPath := filepath.Join(dbPath, repairName)
+	repaired, err := OpenBlockStore(kind, dbPath, repairName, report.First, true)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	err = copyRepairedBlocks(store, repaired, report)
+	if err == nil {
+		err = repaired.Sync()
+	}
+	store.Close()
+	repaired.Close()
+	if err != nil {
+		os.RemoveAll(repairPath)
+		return err
+	}
+
+	// Replace the cache's files with the repaired ones. (If this is
+	// interrupted, the cache is inconsistent, but VerifyCache reports that.)
+	var names []string
+	switch kind {
+	case "flat":
+		names = []string{"lengths", "blocks", "commit"}
+	case "bbolt":
+		names = []string{"blocks.db"}
+	}
+	for _, name := range names {
+		oldpath, newpath := filepath.Join(repairPath, name), filepath.Join(dbPath, chainName, name)
+		if err := os.Rename(oldpath, newpath); err != nil {
+			return errors.Wrap(err, "rename "+oldpath+" failed")
+		}
+	}
+	return os.RemoveAll(repairPath)
+}
+
+// copyRepairedBlocks appends the stored blocks in the report's range to
+// the repaired store, fetching those that are bad from zcashd.
+func copyRepairedBlocks(store, repaired BlockStore, report *CacheReport) error {
+	var prevHash []byte
+	fetched := 0
+	for height := report.First; height < report.Next; height++ {
+		var block *walletrpc.CompactBlock
+		data, err := store.Read(height)
+		if err == nil {
+			block, _, _ = checkBlock(height, data)
+		}
+		if block == nil || (prevHash != nil && !bytes.Equal(block.PrevHash, prevHash)) {
+			block, err = getBlockFromRPC(height)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprint("fetching block ", height, " failed"))
+			}
+			if block == nil || (prevHash != nil && !bytes.Equal(block.PrevHash, prevHash)) {
+				Log.Warning("the cached blocks before height ", height,
+					" aren't in zcashd's best chain, the cache ends there")
+				break
+			}
+			marshalled, err := proto.Marshal(block)
+			if err != nil {
+				return err
+			}
+			data = encodeBlock(marshalled)
+			fetched++
+		}
+		if err := repaired.Append(height, data); err != nil {
+			return err
+		}
+		prevHash = block.Hash
+	}
+	Log.Info("fetched ", fetched, " blocks from zcashd")
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// writeTestCache stores the test compact blocks (from TestCache), with a
+// garbage block at 289462 and one at 289464 that doesn't follow 289463.
+func writeTestCache(t *testing.T, kind string) {
+	store, err := OpenBlockStore(kind, unitTestPath, unitTestChain, 289460, true)
+	if err != nil {
+		t.Fatal(kind, err)
+	}
+	for i, compact := range compacts {
+		data, _ := proto.Marshal(compact)
+		switch 289460 + i {
+		case 289462:
+			data = make([]byte, 100)
+		case 289464:
+			forked := proto.Clone(compact).(*walletrpc.CompactBlock)
+			forked.PrevHash = make([]byte, 32)
+			data, _ = proto.Marshal(forked)
+		}
+		if err := store.Append(289460+i, data); err != nil {
+			t.Fatal(kind, err)
+		}
+	}
+	store.Sync()
+	store.Close()
+}
+
+func verifyTestCache(t *testing.T, kind string) *CacheReport {
+	report, err := VerifyCache(kind, unitTestPath, unitTestChain)
+	if err != nil {
+		t.Fatal(kind, err)
+	}
+	return report
+}
+
+func problemHeights(report *CacheReport) []int {
+	heights := []int{}
+	for _, p := range report.Problems {
+		heights = append(heights, p.Height)
+	}
+	return heights
+}
+
+func TestVerifyRepairCache(t *testing.T) {
+	type compactTest struct {
+		Full string `json:"full"`
+	}
+	var compactTests []compactTest
+	blockJSON, err := ioutil.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(blockJSON, &compactTests); err != nil {
+		t.Fatal(err)
+	}
+	stub := NewStubBackend("main", 289460)
+	for _, test := range compactTests {
+		blockData, _ := hex.DecodeString(test.Full)
+		if err := stub.AddBlock(blockData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saveChain := Chain
+	defer func() { Chain = saveChain }()
+	Chain = stub
+
+	for _, kind := range []string{"flat", "bbolt"} {
+		os.RemoveAll(unitTestPath)
+		writeTestCache(t, kind)
+		report := verifyTestCache(t, kind)
+		if report.First != 289460 || report.Next != 289466 {
+			t.Fatal(kind, " unexpected heights ", report.First, report.Next)
+		}
+		if h := problemHeights(report); !reflect.DeepEqual(h, []int{289462, 289464}) {
+			t.Fatal(kind, " unexpected problems ", report.Problems)
+		}
+
+		// The bad blocks are replaced by zcashd's, and the others kept.
+		if err := RepairCache(kind, unitTestPath, unitTestChain, report, true); err != nil {
+			t.Fatal(kind, err)
+		}
+		report = verifyTestCache(t, kind)
+		if len(report.Problems) != 0 || report.First != 289460 || report.Next != 289466 {
+			t.Fatal(kind, " unexpected report after refetch ", report)
+		}
+		store, err := OpenBlockStore(kind, unitTestPath, unitTestChain, 289460, false)
+		if err != nil {
+			t.Fatal(kind, err)
+		}
+		for i, compact := range compacts {
+			data, _ := store.Read(289460 + i)
+			// (Blocks refetched from zcashd have no chain metadata.)
+			compact = proto.Clone(compact).(*walletrpc.CompactBlock)
+			compact.ChainMetadata = nil
+			block, _, problem := checkBlock(289460+i, data)
+			if block != nil {
+				block.ChainMetadata = nil
+			}
+			if block == nil || !proto.Equal(block, compact) {
+				t.Fatal(kind, " unexpected block after refetch at ", 289460+i, problem)
+			}
+		}
+		store.Close()
+
+		// Or the cache is truncated at the first bad block.
+		writeTestCache(t, kind)
+		report = verifyTestCache(t, kind)
+		if err := RepairCache(kind, unitTestPath, unitTestChain, report, false); err != nil {
+			t.Fatal(kind, err)
+		}
+		report = verifyTestCache(t, kind)
+		if len(report.Problems) != 0 || report.First != 289460 || report.Next != 289462 {
+			t.Fatal(kind, " unexpected report after truncate ", report)
+		}
+	}
+
+	// A bad checksum in the flat store (with no commit record, so the
+	// first height is the first block's).
+	os.RemoveAll(unitTestPath)
+	s, err := openFlatFileStore(unitTestPath, unitTestChain, 289460, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, compact := range compacts {
+		data, _ := proto.Marshal(compact)
+		if err := s.Append(289460+i, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offset := s.starts[3] + 8
+	s.Close()
+	os.Remove(s.commitName)
+	_, blocksName := dbFileNames(unitTestPath, unitTestChain)
+	f, err := os.OpenFile(blocksName, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("X"), offset)
+	f.Close()
+	report := verifyTestCache(t, "flat")
+	if h := problemHeights(report); report.First != 289460 || !reflect.DeepEqual(h, []int{289463}) {
+		t.Fatal("unexpected report ", report)
+	}
+	if report.Problems[0].Reason != "bad checksum" {
+		t.Fatal("unexpected problem ", report.Problems[0].Reason)
+	}
+	os.RemoveAll(unitTestPath)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// ZebradBackend implements ChainBackend using zebrad's JSON-RPC interface,
+// which implements the subset of zcashd's RPCs that lightwalletd uses, with
+// the differences handled here.
+type ZebradBackend struct {
+	ZcashdBackend
+}
+
+// JSON-RPC "Method not found"; older zebrad releases don't have getinfo.
+const rpcMethodNotFound = -32601
+
+// GetBlockchainInfo implements ChainBackend. zebrad may not estimate the
+// network's height; report its own tip in that case.
+func (z ZebradBackend) GetBlockchainInfo() (*ZcashdRpcReplyGetblockchaininfo, error) {
+	reply, err := z.ZcashdBackend.GetBlockchainInfo()
+	if err != nil {
+		return nil, err
+	}
+	if reply.EstimatedHeight == 0 {
+		reply.EstimatedHeight = reply.Blocks
+	}
+	return reply, nil
+}
+
+// GetInfo implements ChainBackend.
+func (z ZebradBackend) GetInfo() (*ZcashdRpcReplyGetinfo, error) {
+	reply, err := z.ZcashdBackend.GetInfo()
+	if err != nil && rpcErrorCode(err) == rpcMethodNotFound {
+		return &ZcashdRpcReplyGetinfo{Build: "unknown", Subversion: "/Zebra/"}, nil
+	}
+	return reply, err
+}
+
+// GetBlock implements ChainBackend.
+func (ZebradBackend) GetBlock(height int) ([]byte, error) {
+	return getRawBlock(height, zebradBlockNotFound)
+}
+
+// GetBlocks implements ChainBackend.
+func (ZebradBackend) GetBlocks(heights []int) ([][]byte, error) {
+	return getRawBlocks(heights, zebradBlockNotFound)
+}
+
+// zebradBlockNotFound recognizes a height beyond zebrad's tip, reported as
+// either -8 (like zcashd) or, in older releases, -5 "Block not found".
+func zebradBlockNotFound(err error) bool {
+	return zcashdBlockNotFound(err) || strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+// GetRawTransaction implements ChainBackend.
+func (ZebradBackend) GetRawTransaction(txid []byte) ([]byte, int, error) {
+	request := getRawTransactionRequest(txid)
+	result, rpcErr := rawRequest(request.Method, request.Params)
+	return decodeZebradRawTransaction(result, rpcErr)
+}
+
+// GetRawTransactions implements ChainBackend.
+func (ZebradBackend) GetRawTransactions(txids [][]byte) ([]*walletrpc.RawTransaction, []error) {
+	return getRawTransactions(txids, decodeZebradRawTransaction)
+}
+
+// zebrad reports a negative height for mempool transactions; zcashd omits it.
+func decodeZebradRawTransaction(result json.RawMessage, rpcErr error) ([]byte, int, error) {
+	tx, height, err := decodeRawTransaction(result, rpcErr)
+	if height < 0 {
+		height = 0
+	}
+	return tx, height, err
+}
+
+// GetTreeState implements ChainBackend. zebrad always returns the tree
+// state itself (it has no skipHash), so there's only one request.
+func (ZebradBackend) GetTreeState(heightOrHash string) (*ZcashdRpcReplyGettreestate, error) {
+	var reply ZcashdRpcReplyGettreestate
+	if err := request(&reply, "z_gettreestate", heightOrHash); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+// A minimal ZeroMQ (ZMTP 3.0, NULL security) SUB client, just enough to
+// receive zcashd's -zmqpubhashblock and -zmqpubrawtx notifications. See
+// https://rfc.zeromq.org/spec/23/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+
+	// Raw transactions are the largest thing zcashd publishes.
+	zmqMaxFrameSize = 8 * 1024 * 1024
+
+	zmqReconnectDelay = 5 * time.Second
+)
+
+// Set when block notifications are being received, so the ingestor can
+// wait for them rather than only polling.
+var (
+	newBlockChan       = make(chan struct{}, 1)
+	blockNotifyEnabled int32
+)
+
+// NotifyNewBlock wakes the ingestor if it's waiting for the next block.
+func NotifyNewBlock() {
+	select {
+	case newBlockChan <- struct{}{}:
+	default:
+		// The ingestor has a wakeup pending already.
+	}
+}
+
+// waitForBlock returns when a new block is announced or after the polling
+// interval (see Sleep), whichever comes first, or returns true if the
+// ingestor is asked to stop.
+func waitForBlock(d time.Duration) bool {
+	if atomic.LoadInt32(&blockNotifyEnabled) == 0 {
+		return ingestorSleep(d)
+	}
+	slept := make(chan struct{})
+	go func() {
+		Sleep(d)
+		close(slept)
+	}()
+	select {
+	case <-stopIngestorChan:
+		return true
+	case <-newBlockChan:
+	case <-slept:
+	}
+	return false
+}
+
+// ZmqSubscriber receives zcashd's ZMQ notifications from one endpoint,
+// reconnecting as needed, and passes them to the given functions (either
+// may be nil).
+type ZmqSubscriber struct {
+	endpoint string
+	onBlock  func(hash []byte)
+	onTx     func(tx []byte)
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// NewZmqSubscriber returns a subscriber to an endpoint such as
+// "tcp://127.0.0.1:28332"; call Run() to start receiving.
+func NewZmqSubscriber(endpoint string, onBlock func(hash []byte), onTx func(tx []byte)) (*ZmqSubscriber, error) {
+	if !strings.HasPrefix(endpoint, "tcp://") {
+		return nil, fmt.Errorf("unsupported ZMQ endpoint %q, only tcp:// is supported", endpoint)
+	}
+	return &ZmqSubscriber{endpoint: endpoint, onBlock: onBlock, onTx: onTx}, nil
+}
+
+// Run receives notifications until Close() is called; polling continues
+// to find new blocks while it's disconnected.
+func (z *ZmqSubscriber) Run() {
+	if z.onBlock != nil {
+		atomic.StoreInt32(&blockNotifyEnabled, 1)
+	}
+	for {
+		err := z.receive()
+		z.mutex.Lock()
+		closed := z.closed
+		z.mutex.Unlock()
+		if closed {
+			return
+		}
+		Log.WithFields(logrus.Fields{
+			"endpoint": z.endpoint,
+			"error":    err,
+		}).Warn("ZMQ notifications interrupted, reconnecting")
+		time.Sleep(zmqReconnectDelay)
+	}
+}
+
+// Close disconnects and stops Run().
+func (z *ZmqSubscriber) Close() {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.closed = true
+	if z.conn != nil {
+		z.conn.Close()
+	}
+}
+
+func (z *ZmqSubscriber) receive() error {
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(z.endpoint, "tcp://"), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	z.mutex.Lock()
+	if z.closed {
+		z.mutex.Unlock()
+		conn.Close()
+		return nil
+	}
+	z.conn = conn
+	z.mutex.Unlock()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if err := zmqHandshake(conn, r, "SUB"); err != nil {
+		return err
+	}
+	for _, topic := range []string{"hashblock", "rawtx"} {
+		// ZMTP 3.0 subscriptions are messages: 0x01 then the topic.
+		if err := zmqWriteFrame(conn, 0, append([]byte{1}, topic...)); err != nil {
+			return err
+		}
+	}
+	Log.Info("Receiving ZMQ notifications from ", z.endpoint)
+	for {
+		msg, err := zmqReadMessage(r)
+		if err != nil {
+			return err
+		}
+		// zcashd sends topic, body, and a 4-byte sequence number.
+		if len(msg) < 2 {
+			continue
+		}
+		switch string(msg[0]) {
+		case "hashblock":
+			Log.Debug("ZMQ hashblock ", fmt.Sprintf("%x", msg[1]))
+			if z.onBlock != nil {
+				z.onBlock(msg[1])
+			}
+		case "rawtx":
+			if z.onTx != nil {
+				z.onTx(msg[1])
+			}
+		}
+	}
+}
+
+// zmqGreeting is the 64-byte ZMTP 3.0 greeting with the NULL mechanism.
+func zmqGreeting() []byte {
+	g := make([]byte, 64)
+	g[0] = 0xff
+	g[9] = 0x7f
+	g[10] = 3 // version 3.0
+	copy(g[12:32], "NULL")
+	return g
+}
+
+// zmqHandshake exchanges greetings and READY commands with the peer; the
+// NULL mechanism is symmetric, so this works for either end.
+func zmqHandshake(w io.Writer, r *bufio.Reader, socketType string) error {
+	if _, err := w.Write(zmqGreeting()); err != nil {
+		return err
+	}
+	greeting := make([]byte, 64)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return err
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] < 3 {
+		return errors.New("peer is not a ZMTP 3 endpoint")
+	}
+	if mechanism := strings.TrimRight(string(greeting[12:32]), "\x00"); mechanism != "NULL" {
+		return fmt.Errorf("unsupported ZMQ security mechanism %q", mechanism)
+	}
+	ready := []byte("\x05READY\x0bSocket-Type")
+	ready = append(ready, 0, 0, 0, byte(len(socketType)))
+	ready = append(ready, socketType...)
+	if err := zmqWriteFrame(w, zmqFlagCommand, ready); err != nil {
+		return err
+	}
+	flags, body, err := zmqReadFrame(r)
+	if err != nil {
+		return err
+	}
+	if flags&zmqFlagCommand == 0 || len(body) < 1 || len(body) < 1+int(body[0]) {
+		return errors.New("expected a ZMQ READY command")
+	}
+	if name := string(body[1 : 1+body[0]]); name != "READY" {
+		return fmt.Errorf("ZMQ handshake failed: %s %s", name, body[1+body[0]:])
+	}
+	return nil
+}
+
+func zmqWriteFrame(w io.Writer, flags byte, body []byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | zmqFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+	_, err := w.Write(append(header, body...))
+	return err
+}
+
+func zmqReadFrame(r *bufio.Reader) (byte, []byte, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmqFlagLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > zmqMaxFrameSize {
+		return 0, nil, fmt.Errorf("ZMQ frame too large (%d bytes)", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// zmqReadMessage returns the frames of the next message, skipping commands.
+func zmqReadMessage(r *bufio.Reader) ([][]byte, error) {
+	var msg [][]byte
+	for {
+		flags, body, err := zmqReadFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmqFlagCommand != 0 {
+			continue
+		}
+		msg = append(msg, body)
+		if flags&zmqFlagMore == 0 {
+			return msg, nil
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// zmqPublisher stands in for zcashd's ZMQ PUB socket: it accepts one
+// subscriber, checks its subscriptions, and sends the given messages.
+func zmqPublisher(t *testing.T, listener net.Listener, msgs [][][]byte) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Error("accept failed", err)
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if err := zmqHandshake(conn, r, "PUB"); err != nil {
+		t.Error("publisher handshake failed", err)
+		return
+	}
+	for _, topic := range []string{"hashblock", "rawtx"} {
+		msg, err := zmqReadMessage(r)
+		if err != nil || len(msg) != 1 || string(msg[0]) != "\x01"+topic {
+			t.Error("unexpected subscription", msg, err)
+			return
+		}
+	}
+	for _, msg := range msgs {
+		for i, frame := range msg {
+			var flags byte
+			if i < len(msg)-1 {
+				flags = zmqFlagMore
+			}
+			if err := zmqWriteFrame(conn, flags, frame); err != nil {
+				t.Error("publisher write failed", err)
+				return
+			}
+		}
+	}
+	// Wait for the subscriber to disconnect.
+	r.ReadByte()
+}
+
+func TestZmqSubscriber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen failed", err)
+	}
+	defer listener.Close()
+
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	rawtx := bytes.Repeat([]byte{0xcd}, 1000) // needs a long frame
+	seq := []byte{0, 0, 0, 0}
+	go zmqPublisher(t, listener, [][][]byte{
+		{[]byte("hashblock"), hash, seq},
+		{[]byte("rawtx"), rawtx, seq},
+	})
+
+	blockChan := make(chan []byte, 1)
+	txChan := make(chan []byte, 1)
+	subscriber, err := NewZmqSubscriber("tcp://"+listener.Addr().String(),
+		func(hash []byte) {
+			NotifyNewBlock()
+			blockChan <- hash
+		},
+		func(tx []byte) { txChan <- tx })
+	if err != nil {
+		t.Fatal("NewZmqSubscriber failed", err)
+	}
+	defer atomic.StoreInt32(&blockNotifyEnabled, 0)
+	go subscriber.Run()
+	defer subscriber.Close()
+
+	select {
+	case h := <-blockChan:
+		if !bytes.Equal(h, hash) {
+			t.Fatal("unexpected block hash", h)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no hashblock notification")
+	}
+	select {
+	case tx := <-txChan:
+		if !bytes.Equal(tx, rawtx) {
+			t.Fatal("unexpected rawtx")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no rawtx notification")
+	}
+
+	// The ingestor doesn't wait out its polling interval.
+	start := time.Now()
+	waitForBlock(time.Minute)
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("waitForBlock didn't wake on the new block")
+	}
+
+	// Without a new block, it polls after the interval.
+	saveSleep := Sleep
+	slept := make(chan time.Duration, 1)
+	Sleep = func(d time.Duration) { slept <- d }
+	stopped := waitForBlock(time.Minute)
+	Sleep = saveSleep
+	if stopped || <-slept != time.Minute {
+		t.Fatal("waitForBlock didn't wait for the polling interval")
+	}
+
+	if _, err := NewZmqSubscriber("ipc:///tmp/zcashd", nil, nil); err == nil {
+		t.Fatal("NewZmqSubscriber should reject non-tcp endpoints")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package frontend
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+)
+
+// marshalledMessage is a protobuf message that's already marshalled, such
+// as a compact block from the cache; sending it (with SendMsg) sends these
+// bytes as they are, rather than unmarshalling and marshalling it again.
+type marshalledMessage []byte
+
+// codec is gRPC's protobuf codec, except that it sends a marshalledMessage
+// as it is.
+type codec struct {
+	encoding.Codec
+}
+
+func newCodec() codec {
+	return codec{encoding.GetCodec(proto.Name)}
+}
+
+// ServerCodec is the server option that makes a gRPC server use codec for
+// all its requests and replies; other servers and clients in the process
+// keep gRPC's codec. (This version of gRPC has CustomCodec, not
+// ForceServerCodec.)
+func ServerCodec() grpc.ServerOption {
+	return grpc.CustomCodec(newCodec())
+}
+
+// Marshal implements encoding.Codec.
+func (c codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(marshalledMessage); ok {
+		return m, nil
+	}
+	return c.Codec.Marshal(v)
+}
+
+// String implements grpc.Codec.
+func (c codec) String() string {
+	return c.Name()
+}
@@ -11,13 +11,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/common"
+	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
 )
 
 var (
@@ -383,6 +390,52 @@ func TestGetBlock(t *testing.T) {
 	step = 0
 }
 
+type testsubscribe struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	events []*walletrpc.ChainEvent
+	walletrpc.CompactTxStreamer_SubscribeChainEventsServer
+}
+
+func (ts *testsubscribe) Context() context.Context {
+	return ts.ctx
+}
+
+func (ts *testsubscribe) Send(e *walletrpc.ChainEvent) error {
+	ts.events = append(ts.events, e)
+	// Stop after the initial (current tip) event.
+	ts.cancel()
+	return nil
+}
+
+func TestSubscribeChainEvents(t *testing.T) {
+	testT = t
+	common.RawRequest = getblockStub
+	lwd, cache := testsetup()
+
+	block, err := common.GetBlock(cache, 380640)
+	if err != nil {
+		t.Fatal("getBlockFromRPC failed", err)
+	}
+	if err = cache.Add(380640, block); err != nil {
+		t.Fatal("cache.Add failed:", err)
+	}
+	ts := &testsubscribe{}
+	ts.ctx, ts.cancel = context.WithCancel(context.Background())
+	err = lwd.SubscribeChainEvents(&walletrpc.ChainSpec{}, ts)
+	if err != context.Canceled {
+		t.Fatal("SubscribeChainEvents unexpected error:", err)
+	}
+	if len(ts.events) != 1 {
+		t.Fatal("SubscribeChainEvents unexpected event count:", len(ts.events))
+	}
+	e := ts.events[0]
+	if e.Kind != walletrpc.ChainEvent_BLOCK_ADDED || e.Height != 380640 || !bytes.Equal(e.Hash, block.Hash) {
+		t.Fatal("SubscribeChainEvents unexpected initial event:", e)
+	}
+	step = 0
+}
+
 type testgetbrange struct {
 	walletrpc.CompactTxStreamer_GetBlockRangeServer
 }
@@ -395,6 +448,22 @@ func (tg *testgetbrange) Send(cb *walletrpc.CompactBlock) error {
 	return nil
 }
 
+func (tg *testgetbrange) SendMsg(m interface{}) error {
+	// GetBlockRange sends the blocks already marshalled.
+	data, ok := m.(marshalledMessage)
+	if !ok {
+		testT.Fatal("unexpected message type")
+	}
+	block := &walletrpc.CompactBlock{}
+	if err := proto.Unmarshal(data, block); err != nil {
+		testT.Fatal("unmarshal sent block failed ", err)
+	}
+	if block.Height != 380640 {
+		testT.Fatal("unexpected sent block height ", block.Height)
+	}
+	return nil
+}
+
 func TestGetBlockRange(t *testing.T) {
 	testT = t
 	common.RawRequest = getblockStub
@@ -418,6 +487,77 @@ func TestGetBlockRange(t *testing.T) {
 	step = 0
 }
 
+func TestCodec(t *testing.T) {
+	if _, ok := encoding.GetCodec(grpcproto.Name).(codec); ok {
+		t.Fatal("process-wide protobuf codec replaced")
+	}
+	c := newCodec()
+	b := &walletrpc.BlockID{Height: 380640}
+	data, err := c.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := proto.Marshal(b)
+	if !bytes.Equal(data, expected) {
+		t.Fatal("unexpected marshalled message")
+	}
+	data, err = c.Marshal(marshalledMessage(expected))
+	if err != nil || !bytes.Equal(data, expected) {
+		t.Fatal("unexpected marshalled message", err)
+	}
+	b = &walletrpc.BlockID{}
+	if err := c.Unmarshal(data, b); err != nil || b.Height != 380640 {
+		t.Fatal("unexpected unmarshalled message", err)
+	}
+}
+
+// benchmarkBlockRange measures sending the test blocks, getting each one
+// from the cache and marshalling it as gRPC does, with get.
+func benchmarkBlockRange(b *testing.B, get func(*common.BlockCache, int) interface{}) {
+	_, cache := testsetup()
+	defer cache.Close()
+	for i, blockJSON := range blocks {
+		var blockHex string
+		json.Unmarshal(blockJSON, &blockHex)
+		blockData, _ := hex.DecodeString(blockHex)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			b.Fatal(err)
+		}
+		compact := block.ToCompact()
+		compact.Height = uint64(380640 + i)
+		if err := cache.Add(380640+i, compact); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var size int64
+	for height := 380640; height < 380640+len(blocks); height++ {
+		size += int64(len(cache.GetMarshalled(height)))
+	}
+	b.SetBytes(size)
+	c := newCodec()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for height := 380640; height < 380640+len(blocks); height++ {
+			if _, err := c.Marshal(get(cache, height)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBlockRangeUnmarshalled(b *testing.B) {
+	benchmarkBlockRange(b, func(cache *common.BlockCache, height int) interface{} {
+		return cache.Get(height)
+	})
+}
+
+func BenchmarkBlockRangeMarshalled(b *testing.B) {
+	benchmarkBlockRange(b, func(cache *common.BlockCache, height int) interface{} {
+		return marshalledMessage(cache.GetMarshalled(height))
+	})
+}
+
 func TestGetBlockRangeNilArgs(t *testing.T) {
 	lwd, _ := testsetup()
 
@@ -538,6 +678,20 @@ func TestNewZRPCFromConf(t *testing.T) {
 	}
 }
 
+func TestNewZRPCFromBackend(t *testing.T) {
+	_, _, name, err := NewZRPCFromBackend("user:p%40ss@10.0.0.2:8232")
+	if err != nil {
+		t.Fatal("NewZRPCFromBackend failed", err)
+	}
+	if name != "10.0.0.2:8232" {
+		t.Fatal("NewZRPCFromBackend returned unexpected name", name)
+	}
+	_, _, _, err = NewZRPCFromBackend("nonexistent-zcash.conf")
+	if err == nil {
+		t.Fatal("NewZRPCFromBackend unexpected success")
+	}
+}
+
 func TestMempoolFilter(t *testing.T) {
 	txidlist := []string{
 		"2e819d0bab5c819dc7d5f92d1bfb4127ce321daf847f6602",
@@ -592,3 +746,108 @@ func TestMempoolFilter(t *testing.T) {
 	}
 
 }
+
+func TestRefreshMempool(t *testing.T) {
+	saveRawRequest, saveBatchRequest := common.RawRequest, common.RawBatchRequest
+	defer func() {
+		common.RawRequest, common.RawBatchRequest = saveRawRequest, saveBatchRequest
+		mempoolList, mempoolMap = nil, nil
+	}()
+	common.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		return json.RawMessage(`["` + strings.Repeat("ab", 32) + `"]`), nil
+	}
+	txErr := errors.New("-5: No such mempool or blockchain transaction")
+	common.RawBatchRequest = func(requests []common.RPCRequest) ([]common.RPCReply, error) {
+		if txErr == nil {
+			return nil, errors.New("batch test error")
+		}
+		return []common.RPCReply{{Err: txErr}}, nil
+	}
+	mempoolList = []string{"old"}
+	oldMap := map[string]*walletrpc.CompactTx{"old": {}}
+	mempoolMap = &oldMap
+
+	// A transaction that disappeared from the mempool isn't an error.
+	if err := refreshMempool(); err != nil {
+		t.Fatal("refreshMempool failed", err)
+	}
+	if len(mempoolList) != 1 || len(*mempoolMap) != 0 {
+		t.Fatal("unexpected mempool", mempoolList, *mempoolMap)
+	}
+
+	// If fetching the transactions fails, our copy is kept.
+	mempoolList = []string{"old"}
+	mempoolMap = &oldMap
+	txErr = nil
+	if err := refreshMempool(); err == nil {
+		t.Fatal("refreshMempool unexpected success")
+	}
+	if len(mempoolList) != 1 || mempoolList[0] != "old" || mempoolMap != &oldMap {
+		t.Fatal("mempool changed after a failed refresh", mempoolList)
+	}
+}
+
+func TestBatchClient(t *testing.T) {
+	// A stand-in for zcashd's JSON-RPC server, replying in reverse order.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var requests []batchRequest
+		json.NewDecoder(r.Body).Decode(&requests)
+		replies := make([]string, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			if requests[i].Method == "getrawtransaction" {
+				replies = append(replies, fmt.Sprintf(
+					`{"result":null,"error":{"code":-5,"message":"No such mempool or blockchain transaction"},"id":%d}`,
+					requests[i].ID))
+				continue
+			}
+			replies = append(replies, fmt.Sprintf(`{"result":%q,"error":null,"id":%d}`,
+				requests[i].Method, requests[i].ID))
+		}
+		w.Write([]byte("[" + strings.Join(replies, ",") + "]"))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	_, batch, _, err := NewZRPCFromBackend("user:pass@" + host)
+	if err != nil {
+		t.Fatal("NewZRPCFromBackend failed", err)
+	}
+	replies, err := batch.BatchRequest([]common.RPCRequest{
+		{Method: "getblock"},
+		{Method: "getrawtransaction"},
+		{Method: "getinfo"},
+	})
+	if err != nil || len(replies) != 3 {
+		t.Fatal("BatchRequest failed", err)
+	}
+	if string(replies[0].Result) != `"getblock"` || string(replies[2].Result) != `"getinfo"` {
+		t.Fatal("BatchRequest replies out of order", replies)
+	}
+	if replies[1].Err == nil || replies[1].Err.Error() != "-5: No such mempool or blockchain transaction" {
+		t.Fatal("BatchRequest unexpected error reply", replies[1].Err)
+	}
+
+	_, batch, _, _ = NewZRPCFromBackend("user:wrong@" + host)
+	if _, err := batch.BatchRequest([]common.RPCRequest{{Method: "getinfo"}}); err == nil {
+		t.Fatal("BatchRequest should fail with the wrong password")
+	}
+
+	// A zcashd that never replies
+	hung := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer slow.Close()
+	defer close(hung)
+	saveTimeout := batchTimeout
+	defer func() { batchTimeout = saveTimeout }()
+	batchTimeout = 100 * time.Millisecond
+	_, batch, _, _ = NewZRPCFromBackend("user:pass@" + strings.TrimPrefix(slow.URL, "http://"))
+	if _, err := batch.BatchRequest([]common.RPCRequest{{Method: "getinfo"}}); err == nil {
+		t.Fatal("BatchRequest should time out")
+	}
+}
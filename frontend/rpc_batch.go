@@ -0,0 +1,110 @@
+// Copyright (c) 2019-2020 The Zcash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package frontend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/zcash/lightwalletd/common"
+)
+
+// BatchClient sends JSON-RPC batches (arrays of requests) to zcashd over
+// HTTP POST; rpcclient sends only one request per round trip.
+type BatchClient struct {
+	url        string
+	user, pass string
+	httpClient http.Client
+}
+
+// batchTimeout bounds a whole batch round trip, so a hung zcashd can't
+// stall catch-up (or the ingestor) forever; it's generous because a batch
+// may carry many full blocks.
+var batchTimeout = 5 * time.Minute
+
+type batchRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      int               `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type batchReply struct {
+	Result json.RawMessage   `json:"result"`
+	Error  *btcjson.RPCError `json:"error"`
+	ID     int               `json:"id"`
+}
+
+func newBatchClient(connCfg *rpcclient.ConnConfig) *BatchClient {
+	return &BatchClient{
+		url:  "http://" + connCfg.Host,
+		user: connCfg.User,
+		pass: connCfg.Pass,
+		httpClient: http.Client{
+			Timeout: batchTimeout,
+		},
+	}
+}
+
+// BatchRequest implements common.RawBatchRequest.
+func (b *BatchClient) BatchRequest(requests []common.RPCRequest) ([]common.RPCReply, error) {
+	batch := make([]batchRequest, len(requests))
+	for i, r := range requests {
+		params := r.Params
+		if params == nil {
+			params = []json.RawMessage{}
+		}
+		batch[i] = batchRequest{JSONRPC: "1.0", ID: i, Method: r.Method, Params: params}
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", b.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Close = true
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.SetBasicAuth(b.user, b.pass)
+	httpResp, err := b.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var batchReplies []batchReply
+	if err := json.Unmarshal(respBody, &batchReplies); err != nil {
+		return nil, fmt.Errorf("status code: %d, response: %q", httpResp.StatusCode, string(respBody))
+	}
+	replies := make([]common.RPCReply, len(requests))
+	received := make([]bool, len(requests))
+	for _, r := range batchReplies {
+		if r.ID < 0 || r.ID >= len(requests) || received[r.ID] {
+			return nil, fmt.Errorf("unexpected id %d in JSON-RPC batch reply", r.ID)
+		}
+		received[r.ID] = true
+		if r.Error != nil {
+			replies[r.ID].Err = r.Error
+		} else {
+			replies[r.ID].Result = r.Result
+		}
+	}
+	for i := range received {
+		if !received[i] {
+			return nil, fmt.Errorf("no reply to request %d in JSON-RPC batch", i)
+		}
+	}
+	return replies, nil
+}
@@ -6,6 +6,8 @@ package frontend
 
 import (
 	"net"
+	"net/url"
+	"strings"
 
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/pkg/errors"
@@ -24,15 +26,67 @@ func NewZRPCFromConf(confPath interface{}) (*rpcclient.Client, error) {
 
 // NewZRPCFromFlags gets zcashd rpc connection information from provided flags.
 func NewZRPCFromFlags(opts *common.Options) (*rpcclient.Client, error) {
+	return rpcclient.New(connFromFlags(opts), nil)
+}
+
+// ZcashdConnConfig returns the zcashd RPC connection settings, from the
+// flags if they're all given, else from the zcashd configuration file.
+func ZcashdConnConfig(opts *common.Options) (*rpcclient.ConnConfig, error) {
+	if opts.RPCUser != "" && opts.RPCPassword != "" && opts.RPCHost != "" && opts.RPCPort != "" {
+		return connFromFlags(opts), nil
+	}
+	return connFromConf(opts.ZcashConfPath)
+}
+
+// NewZRPCFromConnConfig returns a client for single requests and one for
+// JSON-RPC batches to the zcashd described by connCfg.
+func NewZRPCFromConnConfig(connCfg *rpcclient.ConnConfig) (*rpcclient.Client, *BatchClient, error) {
+	client, err := rpcclient.New(connCfg, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, newBatchClient(connCfg), nil
+}
+
+func connFromFlags(opts *common.Options) *rpcclient.ConnConfig {
 	// Connect to local Zcash RPC server using HTTP POST mode.
-	connCfg := &rpcclient.ConnConfig{
+	return &rpcclient.ConnConfig{
 		Host:         net.JoinHostPort(opts.RPCHost, opts.RPCPort),
 		User:         opts.RPCUser,
 		Pass:         opts.RPCPassword,
 		HTTPPostMode: true, // Zcash only supports HTTP POST mode
 		DisableTLS:   true, // Zcash does not provide TLS by default
 	}
-	return rpcclient.New(connCfg, nil)
+}
+
+// NewZRPCFromBackend connects to one of several zcashd backends, given
+// either as "user:password@host:port" or as the path of its zcash.conf.
+// It also returns a client for JSON-RPC batches to the same backend.
+func NewZRPCFromBackend(backend string) (*rpcclient.Client, *BatchClient, string, error) {
+	connCfg, err := connFromBackend(backend)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	client, err := rpcclient.New(connCfg, nil)
+	return client, newBatchClient(connCfg), connCfg.Host, err
+}
+
+func connFromBackend(backend string) (*rpcclient.ConnConfig, error) {
+	if !strings.Contains(backend, "@") {
+		return connFromConf(backend)
+	}
+	u, err := url.Parse("http://" + backend)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse zcashd backend")
+	}
+	password, _ := u.User.Password()
+	return &rpcclient.ConnConfig{
+		Host:         u.Host,
+		User:         u.User.Username(),
+		Pass:         password,
+		HTTPPostMode: true, // Zcash only supports HTTP POST mode
+		DisableTLS:   true, // Zcash does not provide TLS by default
+	}, nil
 }
 
 // If passed a string, interpret as a path, open and read; if passed
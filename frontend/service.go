@@ -8,19 +8,21 @@ package frontend
 import (
 	"context"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"io"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/zcash/lightwalletd/common"
 	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type lwdStreamer struct {
@@ -67,9 +69,52 @@ func (s *lwdStreamer) GetLatestBlock(ctx context.Context, placeholder *walletrpc
 	return &walletrpc.BlockID{Height: uint64(latestBlock)}, nil
 }
 
+// SubscribeChainEvents is a streaming RPC that sends an event each time a
+// block is added to the cache or the cache is reorged to a different chain.
+// The first event describes the current tip, so the client needn't call
+// GetLatestBlock first.
+func (s *lwdStreamer) SubscribeChainEvents(placeholder *walletrpc.ChainSpec, resp walletrpc.CompactTxStreamer_SubscribeChainEventsServer) error {
+	// Subscribe before looking at the tip so no block can be missed.
+	events, cancel := s.cache.Subscribe()
+	defer cancel()
+
+	if latest := s.cache.GetLatestHeight(); latest != -1 {
+		err := resp.Send(&walletrpc.ChainEvent{
+			Kind:   walletrpc.ChainEvent_BLOCK_ADDED,
+			Height: uint64(latest),
+			Hash:   s.cache.GetLatestHash(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-resp.Context().Done():
+			return resp.Context().Err()
+		case event, ok := <-events:
+			if !ok {
+				if s.cache.SubscriptionsClosed() {
+					return status.Error(codes.Unavailable, "lightwalletd is shutting down")
+				}
+				return errors.New("SubscribeChainEvents client is not keeping up")
+			}
+			if err := resp.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// The number of transactions requested from zcashd in one batch.
+const txidBatchSize = 100
+
 // GetTaddressTxids is a streaming RPC that returns transaction IDs that have
 // the given transparent address (taddr) as either an input or output.
 func (s *lwdStreamer) GetTaddressTxids(addressBlockFilter *walletrpc.TransparentAddressBlockFilter, resp walletrpc.CompactTxStreamer_GetTaddressTxidsServer) error {
+	if common.ZcashdUnavailable() {
+		return common.ErrZcashdUnavailable
+	}
 	if err := checkTaddress(addressBlockFilter.Address); err != nil {
 		return err
 	}
@@ -83,43 +128,35 @@ func (s *lwdStreamer) GetTaddressTxids(addressBlockFilter *walletrpc.Transparent
 	if addressBlockFilter.Range.End == nil {
 		return errors.New("Must specify an end block height")
 	}
-	params := make([]json.RawMessage, 1)
-	request := &common.ZcashdRpcRequestGetaddresstxids{
-		Addresses: []string{addressBlockFilter.Address},
-		Start:     addressBlockFilter.Range.Start.Height,
-		End:       addressBlockFilter.Range.End.Height,
-	}
-	param, err := json.Marshal(request)
+	txids, err := common.Chain.GetAddressTxids([]string{addressBlockFilter.Address},
+		addressBlockFilter.Range.Start.Height, addressBlockFilter.Range.End.Height)
 	if err != nil {
 		return err
 	}
-	params[0] = param
-	result, rpcErr := common.RawRequest("getaddresstxids", params)
 
-	// For some reason, the error responses are not JSON
-	if rpcErr != nil {
-		return rpcErr
-	}
-
-	var txids []string
-	err = json.Unmarshal(result, &txids)
-	if err != nil {
-		return err
-	}
-
-	timeout, cancel := context.WithTimeout(resp.Context(), 30*time.Second)
-	defer cancel()
-
-	for _, txidstr := range txids {
-		txid, _ := hex.DecodeString(txidstr)
-		// Txid is read as a string, which is in big-endian order. But when converting
-		// to bytes, it should be little-endian
-		tx, err := s.GetTransaction(timeout, &walletrpc.TxFilter{Hash: parser.Reverse(txid)})
-		if err != nil {
-			return err
-		}
-		if err = resp.Send(tx); err != nil {
-			return err
+	// Fetch the transactions in batches, each (the ones that aren't in the
+	// tx index) in one round trip to zcashd.
+	for len(txids) > 0 {
+		n := len(txids)
+		if n > txidBatchSize {
+			n = txidBatchSize
+		}
+		batch := make([][]byte, n)
+		for i, txidstr := range txids[:n] {
+			txid, _ := hex.DecodeString(txidstr)
+			// Txid is read as a string, which is in big-endian order. But when converting
+			// to bytes, it should be little-endian
+			batch[i] = parser.Reverse(txid)
+		}
+		txids = txids[n:]
+		txs, errs := common.GetRawTransactions(s.cache, batch)
+		for i, tx := range txs {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if err := resp.Send(tx); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -150,13 +187,14 @@ func (s *lwdStreamer) GetBlock(ctx context.Context, id *walletrpc.BlockID) (*wal
 // (as also returned by GetBlock) from the block height 'start' to height
 // 'end' inclusively.
 func (s *lwdStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
-	blockChan := make(chan *walletrpc.CompactBlock)
+	blockChan := make(chan []byte)
 	errChan := make(chan error)
 	if span.Start == nil || span.End == nil {
 		return errors.New("Must specify start and end heights")
 	}
 
-	go common.GetBlockRange(s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height))
+	// The blocks are sent as they're stored, already marshalled (see codec).
+	go common.GetMarshalledBlockRange(s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height))
 
 	for {
 		select {
@@ -164,7 +202,7 @@ func (s *lwdStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.C
 			// this will also catch context.DeadlineExceeded from the timeout
 			return err
 		case cBlock := <-blockChan:
-			err := resp.Send(cBlock)
+			err := resp.SendMsg(marshalledMessage(cBlock))
 			if err != nil {
 				return err
 			}
@@ -175,49 +213,32 @@ func (s *lwdStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.C
 // GetTreeState returns the note commitment tree state corresponding to the given block.
 // See section 3.7 of the Zcash protocol specification. It returns several other useful
 // values also (even though they can be obtained using GetBlock).
-// The block can be specified by either height or hash.
+// The block can be specified by either height or hash. If the cache keeps the Sapling
+// tree (--sapling-tree), the state of a cached block specified by height comes from it.
 func (s *lwdStreamer) GetTreeState(ctx context.Context, id *walletrpc.BlockID) (*walletrpc.TreeState, error) {
 	if id.Height == 0 && id.Hash == nil {
 		return nil, errors.New("request for unspecified identifier")
 	}
-	// The Zcash z_gettreestate rpc accepts either a block height or block hash
-	params := make([]json.RawMessage, 1)
-	var hashJSON []byte
 	if id.Height > 0 {
-		heightJSON, err := json.Marshal(strconv.Itoa(int(id.Height)))
-		if err != nil {
-			return nil, err
+		if treeState := s.cache.GetTreeState(int(id.Height)); treeState != nil {
+			treeState.Network = s.chainName
+			return treeState, nil
 		}
-		params[0] = heightJSON
+	}
+	if common.ZcashdUnavailable() {
+		return nil, common.ErrZcashdUnavailable
+	}
+	// The Zcash z_gettreestate rpc accepts either a block height or block hash
+	var heightOrHash string
+	if id.Height > 0 {
+		heightOrHash = strconv.Itoa(int(id.Height))
 	} else {
 		// id.Hash is big-endian, keep in big-endian for the rpc
-		hashJSON, err := json.Marshal(hex.EncodeToString(id.Hash))
-		if err != nil {
-			return nil, err
-		}
-		params[0] = hashJSON
+		heightOrHash = hex.EncodeToString(id.Hash)
 	}
-	var gettreestateReply common.ZcashdRpcReplyGettreestate
-	for {
-		result, rpcErr := common.RawRequest("z_gettreestate", params)
-		if rpcErr != nil {
-			return nil, rpcErr
-		}
-		err := json.Unmarshal(result, &gettreestateReply)
-		if err != nil {
-			return nil, err
-		}
-		if gettreestateReply.Sapling.Commitments.FinalState != "" {
-			break
-		}
-		if gettreestateReply.Sapling.SkipHash == "" {
-			break
-		}
-		hashJSON, err = json.Marshal(gettreestateReply.Sapling.SkipHash)
-		if err != nil {
-			return nil, err
-		}
-		params[0] = hashJSON
+	gettreestateReply, err := common.Chain.GetTreeState(heightOrHash)
+	if err != nil {
+		return nil, err
 	}
 	if gettreestateReply.Sapling.Commitments.FinalState == "" {
 		return nil, errors.New("zcashd did not return treestate")
@@ -231,41 +252,32 @@ func (s *lwdStreamer) GetTreeState(ctx context.Context, id *walletrpc.BlockID) (
 	}, nil
 }
 
-// GetTransaction returns the raw transaction bytes that are returned
-// by the zcashd 'getrawtransaction' RPC.
+// GetSubtreeRoots returns the roots of the complete subtrees (of 2^16 note
+// commitments) of the Sapling or Orchard note commitment tree, from the
+// given index, with the blocks that completed them. Sapling roots come from
+// the cache if it keeps the Sapling tree (--sapling-tree), otherwise from
+// zcashd's z_getsubtreesbyindex.
+func (s *lwdStreamer) GetSubtreeRoots(arg *walletrpc.GetSubtreeRootsArg, resp walletrpc.CompactTxStreamer_GetSubtreeRootsServer) error {
+	roots, err := common.GetSubtreeRoots(s.cache, arg.ShieldedProtocol, arg.StartIndex, arg.MaxEntries)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := resp.Send(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTransaction returns the raw transaction bytes, from the tx index if
+// it's there, else as returned by the zcashd 'getrawtransaction' RPC.
 func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.RawTransaction, error) {
 	if txf.Hash != nil {
 		if len(txf.Hash) != 32 {
 			return nil, errors.New("Transaction ID has invalid length")
 		}
-		leHashStringJSON, err := json.Marshal(hex.EncodeToString(parser.Reverse(txf.Hash)))
-		if err != nil {
-			return nil, err
-		}
-		params := []json.RawMessage{
-			leHashStringJSON,
-			json.RawMessage("1"),
-		}
-		result, rpcErr := common.RawRequest("getrawtransaction", params)
-
-		// For some reason, the error responses are not JSON
-		if rpcErr != nil {
-			return nil, rpcErr
-		}
-		// Many other fields are returned, but we need only these two.
-		var txinfo common.ZcashdRpcReplyGetrawtransaction
-		err = json.Unmarshal(result, &txinfo)
-		if err != nil {
-			return nil, err
-		}
-		txBytes, err := hex.DecodeString(txinfo.Hex)
-		if err != nil {
-			return nil, err
-		}
-		return &walletrpc.RawTransaction{
-			Data:   txBytes,
-			Height: uint64(txinfo.Height),
-		}, nil
+		return common.GetRawTransaction(s.cache, txf.Hash)
 	}
 
 	if txf.Block != nil && txf.Block.Hash != nil {
@@ -277,37 +289,39 @@ func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 // GetLightdInfo gets the LightWalletD (this server) info, and includes information
 // it gets from its backend zcashd.
 func (s *lwdStreamer) GetLightdInfo(ctx context.Context, in *walletrpc.Empty) (*walletrpc.LightdInfo, error) {
-	return common.GetLightdInfo()
+	info, err := common.GetLightdInfo()
+	if err == nil && common.ZcashdUnavailable() {
+		// The reply is from before zcashd became unreachable; the cache
+		// may have a more recent height.
+		if latest := s.cache.GetLatestHeight(); latest > int(info.BlockHeight) {
+			info.BlockHeight = uint64(latest)
+		}
+	}
+	return info, err
 }
 
 // SendTransaction forwards raw transaction bytes to a zcashd instance over JSON-RPC
 func (s *lwdStreamer) SendTransaction(ctx context.Context, rawtx *walletrpc.RawTransaction) (*walletrpc.SendResponse, error) {
-	// sendrawtransaction "hexstring" ( allowhighfees )
-	//
-	// Submits raw transaction (binary) to local node and network.
-	//
-	// Result:
-	// "hex"             (string) The transaction hash in hex
-
-	// Construct raw JSON-RPC params
-	params := make([]json.RawMessage, 1)
-	txJSON, err := json.Marshal(hex.EncodeToString(rawtx.Data))
-	if err != nil {
-		return &walletrpc.SendResponse{}, err
+	if common.ZcashdUnavailable() {
+		return nil, common.ErrZcashdUnavailable
 	}
-	params[0] = txJSON
-	result, rpcErr := common.RawRequest("sendrawtransaction", params)
+	result, rpcErr := common.Chain.SendRawTransaction(rawtx.Data)
 
 	var errCode int64
 	var errMsg string
 
 	// For some reason, the error responses are not JSON
 	if rpcErr != nil {
+		if status.Code(rpcErr) == codes.Unavailable {
+			// It didn't reach zcashd.
+			return nil, rpcErr
+		}
 		errParts := strings.SplitN(rpcErr.Error(), ":", 2)
 		if len(errParts) < 2 {
 			return nil, errors.New("SendTransaction couldn't parse error code")
 		}
 		errMsg = strings.TrimSpace(errParts[1])
+		var err error
 		errCode, err = strconv.ParseInt(errParts[0], 10, 32)
 		if err != nil {
 			// This should never happen. We can't panic here, but it's that class of error.
@@ -315,7 +329,7 @@ func (s *lwdStreamer) SendTransaction(ctx context.Context, rawtx *walletrpc.RawT
 			return nil, errors.New("SendTransaction couldn't parse error code")
 		}
 	} else {
-		errMsg = string(result)
+		errMsg = result
 	}
 
 	// TODO these are called Error but they aren't at the moment.
@@ -332,26 +346,14 @@ func getTaddressBalanceZcashdRpc(addressList []string) (*walletrpc.Balance, erro
 			return &walletrpc.Balance{}, err
 		}
 	}
-	params := make([]json.RawMessage, 1)
-	addrList := &common.ZcashdRpcRequestGetaddressbalance{
-		Addresses: addressList,
+	if common.ZcashdUnavailable() {
+		return &walletrpc.Balance{}, common.ErrZcashdUnavailable
 	}
-	param, err := json.Marshal(addrList)
+	balance, err := common.Chain.GetAddressBalance(addressList)
 	if err != nil {
 		return &walletrpc.Balance{}, err
 	}
-	params[0] = param
-
-	result, rpcErr := common.RawRequest("getaddressbalance", params)
-	if rpcErr != nil {
-		return &walletrpc.Balance{}, rpcErr
-	}
-	var balanceReply common.ZcashdRpcReplyGetaddressbalance
-	err = json.Unmarshal(result, &balanceReply)
-	if err != nil {
-		return &walletrpc.Balance{}, err
-	}
-	return &walletrpc.Balance{ValueZat: balanceReply.Balance}, nil
+	return &walletrpc.Balance{ValueZat: balance}, nil
 }
 
 // GetTaddressBalance returns the total balance for a list of taddrs
@@ -380,6 +382,29 @@ func (s *lwdStreamer) GetTaddressBalanceStream(addresses walletrpc.CompactTxStre
 	return nil
 }
 
+// GetNullifierStatus returns whether each of the given nullifiers is in a
+// cached block (its note is spent), and where. If the cache doesn't go back
+// to Sapling activation (--cache-size), a nullifier that isn't in it is an
+// error, since it may be in an older block.
+func (s *lwdStreamer) GetNullifierStatus(nullifiers walletrpc.CompactTxStreamer_GetNullifierStatusServer) error {
+	for {
+		nf, err := nullifiers.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		nfStatus, err := s.cache.GetNullifierStatus(nf)
+		if err != nil {
+			return err
+		}
+		if err := nullifiers.Send(nfStatus); err != nil {
+			return err
+		}
+	}
+}
+
 // Key is 32-byte txid (as a 64-character string), data is pointer to compact tx.
 var mempoolMap *map[string]*walletrpc.CompactTx
 var mempoolList []string
@@ -387,72 +412,155 @@ var mempoolList []string
 // Last time we pulled a copy of the mempool from zcashd.
 var lastMempool time.Time
 
-func (s *lwdStreamer) GetMempoolTx(exclude *walletrpc.Exclude, resp walletrpc.CompactTxStreamer_GetMempoolTxServer) error {
-	if time.Now().Sub(lastMempool).Seconds() >= 2 {
-		lastMempool = time.Now()
-		// Refresh our copy of the mempool.
-		params := make([]json.RawMessage, 0)
-		result, rpcErr := common.RawRequest("getrawmempool", params)
-		if rpcErr != nil {
-			return rpcErr
-		}
-		err := json.Unmarshal(result, &mempoolList)
-		if err != nil {
-			return err
-		}
+// Protects the mempool variables, which are also updated by AddMempoolTx().
+var mempoolMutex sync.Mutex
+
+// parseMempoolTx returns the transaction's txid (big-endian hex) and its
+// entry for the mempool map.
+func parseMempoolTx(txBytes []byte) (string, *walletrpc.CompactTx, error) {
+	tx := parser.NewTransaction()
+	txdata, err := tx.ParseFromSlice(txBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(txdata) > 0 {
+		return "", nil, errors.New("extra data deserializing transaction")
+	}
+	txidstr := hex.EncodeToString(tx.GetDisplayHash())
+	if tx.HasSaplingElements() {
+		return txidstr, tx.ToCompact( /* height */ 0), nil
+	}
+	return txidstr, &walletrpc.CompactTx{}, nil
+}
+
+// AddMempoolTx adds a transaction that zcashd announced (by ZMQ) to our copy
+// of the mempool, so it's seen before the next refresh, without having to
+// fetch it.
+func AddMempoolTx(txBytes []byte) {
+	txidstr, ctx, err := parseMempoolTx(txBytes)
+	if err != nil {
+		common.Log.Warn("error parsing ZMQ rawtx: ", err)
+		return
+	}
+	mempoolMutex.Lock()
+	defer mempoolMutex.Unlock()
+	if mempoolMap == nil {
 		newmempoolMap := make(map[string]*walletrpc.CompactTx)
-		if mempoolMap == nil {
-			mempoolMap = &newmempoolMap
-		}
-		for _, txidstr := range mempoolList {
+		mempoolMap = &newmempoolMap
+	}
+	if _, ok := (*mempoolMap)[txidstr]; ok {
+		return
+	}
+	(*mempoolMap)[txidstr] = ctx
+	mempoolList = append(mempoolList, txidstr)
+}
+
+// ExpireMempool makes the next GetMempoolTx refresh our copy of the mempool
+// from zcashd, which it should after a new block: zcashd also announces the
+// block's transactions (by ZMQ), which AddMempoolTx can't tell from new
+// mempool transactions.
+func ExpireMempool() {
+	mempoolMutex.Lock()
+	defer mempoolMutex.Unlock()
+	lastMempool = time.Time{}
+}
+
+// refreshMempool replaces our copy of the mempool with zcashd's, fetching
+// the transactions that aren't in it. It doesn't hold mempoolMutex while it
+// waits for zcashd, so AddMempoolTx isn't held up; if fetching fails, our
+// copy is left as it is.
+func refreshMempool() error {
+	newmempoolList, err := common.Chain.GetRawMempool()
+	if err != nil {
+		return err
+	}
+	newmempoolMap := make(map[string]*walletrpc.CompactTx)
+	var fetchTxids []string
+	var fetch [][]byte
+	mempoolMutex.Lock()
+	for _, txidstr := range newmempoolList {
+		if mempoolMap != nil {
 			if ctx, ok := (*mempoolMap)[txidstr]; ok {
 				// This ctx has already been fetched, copy pointer to it.
 				newmempoolMap[txidstr] = ctx
 				continue
 			}
-			txidJSON, err := json.Marshal(txidstr)
-			if err != nil {
-				return err
-			}
-			// The "0" is because we only need the raw hex, which is returned as
-			// just a hex string, and not even a json string (with quotes).
-			params := []json.RawMessage{txidJSON, json.RawMessage("0")}
-			result, rpcErr := common.RawRequest("getrawtransaction", params)
-			if rpcErr != nil {
+		}
+		txid, err := hex.DecodeString(txidstr)
+		if err != nil {
+			mempoolMutex.Unlock()
+			return err
+		}
+		fetchTxids = append(fetchTxids, txidstr)
+		fetch = append(fetch, parser.Reverse(txid))
+	}
+	addedFrom := len(mempoolList)
+	mempoolMutex.Unlock()
+
+	// Fetch the new transactions in one batch.
+	txs, errs := common.Chain.GetRawTransactions(fetch)
+	for i, tx := range txs {
+		if errs[i] != nil {
+			if common.TxNotFound(errs[i]) {
 				// Not an error; mempool transactions can disappear
 				continue
 			}
-			// strip the quotes
-			var txStr string
-			err = json.Unmarshal(result, &txStr)
-			if err != nil {
-				return err
-			}
+			return errs[i]
+		}
+		_, ctx, err := parseMempoolTx(tx.Data)
+		if err != nil {
+			return err
+		}
+		newmempoolMap[fetchTxids[i]] = ctx
+	}
 
-			// conver to binary
-			txBytes, err := hex.DecodeString(txStr)
-			if err != nil {
-				return err
-			}
-			tx := parser.NewTransaction()
-			txdata, err := tx.ParseFromSlice(txBytes)
-			if len(txdata) > 0 {
-				return errors.New("extra data deserializing transaction")
-			}
-			newmempoolMap[txidstr] = &walletrpc.CompactTx{}
-			if tx.HasSaplingElements() {
-				newmempoolMap[txidstr] = tx.ToCompact( /* height */ 0)
+	mempoolMutex.Lock()
+	defer mempoolMutex.Unlock()
+	// Keep the transactions AddMempoolTx added meanwhile.
+	if mempoolMap != nil && addedFrom <= len(mempoolList) {
+		for _, txidstr := range mempoolList[addedFrom:] {
+			if _, ok := newmempoolMap[txidstr]; !ok {
+				newmempoolMap[txidstr] = (*mempoolMap)[txidstr]
+				newmempoolList = append(newmempoolList, txidstr)
 			}
 		}
-		mempoolMap = &newmempoolMap
 	}
+	mempoolList = newmempoolList
+	mempoolMap = &newmempoolMap
+	return nil
+}
+
+func (s *lwdStreamer) GetMempoolTx(exclude *walletrpc.Exclude, resp walletrpc.CompactTxStreamer_GetMempoolTxServer) error {
+	if common.ZcashdUnavailable() {
+		// Our copy of the mempool can't be refreshed, so it's misleading.
+		return common.ErrZcashdUnavailable
+	}
+	mempoolMutex.Lock()
+	if time.Now().Sub(lastMempool).Seconds() >= 2 {
+		lastMempool = time.Now()
+		mempoolMutex.Unlock()
+		if err := refreshMempool(); err != nil {
+			return err
+		}
+		mempoolMutex.Lock()
+	}
+	// (AddMempoolTx adds to the map, so it can't be read without the lock.)
+	currentList := append([]string{}, mempoolList...)
+	currentTxs := make(map[string]*walletrpc.CompactTx, len(currentList))
+	if mempoolMap != nil {
+		for _, txid := range currentList {
+			currentTxs[txid] = (*mempoolMap)[txid]
+		}
+	}
+	mempoolMutex.Unlock()
+
 	excludeHex := make([]string, len(exclude.Txid))
 	for i := 0; i < len(exclude.Txid); i++ {
 		excludeHex[i] = hex.EncodeToString(parser.Reverse(exclude.Txid[i]))
 	}
-	for _, txid := range MempoolFilter(mempoolList, excludeHex) {
-		tx := (*mempoolMap)[txid]
-		if len(tx.Hash) > 0 {
+	for _, txid := range MempoolFilter(currentList, excludeHex) {
+		tx := currentTxs[txid]
+		if tx != nil && len(tx.Hash) > 0 {
 			err := resp.Send(tx)
 			if err != nil {
 				return err
@@ -514,21 +622,10 @@ func getAddressUtxos(arg *walletrpc.GetAddressUtxosArg, f func(*walletrpc.GetAdd
 			return err
 		}
 	}
-	params := make([]json.RawMessage, 1)
-	addrList := &common.ZcashdRpcRequestGetaddressutxos{
-		Addresses: arg.Addresses,
-	}
-	param, err := json.Marshal(addrList)
-	if err != nil {
-		return err
-	}
-	params[0] = param
-	result, rpcErr := common.RawRequest("getaddressutxos", params)
-	if rpcErr != nil {
-		return rpcErr
+	if common.ZcashdUnavailable() {
+		return common.ErrZcashdUnavailable
 	}
-	var utxosReply []common.ZcashdRpcReplyGetaddressutxos
-	err = json.Unmarshal(result, &utxosReply)
+	utxosReply, err := common.Chain.GetAddressUtxos(arg.Addresses)
 	if err != nil {
 		return err
 	}
@@ -618,8 +715,10 @@ func (s *DarksideStreamer) Reset(ctx context.Context, ms *walletrpc.DarksideMeta
 	if err != nil {
 		return nil, err
 	}
+	mempoolMutex.Lock()
 	mempoolMap = nil
 	mempoolList = nil
+	mempoolMutex.Unlock()
 	return &walletrpc.Empty{}, nil
 }
 
@@ -105,10 +105,20 @@ func (b *Block) GetPrevHash() []byte {
 	return b.hdr.HashPrevBlock
 }
 
+// GetFinalSaplingRoot returns the root of the Sapling note commitment tree
+// as of the end of the block (little-endian), from its header.
+func (b *Block) GetFinalSaplingRoot() []byte {
+	return b.hdr.HashFinalSaplingRoot
+}
+
+// CompactBlockProtoVersion is the protoVersion of compact blocks whose
+// header field is the full serialized block header (see
+// ToCompactWithHeader); those without it have none (zero).
+const CompactBlockProtoVersion = 1
+
 // ToCompact returns the compact representation of the full block.
 func (b *Block) ToCompact() *walletrpc.CompactBlock {
 	compactBlock := &walletrpc.CompactBlock{
-		//TODO ProtoVersion: 1,
 		Height:   uint64(b.GetHeight()),
 		PrevHash: b.hdr.HashPrevBlock,
 		Hash:     b.GetEncodableHash(),
@@ -126,6 +136,20 @@ func (b *Block) ToCompact() *walletrpc.CompactBlock {
 	return compactBlock
 }
 
+// ToCompactWithHeader is ToCompact, but includes the full block header
+// (as a RawBlockHeader is serialized), so that clients can check its
+// proof of work and merkle roots themselves.
+func (b *Block) ToCompactWithHeader() (*walletrpc.CompactBlock, error) {
+	header, err := b.hdr.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	compactBlock := b.ToCompact()
+	compactBlock.ProtoVersion = CompactBlockProtoVersion
+	compactBlock.Header = header
+	return compactBlock, nil
+}
+
 // ParseFromSlice deserializes a block from the given data stream
 // and returns a slice to the remaining data. The caller should verify
 // there is no remaining data if none is expected.
@@ -270,6 +270,22 @@ func TestCompactBlocks(t *testing.T) {
 			t.Errorf("wrong data for compact testnet block %d\nhave: %s\nwant: %s\n", test.BlockHeight, encodedCompact, test.Compact)
 			break
 		}
+
+		// With the full header, which is the start of the full block.
+		compact, err = block.ToCompactWithHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, _ := hex.DecodeString(test.Full)
+		if compact.ProtoVersion != CompactBlockProtoVersion || len(compact.Header) == 0 ||
+			!bytes.HasPrefix(full, compact.Header) {
+			t.Errorf("wrong header for compact testnet block %d", test.BlockHeight)
+		}
+		hdr := NewBlockHeader()
+		if rest, err := hdr.ParseFromSlice(compact.Header); err != nil || len(rest) != 0 ||
+			!bytes.Equal(hdr.GetEncodableHash(), compact.Hash) {
+			t.Errorf("compact testnet block %d header doesn't parse back", test.BlockHeight)
+		}
 	}
 
 }
@@ -20,6 +20,9 @@ const _ = grpc.SupportPackageIsVersion7
 type CompactTxStreamerClient interface {
 	// Return the height of the tip of the best chain
 	GetLatestBlock(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (*BlockID, error)
+	// Stream chain tip changes (new blocks and reorgs) as they are ingested;
+	// the first event describes the current tip.
+	SubscribeChainEvents(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeChainEventsClient, error)
 	// Return the compact block corresponding to the given block identifier
 	GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error)
 	// Return a list of consecutive compact blocks
@@ -47,8 +50,15 @@ type CompactTxStreamerClient interface {
 	// values also (even though they can be obtained using GetBlock).
 	// The block can be specified by either height or hash.
 	GetTreeState(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*TreeState, error)
+	// Return the roots of the complete subtrees of the note commitment tree,
+	// in order, from the given index, for wallets that sync the tree by
+	// subtree ("spend before sync")
+	GetSubtreeRoots(ctx context.Context, in *GetSubtreeRootsArg, opts ...grpc.CallOption) (CompactTxStreamer_GetSubtreeRootsClient, error)
 	GetAddressUtxos(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (*GetAddressUtxosReplyList, error)
 	GetAddressUtxosStream(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressUtxosStreamClient, error)
+	// Return the status of each of the given nullifiers, in order; requires
+	// lightwalletd --nullifier-index
+	GetNullifierStatus(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetNullifierStatusClient, error)
 	// Return information about this lightwalletd instance and the blockchain
 	GetLightdInfo(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*LightdInfo, error)
 	// Testing-only, requires lightwalletd --ping-very-insecure (do not enable in production)
@@ -72,6 +82,38 @@ func (c *compactTxStreamerClient) GetLatestBlock(ctx context.Context, in *ChainS
 	return out, nil
 }
 
+func (c *compactTxStreamerClient) SubscribeChainEvents(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeChainEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[0], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/SubscribeChainEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerSubscribeChainEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_SubscribeChainEventsClient interface {
+	Recv() (*ChainEvent, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerSubscribeChainEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerSubscribeChainEventsClient) Recv() (*ChainEvent, error) {
+	m := new(ChainEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *compactTxStreamerClient) GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error) {
 	out := new(CompactBlock)
 	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock", in, out, opts...)
@@ -82,7 +124,7 @@ func (c *compactTxStreamerClient) GetBlock(ctx context.Context, in *BlockID, opt
 }
 
 func (c *compactTxStreamerClient) GetBlockRange(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[0], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockRange", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[1], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockRange", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +174,7 @@ func (c *compactTxStreamerClient) SendTransaction(ctx context.Context, in *RawTr
 }
 
 func (c *compactTxStreamerClient) GetTaddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressTxidsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[1], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressTxids", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[2], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressTxids", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +215,7 @@ func (c *compactTxStreamerClient) GetTaddressBalance(ctx context.Context, in *Ad
 }
 
 func (c *compactTxStreamerClient) GetTaddressBalanceStream(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressBalanceStreamClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[2], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalanceStream", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[3], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalanceStream", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +249,7 @@ func (x *compactTxStreamerGetTaddressBalanceStreamClient) CloseAndRecv() (*Balan
 }
 
 func (c *compactTxStreamerClient) GetMempoolTx(ctx context.Context, in *Exclude, opts ...grpc.CallOption) (CompactTxStreamer_GetMempoolTxClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[3], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetMempoolTx", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[4], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetMempoolTx", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -247,6 +289,38 @@ func (c *compactTxStreamerClient) GetTreeState(ctx context.Context, in *BlockID,
 	return out, nil
 }
 
+func (c *compactTxStreamerClient) GetSubtreeRoots(ctx context.Context, in *GetSubtreeRootsArg, opts ...grpc.CallOption) (CompactTxStreamer_GetSubtreeRootsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[5], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetSubtreeRoots", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetSubtreeRootsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetSubtreeRootsClient interface {
+	Recv() (*SubtreeRoot, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetSubtreeRootsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetSubtreeRootsClient) Recv() (*SubtreeRoot, error) {
+	m := new(SubtreeRoot)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *compactTxStreamerClient) GetAddressUtxos(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (*GetAddressUtxosReplyList, error) {
 	out := new(GetAddressUtxosReplyList)
 	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxos", in, out, opts...)
@@ -257,7 +331,7 @@ func (c *compactTxStreamerClient) GetAddressUtxos(ctx context.Context, in *GetAd
 }
 
 func (c *compactTxStreamerClient) GetAddressUtxosStream(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressUtxosStreamClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[4], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxosStream", opts...)
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[6], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxosStream", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -288,6 +362,37 @@ func (x *compactTxStreamerGetAddressUtxosStreamClient) Recv() (*GetAddressUtxosR
 	return m, nil
 }
 
+func (c *compactTxStreamerClient) GetNullifierStatus(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetNullifierStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CompactTxStreamer_ServiceDesc.Streams[7], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetNullifierStatus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetNullifierStatusClient{stream}
+	return x, nil
+}
+
+type CompactTxStreamer_GetNullifierStatusClient interface {
+	Send(*Nullifier) error
+	Recv() (*NullifierStatus, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetNullifierStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetNullifierStatusClient) Send(m *Nullifier) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetNullifierStatusClient) Recv() (*NullifierStatus, error) {
+	m := new(NullifierStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *compactTxStreamerClient) GetLightdInfo(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*LightdInfo, error) {
 	out := new(LightdInfo)
 	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetLightdInfo", in, out, opts...)
@@ -312,6 +417,9 @@ func (c *compactTxStreamerClient) Ping(ctx context.Context, in *Duration, opts .
 type CompactTxStreamerServer interface {
 	// Return the height of the tip of the best chain
 	GetLatestBlock(context.Context, *ChainSpec) (*BlockID, error)
+	// Stream chain tip changes (new blocks and reorgs) as they are ingested;
+	// the first event describes the current tip.
+	SubscribeChainEvents(*ChainSpec, CompactTxStreamer_SubscribeChainEventsServer) error
 	// Return the compact block corresponding to the given block identifier
 	GetBlock(context.Context, *BlockID) (*CompactBlock, error)
 	// Return a list of consecutive compact blocks
@@ -339,8 +447,15 @@ type CompactTxStreamerServer interface {
 	// values also (even though they can be obtained using GetBlock).
 	// The block can be specified by either height or hash.
 	GetTreeState(context.Context, *BlockID) (*TreeState, error)
+	// Return the roots of the complete subtrees of the note commitment tree,
+	// in order, from the given index, for wallets that sync the tree by
+	// subtree ("spend before sync")
+	GetSubtreeRoots(*GetSubtreeRootsArg, CompactTxStreamer_GetSubtreeRootsServer) error
 	GetAddressUtxos(context.Context, *GetAddressUtxosArg) (*GetAddressUtxosReplyList, error)
 	GetAddressUtxosStream(*GetAddressUtxosArg, CompactTxStreamer_GetAddressUtxosStreamServer) error
+	// Return the status of each of the given nullifiers, in order; requires
+	// lightwalletd --nullifier-index
+	GetNullifierStatus(CompactTxStreamer_GetNullifierStatusServer) error
 	// Return information about this lightwalletd instance and the blockchain
 	GetLightdInfo(context.Context, *Empty) (*LightdInfo, error)
 	// Testing-only, requires lightwalletd --ping-very-insecure (do not enable in production)
@@ -355,6 +470,9 @@ type UnimplementedCompactTxStreamerServer struct {
 func (UnimplementedCompactTxStreamerServer) GetLatestBlock(context.Context, *ChainSpec) (*BlockID, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLatestBlock not implemented")
 }
+func (UnimplementedCompactTxStreamerServer) SubscribeChainEvents(*ChainSpec, CompactTxStreamer_SubscribeChainEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeChainEvents not implemented")
+}
 func (UnimplementedCompactTxStreamerServer) GetBlock(context.Context, *BlockID) (*CompactBlock, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlock not implemented")
 }
@@ -382,12 +500,18 @@ func (UnimplementedCompactTxStreamerServer) GetMempoolTx(*Exclude, CompactTxStre
 func (UnimplementedCompactTxStreamerServer) GetTreeState(context.Context, *BlockID) (*TreeState, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetTreeState not implemented")
 }
+func (UnimplementedCompactTxStreamerServer) GetSubtreeRoots(*GetSubtreeRootsArg, CompactTxStreamer_GetSubtreeRootsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetSubtreeRoots not implemented")
+}
 func (UnimplementedCompactTxStreamerServer) GetAddressUtxos(context.Context, *GetAddressUtxosArg) (*GetAddressUtxosReplyList, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAddressUtxos not implemented")
 }
 func (UnimplementedCompactTxStreamerServer) GetAddressUtxosStream(*GetAddressUtxosArg, CompactTxStreamer_GetAddressUtxosStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetAddressUtxosStream not implemented")
 }
+func (UnimplementedCompactTxStreamerServer) GetNullifierStatus(CompactTxStreamer_GetNullifierStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetNullifierStatus not implemented")
+}
 func (UnimplementedCompactTxStreamerServer) GetLightdInfo(context.Context, *Empty) (*LightdInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLightdInfo not implemented")
 }
@@ -425,6 +549,27 @@ func _CompactTxStreamer_GetLatestBlock_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompactTxStreamer_SubscribeChainEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChainSpec)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).SubscribeChainEvents(m, &compactTxStreamerSubscribeChainEventsServer{stream})
+}
+
+type CompactTxStreamer_SubscribeChainEventsServer interface {
+	Send(*ChainEvent) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerSubscribeChainEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerSubscribeChainEventsServer) Send(m *ChainEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _CompactTxStreamer_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(BlockID)
 	if err := dec(in); err != nil {
@@ -604,6 +749,27 @@ func _CompactTxStreamer_GetTreeState_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompactTxStreamer_GetSubtreeRoots_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetSubtreeRootsArg)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetSubtreeRoots(m, &compactTxStreamerGetSubtreeRootsServer{stream})
+}
+
+type CompactTxStreamer_GetSubtreeRootsServer interface {
+	Send(*SubtreeRoot) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetSubtreeRootsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetSubtreeRootsServer) Send(m *SubtreeRoot) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _CompactTxStreamer_GetAddressUtxos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetAddressUtxosArg)
 	if err := dec(in); err != nil {
@@ -643,6 +809,32 @@ func (x *compactTxStreamerGetAddressUtxosStreamServer) Send(m *GetAddressUtxosRe
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CompactTxStreamer_GetNullifierStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CompactTxStreamerServer).GetNullifierStatus(&compactTxStreamerGetNullifierStatusServer{stream})
+}
+
+type CompactTxStreamer_GetNullifierStatusServer interface {
+	Send(*NullifierStatus) error
+	Recv() (*Nullifier, error)
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetNullifierStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetNullifierStatusServer) Send(m *NullifierStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetNullifierStatusServer) Recv() (*Nullifier, error) {
+	m := new(Nullifier)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _CompactTxStreamer_GetLightdInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -724,6 +916,11 @@ var CompactTxStreamer_ServiceDesc = grpc.ServiceDesc{
 		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeChainEvents",
+			Handler:       _CompactTxStreamer_SubscribeChainEvents_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "GetBlockRange",
 			Handler:       _CompactTxStreamer_GetBlockRange_Handler,
@@ -744,11 +941,22 @@ var CompactTxStreamer_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _CompactTxStreamer_GetMempoolTx_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetSubtreeRoots",
+			Handler:       _CompactTxStreamer_GetSubtreeRoots_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "GetAddressUtxosStream",
 			Handler:       _CompactTxStreamer_GetAddressUtxosStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetNullifierStatus",
+			Handler:       _CompactTxStreamer_GetNullifierStatus_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "service.proto",
 }